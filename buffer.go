@@ -131,18 +131,23 @@ func (b *Buffer) Rollback() {
 func (b *Buffer) reserve(need int) {
 	space := len(b.buf) - b.wi
 	if need > space {
-		b.grow(need - space)
+		b.grow(need)
 	}
 }
 
+// grow makes the writable space of the buffer at least 'need' bytes.
 func (b *Buffer) grow(need int) {
-	space := len(b.buf) - b.wi + b.si
-	if space > need {
+	used := b.wi - b.si
+	if len(b.buf)-used >= need {
 		b.wi = copy(b.buf, b.buf[b.si:b.wi])
 		b.ri = b.ri - b.si
 		b.si = 0
 	} else {
-		buf := make([]byte, len(b.buf)*2)
+		size := len(b.buf) * 2
+		if size < used+need {
+			size = used + need
+		}
+		buf := make([]byte, size)
 		b.wi = copy(buf, b.buf[b.si:b.wi])
 		b.ri = b.ri - b.si
 		b.si = 0
@@ -0,0 +1,32 @@
+package net
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferGrow(t *testing.T) {
+	buffer := NewBuffer(4)
+	data := bytes.Repeat([]byte("0123456789"), 3)
+	if n, _ := buffer.Write(data); n != len(data) || !bytes.Equal(buffer.Data(), data) {
+		t.Fatalf("Write() = %d, data %q", n, buffer.Data())
+	}
+
+	// consumed data is compacted before the buffer grows.
+	buffer = NewBuffer(8)
+	buffer.Write([]byte("abcdef"))
+	buffer.DataConsume(4)
+	buffer.Commit()
+	buffer.Write([]byte("ghijk"))
+	if len(buffer.buf) != 8 || string(buffer.Data()) != "efghijk" {
+		t.Errorf("buffer of %d bytes has %q", len(buffer.buf), buffer.Data())
+	}
+
+	// uncommitted data is kept for rollback.
+	buffer.DataConsume(3)
+	buffer.Write([]byte("lmnopqrstu"))
+	buffer.Rollback()
+	if string(buffer.Data()) != "efghijklmnopqrstu" {
+		t.Errorf("Rollback() after grow = %q", buffer.Data())
+	}
+}
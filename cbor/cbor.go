@@ -0,0 +1,134 @@
+// Package cbor implements encoding and decoding of CBOR (RFC 8949) without external dependencies.
+//
+// Go values are mapped to CBOR as follows. Integers are mapped to unsigned or negative integers, []byte and [N]byte to
+// byte strings, strings to text strings, other slices and arrays to arrays, maps and structs to maps, bool and nil to
+// simple values, and floats to floating-point numbers. time.Time is mapped to an epoch-based date/time (tag 1) and Tag to
+// other tagged items.
+//
+// Struct fields are encoded with the field name as the key. The key can be changed by the "cbor" struct tag.
+// The "omitempty" option omits the field if it has an empty value, and the tag "-" skips the field.
+//
+//	Name string `cbor:"name,omitempty"`
+//
+// Decoding accepts both definite and indefinite-length items. When an item is decoded into an interface value,
+// nil, bool, uint64, int64, float64, string, []byte, []interface{}, map[string]interface{}, time.Time, Tag and Simple are
+// used. A map that has non-string keys is decoded into map[interface{}]interface{}.
+//
+// Marshal uses the preferred serialization. MarshalCanonical produces the core deterministic encoding (RFC 8949 section 4.2)
+// in which map keys are sorted and floating-point numbers use the shortest form that preserves the value.
+package cbor
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIncomplete is returned when data ends in the middle of a CBOR item.
+var ErrIncomplete = errors.New("cbor: incomplete item")
+
+// Tag represents a tagged item whose tag number is not handled by the package.
+type Tag struct {
+	Number  uint64
+	Content interface{}
+}
+
+// Simple represents a simple value other than false, true, null and undefined.
+type Simple uint8
+
+const (
+	majorUint     = 0
+	majorNegInt   = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+	aiIndefinite  = 31
+	codeFalse     = 0xf4
+	codeTrue      = 0xf5
+	codeNull      = 0xf6
+	codeUndefined = 0xf7
+	codeFloat16   = 0xf9
+	codeFloat32   = 0xfa
+	codeFloat64   = 0xfb
+	codeBreak     = 0xff
+
+	tagDateTimeString = 0
+	tagEpochDateTime  = 1
+)
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	tagType    = reflect.TypeOf(Tag{})
+	simpleType = reflect.TypeOf(Simple(0))
+)
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// fieldsOf returns the encodable fields of the struct type. Fields of embedded structs are promoted.
+func fieldsOf(typ reflect.Type) []field {
+	if fields, ok := fieldCache.Load(typ); ok {
+		return fields.([]field)
+	}
+
+	var fields []field
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get("cbor")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			for _, f := range fieldsOf(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{name, []int{i}, opts == "omitempty"})
+	}
+
+	fieldCache.Store(typ, fields)
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shanpark/net"
+)
+
+type point struct {
+	X int `cbor:"x"`
+	Y int `cbor:"y,omitempty"`
+}
+
+type record struct {
+	point
+	Name    string            `cbor:"name"`
+	Data    []byte            `cbor:"data"`
+	Values  []float64         `cbor:"values"`
+	Labels  map[string]string `cbor:"labels"`
+	When    time.Time         `cbor:"when"`
+	Tagged  Tag               `cbor:"tagged"`
+	Next    *record           `cbor:"next"`
+	Any     interface{}       `cbor:"any"`
+	Skipped bool              `cbor:"-"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := record{
+		point:  point{X: -1000},
+		Name:   "sensor",
+		Data:   []byte{0, 1, 2},
+		Values: []float64{0.5, 1e300, math.Inf(-1)},
+		Labels: map[string]string{"b": "2", "a": "1"},
+		When:   time.Unix(1500000000, 0),
+		Tagged: Tag{Number: 32, Content: "http://example.com"},
+		Next:   &record{Name: "next", When: time.Unix(1, 0)},
+		Any:    []interface{}{uint64(1), int64(-2), "three", nil, true},
+	}
+
+	for _, marshal := range []func(interface{}) ([]byte, error){Marshal, MarshalCanonical} {
+		data, err := marshal(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out record
+		if err = Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("round trip mismatch\n got: %+v\nwant: %+v", out, in)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		hex   string
+	}{
+		{map[interface{}]interface{}{"aa": 1, 10: 2, "b": 3, -1: 4}, "a40a02200461620362616101"},
+		{1.5, "f93e00"},
+		{100000.0, "fa47c35000"},
+		{1.1, "fb3ff199999999999a"},
+		{math.Inf(1), "f97c00"},
+		{5.960464477539063e-8, "f90001"},
+	}
+
+	for _, test := range tests {
+		data, err := MarshalCanonical(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(data); got != test.hex {
+			t.Errorf("MarshalCanonical(%v) = %s, want %s", test.value, got, test.hex)
+		}
+	}
+}
+
+func TestIndefiniteAndIncomplete(t *testing.T) {
+	// {_ "a": [_ 1, 2], "b": (_ h'0102', h'03')}
+	data, _ := hex.DecodeString("bf61619f0102ff61625f4201024103ffff")
+	for i := 0; i < len(data); i++ {
+		if _, err := Size(data[:i]); err != ErrIncomplete {
+			t.Fatalf("Size(data[:%d]) = %v, want ErrIncomplete", i, err)
+		}
+	}
+
+	var out struct {
+		A []int  `cbor:"a"`
+		B []byte `cbor:"b"`
+	}
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.A, []int{1, 2}) || !bytes.Equal(out.B, []byte{1, 2, 3}) {
+		t.Errorf("unexpected result - %+v", out)
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		data := bytes.Repeat([]byte{0x81}, depth) // array of one item
+		return append(data, codeNull)
+	}
+
+	var out interface{}
+	if err := Unmarshal(nested(100), &out); err != nil {
+		t.Errorf("Unmarshal() of 100 levels = %v", err)
+	}
+	if err := Unmarshal(nested(5<<20), &out); err != errTooDeep {
+		t.Errorf("Unmarshal() into interface = %v, want errTooDeep", err)
+	}
+	var list []interface{}
+	if err := Unmarshal(nested(5<<20), &list); err != errTooDeep {
+		t.Errorf("Unmarshal() into slice = %v, want errTooDeep", err)
+	}
+}
+
+// TestUnhashableKey verifies that a map key that cannot be hashed is an error instead of a panic.
+func TestUnhashableKey(t *testing.T) {
+	data, _ := hex.DecodeString("a1810101") // {[1]: 1}
+	var m map[interface{}]interface{}
+	if err := Unmarshal(data, &m); err == nil || !strings.Contains(err.Error(), "unhashable") {
+		t.Errorf("Unmarshal() into map = %v", err)
+	}
+
+	data, _ = hex.DecodeString("a2db41e1561165692015801538ce2c") // a Tag key whose content is an array
+	var out interface{}
+	if err := Unmarshal(data, &out); err == nil || !strings.Contains(err.Error(), "unhashable") {
+		t.Errorf("Unmarshal() into interface = %v", err)
+	}
+}
+
+func TestCodecMaxItemSize(t *testing.T) {
+	codec := NewCodec(nil)
+	if codec.maxItemSize != DefaultMaxItemSize {
+		t.Errorf("default max item size = %d", codec.maxItemSize)
+	}
+	codec.SetMaxItemSize(16)
+	p, _ := net.NewEmbeddedPipeline(codec)
+
+	if err := p.WriteInbound([]byte{0x63, 'a', 'b', 'c', 0x62, 'd'}); err != nil || p.ReadInbound() != "abc" || !p.IsRollback() {
+		t.Errorf("WriteInbound() = %v, rollback %v", err, p.IsRollback())
+	}
+	if err := p.WriteInbound([]byte{'e'}); err != nil || p.ReadInbound() != "de" {
+		t.Errorf("WriteInbound() of rest = %v", err)
+	}
+	if err := p.WriteInbound(append([]byte{0x58, 32}, make([]byte, 16)...)); err != net.ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long incomplete item = %v", err)
+	}
+	p, _ = net.NewEmbeddedPipeline(codec)
+	if err := p.WriteInbound(append([]byte{0x58, 20}, make([]byte, 20)...)); err != net.ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long item = %v", err)
+	}
+}
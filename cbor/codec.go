@@ -0,0 +1,116 @@
+package cbor
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/shanpark/net"
+)
+
+// DefaultMaxItemSize is the default maximum size of an inbound item.
+const DefaultMaxItemSize = 4 * 1024 * 1024
+
+// Codec is a net.ReadHandler and net.WriteHandler that decodes inbound CBOR items and encodes outbound values.
+// Codec detects incomplete items directly in a *net.Buffer, so it can be placed on the stream directly or after a frame decoder.
+// If a whole item is not received yet, it requests rollback and waits for more data.
+//
+// Decoded values are passed to the next handler, and outbound values are encoded into a *net.Buffer.
+// Outbound *net.Buffer is regarded as already encoded and passed as it is.
+type Codec struct {
+	typ         reflect.Type
+	maxItemSize int
+	canonical   bool
+}
+
+// NewCodec returns a Codec that decodes items into values of the type of prototype.
+// If prototype is a pointer, decoded values are pointers to new values.
+// If prototype is nil, items are decoded into interface values.
+func NewCodec(prototype interface{}) *Codec {
+	codec := new(Codec)
+	codec.maxItemSize = DefaultMaxItemSize
+	if prototype != nil {
+		codec.typ = reflect.TypeOf(prototype)
+	}
+	return codec
+}
+
+// SetMaxItemSize sets the maximum size of an inbound item. The default is DefaultMaxItemSize.
+// If size is zero, there is no limit.
+func (c *Codec) SetMaxItemSize(size int) {
+	c.maxItemSize = size
+}
+
+// SetCanonical sets whether outbound values are encoded with the core deterministic encoding.
+func (c *Codec) SetCanonical(canonical bool) {
+	c.canonical = canonical
+}
+
+// OnRead implements net.ReadHandler interface.
+func (c *Codec) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	switch msg := in.(type) {
+	case *net.Buffer:
+		data := msg.Data()
+		size, err := Size(data)
+		if err == ErrIncomplete {
+			if c.maxItemSize > 0 && len(data) > c.maxItemSize {
+				return nil, net.ErrFrameTooLong
+			}
+			ctx.Rollback()
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.maxItemSize > 0 && size > c.maxItemSize {
+			return nil, net.ErrFrameTooLong
+		}
+
+		value, err := c.decode(data[:size])
+		msg.DataConsume(size)
+		return value, err
+	case []byte:
+		return c.decode(msg)
+	default:
+		return nil, fmt.Errorf("cbor: unsupported inbound message type - %T", in)
+	}
+}
+
+// OnWrite implements net.WriteHandler interface.
+func (c *Codec) OnWrite(ctx *net.SoContext, out interface{}) (interface{}, error) {
+	if buffer, ok := out.(*net.Buffer); ok {
+		return buffer, nil
+	}
+
+	var data []byte
+	var err error
+	if c.canonical {
+		data, err = MarshalCanonical(out)
+	} else {
+		data, err = Marshal(out)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := net.NewBuffer(len(data))
+	buffer.Write(data)
+	return buffer, nil
+}
+
+func (c *Codec) decode(data []byte) (interface{}, error) {
+	if c.typ == nil {
+		var value interface{}
+		err := Unmarshal(data, &value)
+		return value, err
+	}
+
+	if c.typ.Kind() == reflect.Ptr {
+		value := reflect.New(c.typ.Elem())
+		err := Unmarshal(data, value.Interface())
+		return value.Interface(), err
+	}
+
+	value := reflect.New(c.typ)
+	err := Unmarshal(data, value.Interface())
+	return value.Elem().Interface(), err
+}
@@ -0,0 +1,632 @@
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+const maxNestingDepth = 512
+
+var (
+	errMalformed = errors.New("cbor: malformed item")
+	errTooDeep   = errors.New("cbor: nesting too deep")
+)
+
+// Unmarshal decodes the CBOR item in data and stores the result in the value pointed to by v.
+// data should contain exactly one item.
+func Unmarshal(data []byte, v interface{}) error {
+	size, err := Size(data)
+	if err != nil {
+		return err
+	}
+	if size != len(data) {
+		return errors.New("cbor: trailing data after item")
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("cbor: Unmarshal() requires a non-nil pointer - %T", v)
+	}
+
+	d := decoder{data: data}
+	return d.decode(rv.Elem())
+}
+
+// Size returns the size of the CBOR item at the start of data.
+// If data ends in the middle of the item, it returns ErrIncomplete.
+// It doesn't decode the item, so it can be used to detect whether a whole item has been received.
+func Size(data []byte) (int, error) {
+	pos := 0
+	stack := []int{1} // number of items remaining at each nesting level, -1 for indefinite-length items.
+	for len(stack) > 0 {
+		top := len(stack) - 1
+		if stack[top] == 0 {
+			stack = stack[:top]
+			continue
+		}
+		if pos >= len(data) {
+			return 0, ErrIncomplete
+		}
+
+		if data[pos] == codeBreak {
+			if stack[top] != -1 {
+				return 0, errMalformed
+			}
+			stack = stack[:top]
+			pos++
+			continue
+		}
+		if stack[top] > 0 {
+			stack[top]--
+		}
+
+		major, ai, arg, n, err := head(data[pos:])
+		if err != nil {
+			return 0, err
+		}
+		pos += n
+		remain := uint64(len(data) - pos) // every item takes at least 1 byte.
+
+		switch major {
+		case majorBytes, majorText:
+			if ai == aiIndefinite {
+				stack = append(stack, -1)
+			} else if arg > remain {
+				return 0, ErrIncomplete
+			} else {
+				pos += int(arg)
+			}
+		case majorArray, majorMap:
+			if ai == aiIndefinite {
+				stack = append(stack, -1)
+				break
+			}
+			if major == majorMap {
+				if arg > remain/2+1 {
+					return 0, ErrIncomplete
+				}
+				arg *= 2
+			}
+			if arg > remain {
+				return 0, ErrIncomplete
+			}
+			stack = append(stack, int(arg))
+		case majorTag:
+			stack = append(stack, 1)
+		}
+	}
+	return pos, nil
+}
+
+// head decodes the initial byte and the argument of an item.
+// For indefinite-length items ai is aiIndefinite, and for floats arg holds the bits of the number.
+func head(data []byte) (major byte, ai byte, arg uint64, n int, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, 0, ErrIncomplete
+	}
+	major = data[0] >> 5
+	ai = data[0] & 0x1f
+
+	switch {
+	case ai < 24:
+		return major, ai, uint64(ai), 1, nil
+	case ai == aiIndefinite:
+		if major < majorBytes || major == majorTag { // major 7 with ai 31 is break that is handled by the caller.
+			return 0, 0, 0, 0, errMalformed
+		}
+		return major, ai, 0, 1, nil
+	case ai > 27:
+		return 0, 0, 0, 0, errMalformed
+	}
+
+	size := 1 << (ai - 24)
+	if len(data) < 1+size {
+		return 0, 0, 0, 0, ErrIncomplete
+	}
+	switch size {
+	case 1:
+		arg = uint64(data[1])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data[1:]))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data[1:]))
+	default:
+		arg = binary.BigEndian.Uint64(data[1:])
+	}
+	return major, ai, arg, 1 + size, nil
+}
+
+// decoder decodes items that are already checked to be complete by Size().
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int // nesting depth of arrays, maps and tags being decoded
+}
+
+func (d *decoder) head() (major byte, ai byte, arg uint64) {
+	major, ai, arg, n, _ := head(d.data[d.pos:])
+	d.pos += n
+	return major, ai, arg
+}
+
+func (d *decoder) skip() error {
+	n, err := Size(d.data[d.pos:])
+	if err != nil {
+		return err
+	}
+	d.pos += n
+	return nil
+}
+
+func (d *decoder) isBreak() bool {
+	if d.data[d.pos] == codeBreak {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *decoder) decode(v reflect.Value) error {
+	if d.depth++; d.depth > maxNestingDepth {
+		return errTooDeep
+	}
+	defer func() { d.depth-- }()
+
+	code := d.data[d.pos]
+	if code == codeNull || code == codeUndefined {
+		d.pos++
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		t, err := d.decodeTime()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case tagType:
+		if code>>5 != majorTag {
+			return d.typeError(v)
+		}
+		_, _, number := d.head()
+		content, err := d.decodeInterface()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(Tag{number, content}))
+		return nil
+	case simpleType:
+		if code>>5 != majorSimple {
+			return d.typeError(v)
+		}
+		x, err := d.decodeInterface()
+		if err != nil {
+			return err
+		}
+		if simple, ok := x.(Simple); ok {
+			v.Set(reflect.ValueOf(simple))
+			return nil
+		}
+		return d.typeError(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			if v.IsNil() {
+				return fmt.Errorf("cbor: cannot decode into non-empty interface - %v", v.Type())
+			}
+			return d.decode(v.Elem())
+		}
+		x, err := d.decodeInterface()
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+	case reflect.Bool:
+		switch code {
+		case codeTrue:
+			v.SetBool(true)
+		case codeFalse:
+			v.SetBool(false)
+		default:
+			return d.typeError(v)
+		}
+		d.pos++
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := d.decodeInt()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("cbor: %d overflows %v", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if code>>5 != majorUint {
+			return d.typeError(v)
+		}
+		_, _, n := d.head()
+		if v.OverflowUint(n) {
+			return fmt.Errorf("cbor: %d overflows %v", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := d.decodeFloat()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		if code>>5 != majorText && code>>5 != majorBytes {
+			return d.typeError(v)
+		}
+		v.SetString(string(d.decodeString()))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if code>>5 != majorBytes && code>>5 != majorText {
+				return d.typeError(v)
+			}
+			v.SetBytes(d.decodeString())
+			return nil
+		}
+		if code>>5 != majorArray {
+			return d.typeError(v)
+		}
+		_, ai, n := d.head()
+		if ai == aiIndefinite {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			for i := 0; !d.isBreak(); i++ {
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+				if err := d.decode(v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), int(n), int(n)))
+		for i := 0; i < int(n); i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if code>>5 != majorBytes {
+				return d.typeError(v)
+			}
+			reflect.Copy(v, reflect.ValueOf(d.decodeString()))
+			return nil
+		}
+		if code>>5 != majorArray {
+			return d.typeError(v)
+		}
+		_, ai, n := d.head()
+		for i := 0; ; i++ {
+			if ai == aiIndefinite {
+				if d.isBreak() {
+					break
+				}
+			} else if i >= int(n) {
+				break
+			}
+
+			var err error
+			if i < v.Len() {
+				err = d.decode(v.Index(i))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if code>>5 != majorMap {
+			return d.typeError(v)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		return d.decodeEntries(func() error {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(value); err != nil {
+				return err
+			}
+			if !key.Comparable() {
+				return fmt.Errorf("cbor: unhashable map key - %T", key.Interface())
+			}
+			v.SetMapIndex(key, value)
+			return nil
+		})
+	case reflect.Struct:
+		if code>>5 != majorMap {
+			return d.typeError(v)
+		}
+		fields := fieldsOf(v.Type())
+		return d.decodeEntries(func() error {
+			var key string
+			if err := d.decode(reflect.ValueOf(&key).Elem()); err != nil {
+				return err
+			}
+			for _, f := range fields {
+				if f.name == key {
+					return d.decode(v.FieldByIndex(f.index))
+				}
+			}
+			return d.skip()
+		})
+	default:
+		return fmt.Errorf("cbor: unsupported type - %v", v.Type())
+	}
+	return nil
+}
+
+// decodeEntries decodes the head of a map and calls entry for each key/value pair.
+func (d *decoder) decodeEntries(entry func() error) error {
+	_, ai, n := d.head()
+	for i := uint64(0); ; i++ {
+		if ai == aiIndefinite {
+			if d.isBreak() {
+				return nil
+			}
+		} else if i >= n {
+			return nil
+		}
+		if err := entry(); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *decoder) decodeInterface() (interface{}, error) {
+	if d.depth++; d.depth > maxNestingDepth {
+		return nil, errTooDeep
+	}
+	defer func() { d.depth-- }()
+
+	code := d.data[d.pos]
+	switch code >> 5 {
+	case majorUint:
+		_, _, n := d.head()
+		return n, nil
+	case majorNegInt:
+		return d.decodeInt()
+	case majorBytes:
+		return d.decodeString(), nil
+	case majorText:
+		return string(d.decodeString()), nil
+	case majorArray:
+		array := make([]interface{}, 0)
+		_, ai, n := d.head()
+		for i := uint64(0); ; i++ {
+			if ai == aiIndefinite {
+				if d.isBreak() {
+					break
+				}
+			} else if i >= n {
+				break
+			}
+			x, err := d.decodeInterface()
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, x)
+		}
+		return array, nil
+	case majorMap:
+		return d.decodeMap()
+	case majorTag:
+		start := d.pos
+		_, _, number := d.head()
+		if number == tagDateTimeString || number == tagEpochDateTime {
+			d.pos = start
+			return d.decodeTime()
+		}
+		content, err := d.decodeInterface()
+		if err != nil {
+			return nil, err
+		}
+		return Tag{number, content}, nil
+	}
+
+	switch code {
+	case codeFalse, codeTrue:
+		d.pos++
+		return code == codeTrue, nil
+	case codeNull, codeUndefined:
+		d.pos++
+		return nil, nil
+	case codeFloat16, codeFloat32, codeFloat64:
+		return d.decodeFloat()
+	}
+
+	_, _, n := d.head()
+	return Simple(n), nil
+}
+
+func (d *decoder) decodeMap() (interface{}, error) {
+	var keys, values []interface{}
+	stringKeys := true
+	err := d.decodeEntries(func() error {
+		key, err := d.decodeInterface()
+		if err != nil {
+			return err
+		}
+		value, err := d.decodeInterface()
+		if err != nil {
+			return err
+		}
+		if _, ok := key.(string); !ok {
+			stringKeys = false
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if stringKeys {
+		m := make(map[string]interface{}, len(keys))
+		for i, key := range keys {
+			m[key.(string)] = values[i]
+		}
+		return m, nil
+	}
+
+	m := make(map[interface{}]interface{}, len(keys))
+	for i, key := range keys {
+		if key != nil && !reflect.ValueOf(key).Comparable() {
+			return nil, fmt.Errorf("cbor: unhashable map key - %T", key)
+		}
+		m[key] = values[i]
+	}
+	return m, nil
+}
+
+func (d *decoder) decodeInt() (int64, error) {
+	major, _, n := d.head()
+	switch major {
+	case majorUint:
+		if n > math.MaxInt64 {
+			return 0, fmt.Errorf("cbor: %d overflows int64", n)
+		}
+		return int64(n), nil
+	case majorNegInt:
+		if n > math.MaxInt64 {
+			return 0, fmt.Errorf("cbor: -1-%d overflows int64", n)
+		}
+		return -1 - int64(n), nil
+	default:
+		return 0, fmt.Errorf("cbor: invalid major type for integer - %d", major)
+	}
+}
+
+func (d *decoder) decodeFloat() (float64, error) {
+	code := d.data[d.pos]
+	switch code >> 5 {
+	case majorUint:
+		_, _, n := d.head()
+		return float64(n), nil
+	case majorNegInt:
+		_, _, n := d.head()
+		return -1 - float64(n), nil
+	}
+
+	_, _, bits := d.head()
+	switch code {
+	case codeFloat16:
+		return float16ToFloat64(uint16(bits)), nil
+	case codeFloat32:
+		return float64(math.Float32frombits(uint32(bits))), nil
+	case codeFloat64:
+		return math.Float64frombits(bits), nil
+	default:
+		return 0, fmt.Errorf("cbor: invalid code for float - 0x%02x", code)
+	}
+}
+
+// decodeString decodes a byte string or a text string. Chunks of an indefinite-length string are concatenated.
+func (d *decoder) decodeString() []byte {
+	_, ai, n := d.head()
+	if ai != aiIndefinite {
+		s := append([]byte(nil), d.data[d.pos:d.pos+int(n)]...)
+		d.pos += int(n)
+		return s
+	}
+
+	s := []byte{}
+	for !d.isBreak() {
+		_, _, n := d.head()
+		s = append(s, d.data[d.pos:d.pos+int(n)]...)
+		d.pos += int(n)
+	}
+	return s
+}
+
+func (d *decoder) decodeTime() (time.Time, error) {
+	code := d.data[d.pos]
+	if code>>5 != majorTag {
+		f, err := d.decodeFloat()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return epochTime(f), nil
+	}
+
+	_, _, number := d.head()
+	switch number {
+	case tagDateTimeString:
+		if d.data[d.pos]>>5 != majorText {
+			return time.Time{}, errors.New("cbor: date/time string should be a text string")
+		}
+		return time.Parse(time.RFC3339Nano, string(d.decodeString()))
+	case tagEpochDateTime:
+		if d.data[d.pos]>>5 <= majorNegInt {
+			n, err := d.decodeInt()
+			return time.Unix(n, 0), err
+		}
+		f, err := d.decodeFloat()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return epochTime(f), nil
+	default:
+		return time.Time{}, fmt.Errorf("cbor: invalid tag for time - %d", number)
+	}
+}
+
+func (d *decoder) typeError(v reflect.Value) error {
+	return fmt.Errorf("cbor: cannot decode 0x%02x into %v", d.data[d.pos], v.Type())
+}
+
+func epochTime(f float64) time.Time {
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+func float16ToFloat64(bits uint16) float64 {
+	exp := int(bits>>10) & 0x1f
+	mant := float64(bits & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if bits&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,306 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Marshal returns the CBOR encoding of v with the preferred serialization.
+func Marshal(v interface{}) ([]byte, error) {
+	e := encoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.data, nil
+}
+
+// MarshalCanonical returns the core deterministic CBOR encoding of v.
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	e := encoder{canonical: true}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.data, nil
+}
+
+type encoder struct {
+	data      []byte
+	canonical bool
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.data = append(e.data, codeNull)
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		return e.encodeTime(v.Interface().(time.Time))
+	case tagType:
+		tag := v.Interface().(Tag)
+		e.encodeHead(majorTag, tag.Number)
+		return e.encode(reflect.ValueOf(tag.Content))
+	case simpleType:
+		e.encodeSimple(uint8(v.Uint()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.data = append(e.data, codeNull)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.data = append(e.data, codeTrue)
+		} else {
+			e.data = append(e.data, codeFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n < 0 {
+			e.encodeHead(majorNegInt, uint64(-1-n))
+		} else {
+			e.encodeHead(majorUint, uint64(n))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeHead(majorUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.encodeFloat(v.Float(), v.Kind() == reflect.Float32)
+	case reflect.String:
+		e.encodeHead(majorText, uint64(v.Len()))
+		e.data = append(e.data, v.String()...)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.data = append(e.data, codeNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeHead(majorBytes, uint64(v.Len()))
+			e.data = append(e.data, v.Bytes()...)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeHead(majorBytes, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				e.data = append(e.data, byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.data = append(e.data, codeNull)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("cbor: unsupported type - %v", v.Type())
+	}
+	return nil
+}
+
+// encodeHead encodes the initial byte and the argument of an item with the shortest form.
+func (e *encoder) encodeHead(major byte, arg uint64) {
+	ib := major << 5
+	switch {
+	case arg < 24:
+		e.data = append(e.data, ib|byte(arg))
+	case arg <= math.MaxUint8:
+		e.data = append(e.data, ib|24, byte(arg))
+	case arg <= math.MaxUint16:
+		e.data = append(e.data, ib|25)
+		e.data = binary.BigEndian.AppendUint16(e.data, uint16(arg))
+	case arg <= math.MaxUint32:
+		e.data = append(e.data, ib|26)
+		e.data = binary.BigEndian.AppendUint32(e.data, uint32(arg))
+	default:
+		e.data = append(e.data, ib|27)
+		e.data = binary.BigEndian.AppendUint64(e.data, arg)
+	}
+}
+
+func (e *encoder) encodeSimple(value uint8) {
+	if value < 24 {
+		e.data = append(e.data, majorSimple<<5|value)
+	} else {
+		e.data = append(e.data, majorSimple<<5|24, value)
+	}
+}
+
+func (e *encoder) encodeFloat(f float64, is32 bool) {
+	if e.canonical {
+		if math.IsNaN(f) {
+			e.data = append(e.data, codeFloat16, 0x7e, 0x00)
+			return
+		}
+		if f32 := float32(f); float64(f32) == f || math.IsInf(f, 0) {
+			if half, ok := float16Bits(f32); ok {
+				e.data = append(e.data, codeFloat16)
+				e.data = binary.BigEndian.AppendUint16(e.data, half)
+				return
+			}
+			is32 = true
+		} else {
+			is32 = false
+		}
+	}
+
+	if is32 {
+		e.data = append(e.data, codeFloat32)
+		e.data = binary.BigEndian.AppendUint32(e.data, math.Float32bits(float32(f)))
+	} else {
+		e.data = append(e.data, codeFloat64)
+		e.data = binary.BigEndian.AppendUint64(e.data, math.Float64bits(f))
+	}
+}
+
+func (e *encoder) encodeArray(v reflect.Value) error {
+	e.encodeHead(majorArray, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeMap(v reflect.Value) error {
+	e.encodeHead(majorMap, uint64(v.Len()))
+	if !e.canonical {
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	pairs := make([]pair, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		p, err := e.encodePair(iter.Key(), iter.Value())
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, p)
+	}
+	e.appendSorted(pairs)
+	return nil
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) error {
+	fields := fieldsOf(v.Type())
+	pairs := make([]pair, 0, len(fields))
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		p, err := e.encodePair(reflect.ValueOf(f.name), fv)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, p)
+	}
+
+	e.encodeHead(majorMap, uint64(len(pairs)))
+	if e.canonical {
+		e.appendSorted(pairs)
+		return nil
+	}
+	for _, p := range pairs {
+		e.data = append(e.data, p.key...)
+		e.data = append(e.data, p.value...)
+	}
+	return nil
+}
+
+type pair struct {
+	key   []byte
+	value []byte
+}
+
+func (e *encoder) encodePair(key reflect.Value, value reflect.Value) (pair, error) {
+	sub := encoder{canonical: e.canonical}
+	if err := sub.encode(key); err != nil {
+		return pair{}, err
+	}
+	keyLen := len(sub.data)
+	if err := sub.encode(value); err != nil {
+		return pair{}, err
+	}
+	return pair{sub.data[:keyLen], sub.data[keyLen:]}, nil
+}
+
+// appendSorted appends the pairs sorted by the bytewise lexicographic order of the encoded keys.
+func (e *encoder) appendSorted(pairs []pair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return bytes.Compare(pairs[i].key, pairs[j].key) < 0
+	})
+	for _, p := range pairs {
+		e.data = append(e.data, p.key...)
+		e.data = append(e.data, p.value...)
+	}
+}
+
+func (e *encoder) encodeTime(t time.Time) error {
+	e.encodeHead(majorTag, tagEpochDateTime)
+	if t.Nanosecond() == 0 {
+		return e.encode(reflect.ValueOf(t.Unix()))
+	}
+	e.encodeFloat(float64(t.UnixNano())/1e9, false)
+	return nil
+}
+
+// float16Bits returns the half-precision bits of f if f can be represented exactly.
+func float16Bits(f float32) (uint16, bool) {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	switch {
+	case exp == 0xff:
+		if mant == 0 {
+			return sign | 0x7c00, true // infinity
+		}
+		return 0x7e00, true // NaN
+	case exp == 0 && mant == 0:
+		return sign, true // zero
+	case exp == 0:
+		return 0, false // float32 subnormals are too small
+	}
+
+	e := exp - 127 + 15
+	if e >= 31 {
+		return 0, false
+	}
+	if e <= 0 { // half-precision subnormal
+		shift := uint(14 - e)
+		m := mant | 0x800000
+		if shift > 24 || m&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(m>>shift), true
+	}
+	if mant&0x1fff != 0 {
+		return 0, false
+	}
+	return sign | uint16(e)<<10 | uint16(mant>>13), true
+}
@@ -0,0 +1,99 @@
+package net
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// certReloader loads a certificate/key pair from files and reloads it when the files are changed.
+type certReloader struct {
+	certFile     string
+	keyFile      string
+	interval     time.Duration
+	errorHandler func(err error)
+
+	cert atomic.Value // *tls.Certificate
+
+	mu       sync.Mutex  // guards stats and reload
+	certStat os.FileInfo // stat of certFile when it was loaded
+	keyStat  os.FileInfo // stat of keyFile when it was loaded
+}
+
+func newCertReloader(certFile string, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := new(certReloader)
+	r.certFile = certFile
+	r.keyFile = keyFile
+	r.interval = interval
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// getCertificate is used as GetCertificate of tls.Config.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load().(*tls.Certificate), nil
+}
+
+// reload loads the files. If it fails, the current certificate is kept.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certStat, _ := os.Stat(r.certFile)
+	keyStat, _ := os.Stat(r.keyFile)
+	return r.load(certStat, keyStat)
+}
+
+// load loads the files and remembers their stats. r.mu should be held.
+func (r *certReloader) load(certStat os.FileInfo, keyStat os.FileInfo) error {
+	r.certStat = certStat
+	r.keyStat = keyStat
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+// check reloads the files if their stats are changed since the last load.
+// A failed load is not retried until the files are changed again.
+func (r *certReloader) check() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certStat, _ := os.Stat(r.certFile)
+	keyStat, _ := os.Stat(r.keyFile)
+	if sameStat(certStat, r.certStat) && sameStat(keyStat, r.keyStat) {
+		return
+	}
+	if err := r.load(certStat, keyStat); err != nil && r.errorHandler != nil {
+		r.errorHandler(err)
+	}
+}
+
+// watch checks the files every interval until done is closed.
+func (r *certReloader) watch(done <-chan struct{}) {
+	if r.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+func sameStat(a os.FileInfo, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
@@ -0,0 +1,100 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFrameTooLong is returned by frame decoders when the length of a frame exceeds the limit.
+var ErrFrameTooLong = errors.New("net: frame too long")
+
+// messages is the result of a ReadHandler that produced several messages from one inbound message.
+// Each message is passed to the rest of the ReadHandler chain in order.
+type messages []interface{}
+
+type frameKey struct {
+	decoder interface{}
+}
+
+// decodeFrames decodes frames from in with decode, which returns nil if a whole frame is not received yet.
+// If in is the read buffer of ctx, a frame is decoded and rollback is requested on a partial frame.
+// Otherwise in is a message of a previous handler. Its bytes are appended to the buffer of decoder for the connection
+// and all whole frames in it are decoded, so a partial frame is kept until the next message.
+func decodeFrames(ctx *SoContext, decoder interface{}, in interface{}, decode func(buffer *Buffer) (interface{}, error)) (interface{}, error) {
+	if buffer, ok := in.(*Buffer); ok && buffer == ctx.buffer {
+		msg, err := decode(buffer)
+		if msg == nil && err == nil {
+			ctx.Rollback()
+		}
+		return msg, err
+	}
+
+	data, err := inboundBytes(in)
+	if err != nil {
+		return nil, err
+	}
+	buffer, ok := ctx.Value(frameKey{decoder}).(*Buffer)
+	if !ok {
+		buffer = NewBuffer(len(data))
+		ctx.SetValue(frameKey{decoder}, buffer)
+	}
+	buffer.Write(data)
+
+	var msgs messages
+	for {
+		msg, err := decode(buffer)
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	buffer.Commit()
+
+	switch len(msgs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return msgs[0], nil
+	default:
+		return msgs, nil
+	}
+}
+
+// inboundBytes consumes and returns all readable bytes of in.
+func inboundBytes(in interface{}) ([]byte, error) {
+	switch msg := in.(type) {
+	case *Buffer:
+		data := msg.Data()
+		msg.DataConsume(len(data))
+		return data, nil
+	case []byte:
+		return msg, nil
+	case string:
+		return []byte(msg), nil
+	default:
+		return nil, fmt.Errorf("net: unsupported inbound message type - %T", in)
+	}
+}
+
+// outboundBytes returns bytes of out that is written by the WriteHandler chain.
+func outboundBytes(out interface{}) ([]byte, error) {
+	switch msg := out.(type) {
+	case *Buffer:
+		return msg.Data(), nil
+	case []byte:
+		return msg, nil
+	case string:
+		return []byte(msg), nil
+	default:
+		return nil, &UnsupportedMessageError{out}
+	}
+}
+
+func newBufferOf(bytes []byte) *Buffer {
+	buffer := NewBuffer(len(bytes))
+	buffer.Write(bytes)
+	return buffer
+}
@@ -0,0 +1,244 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// readAll returns the inbound messages of p as strings.
+func readAll(p *EmbeddedPipeline) []string {
+	var msgs []string
+	for msg := p.ReadInbound(); msg != nil; msg = p.ReadInbound() {
+		if buffer, ok := msg.(*Buffer); ok {
+			msgs = append(msgs, string(buffer.Data()))
+		} else {
+			msgs = append(msgs, fmt.Sprint(msg))
+		}
+	}
+	return msgs
+}
+
+// toBytes passes the inbound buffer to the next handler as []byte, as decoders such as Decompressor do.
+type toBytes struct{}
+
+func (toBytes) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	return inboundBytes(in)
+}
+
+func TestLengthFieldFrame(t *testing.T) {
+	p, _ := NewEmbeddedPipeline(NewLengthFieldFrameDecoder(2, 8), NewLengthFieldPrepender(2))
+
+	if err := p.WriteInbound([]byte{0, 3, 'a'}); err != nil || !p.IsRollback() || len(readAll(p)) != 0 {
+		t.Errorf("WriteInbound() of partial frame = %v, rollback %v", err, p.IsRollback())
+	}
+	p.WriteInbound([]byte{'b', 'c', 0, 1, 'd', 0})
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[abc d]" || p.Buffered() != 1 {
+		t.Errorf("frames = %s, buffered %d", msgs, p.Buffered())
+	}
+	if err := p.WriteInbound([]byte{9}); err != ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long frame error = %v", err)
+	}
+
+	p.WriteOutbound("abc")
+	if msg, ok := p.ReadOutbound().(*Buffer); !ok || !bytes.Equal(msg.Data(), []byte{0, 3, 'a', 'b', 'c'}) {
+		t.Errorf("ReadOutbound() = %v", msg)
+	}
+}
+
+func TestLineFrame(t *testing.T) {
+	p, _ := NewEmbeddedPipeline(NewLineFrameDecoder(4), NewLineFrameEncoder(true))
+
+	p.WriteInbound("a\r\nb")
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[a]" || !p.IsRollback() {
+		t.Errorf("lines = %s, rollback %v", msgs, p.IsRollback())
+	}
+	p.WriteInbound("c\n\n")
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[bc ]" {
+		t.Errorf("lines = %s", msgs)
+	}
+	if err := p.WriteInbound("abcde"); err != ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long line error = %v", err)
+	}
+
+	p.WriteOutbound([]byte("abc"))
+	if msg, ok := p.ReadOutbound().(*Buffer); !ok || string(msg.Data()) != "abc\r\n" {
+		t.Errorf("ReadOutbound() = %v", msg)
+	}
+}
+
+// TestFrameOfMessages verifies that partial frames in messages of a previous handler are kept,
+// and that all frames in a message are passed to the next handler.
+func TestFrameOfMessages(t *testing.T) {
+	p, _ := NewEmbeddedPipeline(toBytes{}, NewLineFrameDecoder(16))
+
+	p.WriteInbound("he")
+	if msgs := readAll(p); len(msgs) != 0 || p.Buffered() != 0 {
+		t.Errorf("lines = %v, buffered %d", msgs, p.Buffered())
+	}
+	p.WriteInbound("llo\nwor")
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[hello]" {
+		t.Errorf("lines = %s", msgs)
+	}
+	p.WriteInbound("ld\nx\ny\n")
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[world x y]" {
+		t.Errorf("lines = %s", msgs)
+	}
+}
+
+type jsonPing struct {
+	Seq int `json:"seq"`
+}
+
+type jsonPong struct {
+	Seq int `json:"seq"`
+}
+
+func TestJSONCodec(t *testing.T) {
+	codec := NewJSONCodec(nil)
+	codec.SetTypeField("type")
+	codec.Register("ping", &jsonPing{})
+	codec.Register("pong", jsonPong{})
+	p, _ := NewEmbeddedPipeline(NewLineFrameDecoder(64), codec)
+
+	p.WriteInbound(`{"type":"ping","seq":1}` + "\n" + `{"seq":2,"type":"pong"}` + "\n" + `{"seq":3}` + "\n")
+	if msg, ok := p.ReadInbound().(*jsonPing); !ok || msg.Seq != 1 {
+		t.Errorf("ping = %#v", msg)
+	}
+	if msg, ok := p.ReadInbound().(jsonPong); !ok || msg.Seq != 2 {
+		t.Errorf("pong = %#v", msg)
+	}
+	if msg, ok := p.ReadInbound().(map[string]interface{}); !ok || msg["seq"] != 3.0 {
+		t.Errorf("untyped = %#v", msg)
+	}
+	if err := p.WriteInbound(`{"type":"unknown"}` + "\n"); err == nil {
+		t.Error("unknown type decoded")
+	}
+
+	p.WriteOutbound(&jsonPing{4}, jsonPong{})
+	if msg, ok := p.ReadOutbound().(*Buffer); !ok || string(msg.Data()) != `{"type":"ping","seq":4}` {
+		t.Errorf("ReadOutbound() = %v", msg)
+	}
+	if msg, ok := p.ReadOutbound().(*Buffer); !ok || string(msg.Data()) != `{"type":"pong","seq":0}` {
+		t.Errorf("ReadOutbound() = %v", msg)
+	}
+}
+
+type pingHandler struct{}
+
+func (pingHandler) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	return fmt.Sprintf("ping %d", in.(*jsonPing).Seq), nil
+}
+
+func TestTypeRouter(t *testing.T) {
+	router := NewTypeRouter()
+	router.Route(&jsonPing{}, pingHandler{})
+	p, _ := NewEmbeddedPipeline(router)
+
+	p.WriteInbound(&jsonPing{1}, jsonPong{2})
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[ping 1 {2}]" {
+		t.Errorf("routed = %s", msgs)
+	}
+}
+
+func TestProtobufVarint32Frame(t *testing.T) {
+	p, _ := NewEmbeddedPipeline(NewProtobufVarint32FrameDecoder(1024), ProtobufVarint32LengthFieldPrepender{})
+
+	payload := bytes.Repeat([]byte("x"), 300)
+	p.WriteOutbound(payload)
+	frame, ok := p.ReadOutbound().(*Buffer)
+	if !ok || !bytes.Equal(frame.Data()[:2], []byte{0xac, 0x02}) || frame.Readable() != 302 {
+		t.Fatalf("ReadOutbound() = %v", frame)
+	}
+
+	// fragments of the length and the payload are rolled back until the whole frame is received.
+	data := frame.Data()
+	for i, fragment := range [][]byte{data[:1], data[1:100], data[100:]} {
+		p.WriteInbound(fragment)
+		if msgs := readAll(p); (len(msgs) == 0) != (i < 2) || (i < 2) != p.IsRollback() {
+			t.Fatalf("fragment %d: %d frames, rollback %v", i, len(msgs), p.IsRollback())
+		} else if i == 2 && msgs[0] != string(payload) {
+			t.Errorf("frame = %q", msgs[0])
+		}
+	}
+
+	if err := p.WriteInbound([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0x01}); err == nil {
+		t.Error("malformed varint32 decoded")
+	}
+	p.Context().buffer.Clear()
+	if err := p.WriteInbound([]byte{0x81, 0x08}); err != ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long frame error = %v", err)
+	}
+}
+
+type gobMessage struct {
+	Name  string
+	Value interface{}
+}
+
+// gobLoop passes the outbound frames of p to its inbound.
+func gobLoop(t *testing.T, p *EmbeddedPipeline) {
+	for msg := p.ReadOutbound(); msg != nil; msg = p.ReadOutbound() {
+		data := msg.(*Buffer).Data()
+		for i := range data { // byte by byte to exercise rollback on partial frames.
+			if err := p.WriteInbound(data[i : i+1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestGobCodec(t *testing.T) {
+	p, _ := NewEmbeddedPipeline(NewGobCodec(&gobMessage{}))
+	p.WriteOutbound(&gobMessage{"a", 1}, gobMessage{"b", "two"})
+	gobLoop(t, p)
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[&{a 1} &{b two}]" {
+		t.Errorf("decoded = %s", msgs)
+	}
+}
+
+// TestGobCodecCorruptFrame verifies that the decoder starts over after a frame that can't be decoded.
+func TestGobCodecCorruptFrame(t *testing.T) {
+	encode := func(msg gobMessage) []byte { // encodes msg on a new connection, so type definitions are included.
+		p, _ := NewEmbeddedPipeline(NewGobCodec(gobMessage{}))
+		p.WriteOutbound(msg)
+		return p.ReadOutbound().(*Buffer).Data()
+	}
+
+	p, _ := NewEmbeddedPipeline(NewGobCodec(gobMessage{}))
+	if err := p.WriteInbound(encode(gobMessage{"first", 1})); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.WriteInbound([]byte{0, 0, 0, 3, 0xff, 0xff, 0xff}); err == nil {
+		t.Fatal("corrupt frame decoded")
+	}
+	if err := p.WriteInbound(encode(gobMessage{"second", 2})); err != nil {
+		t.Fatalf("WriteInbound() after corrupt frame = %v", err)
+	}
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[{first 1} {second 2}]" {
+		t.Errorf("decoded = %s", msgs)
+	}
+}
+
+// TestGobCodecError verifies that the peer keeps decoding values after an encoding error,
+// which leaves the encoder with type definitions regarded as sent.
+func TestGobCodecError(t *testing.T) {
+	codec := NewGobCodec(nil)
+	codec.Register(gobMessage{})
+	codec.Register([]gobMessage{})
+	p, _ := NewEmbeddedPipeline(codec)
+
+	p.WriteOutbound(gobMessage{"first", 1})
+	gobLoop(t, p)
+	if err := p.WriteOutbound(gobMessage{"bad", []gobMessage{{"nested", func() {}}}}); err == nil {
+		t.Fatal("func value encoded")
+	}
+	if msg := p.ReadOutbound(); msg != nil {
+		t.Fatalf("frame %v written on error", msg)
+	}
+	p.WriteOutbound(gobMessage{"good", []gobMessage{{"nested", 2}}})
+	gobLoop(t, p)
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[{first 1} {good [{nested 2}]}]" {
+		t.Errorf("decoded = %s", msgs)
+	}
+}
@@ -0,0 +1,343 @@
+package net
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compression algorithms used by Compressor and Decompressor.
+const (
+	Deflate = iota
+	Gzip
+	Zlib
+)
+
+// Compression modes used by Compressor and Decompressor.
+// In PerMessage mode, each message is compressed independently.
+// In Streaming mode, one long-lived compressor is used per connection and each message is sync-flushed,
+// so the compression ratio of similar messages gets better.
+const (
+	PerMessage = iota
+	Streaming
+)
+
+const (
+	compressionFlagRaw        = 0
+	compressionFlagCompressed = 1
+
+	maxDictionarySize = 32 * 1024
+)
+
+// ErrDecompressedTooLarge is returned by Decompressor when a decompressed message exceeds the size limit.
+var ErrDecompressedTooLarge = errors.New("net: decompressed message too large")
+
+// finalBlock is an empty final stored block of deflate. It terminates a sync-flushed message.
+var finalBlock = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+
+// Compressor is a WriteHandler that compresses outbound messages.
+// Each compressed message starts with a flag byte that tells whether the message is compressed or not.
+// Messages smaller than the minimum size are not compressed. The outbound message should be one of *Buffer, []byte and string.
+// Compressor doesn't split the stream, so it should be placed before a frame encoder such as LengthFieldPrepender.
+type Compressor struct {
+	algorithm int
+	mode      int
+	level     int
+	minSize   int
+	pool      sync.Pool
+}
+
+type compressorState struct {
+	out bytes.Buffer
+	w   flushWriter
+}
+
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// NewCompressor returns a Compressor. algorithm should be one of Deflate, Gzip and Zlib, and mode should be one of PerMessage and Streaming.
+func NewCompressor(algorithm int, mode int) *Compressor {
+	checkCompression(algorithm, mode)
+	compressor := new(Compressor)
+	compressor.algorithm = algorithm
+	compressor.mode = mode
+	compressor.level = flate.DefaultCompression
+	return compressor
+}
+
+// SetLevel sets the compression level. The level is one of the levels of compress/flate.
+func (c *Compressor) SetLevel(level int) {
+	c.level = level
+}
+
+// SetMinSize sets the minimum size of a message to be compressed. Smaller messages are sent uncompressed.
+func (c *Compressor) SetMinSize(size int) {
+	c.minSize = size
+}
+
+// OnWrite implements WriteHandler interface.
+func (c *Compressor) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	data, err := outboundBytes(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < c.minSize {
+		buffer := NewBuffer(1 + len(data))
+		buffer.Write([]byte{compressionFlagRaw})
+		buffer.Write(data)
+		return buffer, nil
+	}
+
+	var state *compressorState
+	if c.mode == Streaming {
+		if state, err = c.streamState(ctx); err != nil {
+			return nil, err
+		}
+		state.out.Reset()
+		state.out.WriteByte(compressionFlagCompressed)
+		if _, err = state.w.Write(data); err == nil {
+			err = state.w.Flush()
+		}
+	} else {
+		if state, err = c.get(); err != nil {
+			return nil, err
+		}
+		defer c.pool.Put(state)
+		state.out.WriteByte(compressionFlagCompressed)
+		if _, err = state.w.Write(data); err == nil {
+			err = state.w.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newBufferOf(state.out.Bytes()), nil
+}
+
+func (c *Compressor) streamState(ctx *SoContext) (*compressorState, error) {
+	if state, ok := ctx.Value(c).(*compressorState); ok {
+		return state, nil
+	}
+
+	state, err := c.newState()
+	if err != nil {
+		return nil, err
+	}
+	ctx.SetValue(c, state)
+	return state, nil
+}
+
+func (c *Compressor) get() (*compressorState, error) {
+	if state, ok := c.pool.Get().(*compressorState); ok {
+		state.out.Reset()
+		switch w := state.w.(type) {
+		case *flate.Writer:
+			w.Reset(&state.out)
+		case *gzip.Writer:
+			w.Reset(&state.out)
+		case *zlib.Writer:
+			w.Reset(&state.out)
+		}
+		return state, nil
+	}
+	return c.newState()
+}
+
+func (c *Compressor) newState() (*compressorState, error) {
+	state := new(compressorState)
+
+	var err error
+	switch c.algorithm {
+	case Deflate:
+		state.w, err = flate.NewWriter(&state.out, c.level)
+	case Gzip:
+		state.w, err = gzip.NewWriterLevel(&state.out, c.level)
+	default:
+		state.w, err = zlib.NewWriterLevel(&state.out, c.level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Decompressor is a ReadHandler that decompresses inbound messages compressed by Compressor.
+// The algorithm and the mode should be the same with the Compressor of the peer.
+// Decompressor doesn't split the stream, so it should be placed after a frame decoder such as LengthFieldFrameDecoder.
+type Decompressor struct {
+	algorithm int
+	mode      int
+	maxSize   int
+}
+
+type decompressorState struct {
+	r       io.ReadCloser
+	dict    []byte
+	started bool
+}
+
+// NewDecompressor returns a Decompressor. algorithm should be one of Deflate, Gzip and Zlib, and mode should be one of PerMessage and Streaming.
+// If the size of a decompressed message exceeds maxSize, ErrDecompressedTooLarge is returned to protect against zip bombs.
+func NewDecompressor(algorithm int, mode int, maxSize int) *Decompressor {
+	checkCompression(algorithm, mode)
+	decompressor := new(Decompressor)
+	decompressor.algorithm = algorithm
+	decompressor.mode = mode
+	decompressor.maxSize = maxSize
+	return decompressor
+}
+
+// OnRead implements ReadHandler interface.
+func (d *Decompressor) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	data, err := inboundBytes(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("net: compressed message without flag")
+	}
+
+	switch data[0] {
+	case compressionFlagRaw:
+		return newBufferOf(data[1:]), nil
+	case compressionFlagCompressed:
+	default:
+		return nil, fmt.Errorf("net: invalid compression flag - %d", data[0])
+	}
+
+	if d.mode == Streaming {
+		return d.decompressStream(ctx, data[1:])
+	}
+
+	var r io.Reader
+	switch d.algorithm {
+	case Deflate:
+		r = flate.NewReader(bytes.NewReader(data[1:]))
+	case Gzip:
+		r, err = gzip.NewReader(bytes.NewReader(data[1:]))
+	default:
+		r, err = zlib.NewReader(bytes.NewReader(data[1:]))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := d.readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return newBufferOf(out), nil
+}
+
+// decompressStream decompresses a sync-flushed message of the stream.
+// The decompressor is reset for each message with the last 32KB of the decompressed stream as the dictionary.
+func (d *Decompressor) decompressStream(ctx *SoContext, data []byte) (interface{}, error) {
+	state, ok := ctx.Value(d).(*decompressorState)
+	if !ok {
+		state = new(decompressorState)
+		ctx.SetValue(d, state)
+	}
+
+	if !state.started {
+		n, err := d.headerSize(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		state.started = true
+	}
+
+	src := io.MultiReader(bytes.NewReader(data), bytes.NewReader(finalBlock))
+	if state.r == nil {
+		state.r = flate.NewReaderDict(src, state.dict)
+	} else if err := state.r.(flate.Resetter).Reset(src, state.dict); err != nil {
+		return nil, err
+	}
+
+	out, err := d.readAll(state.r)
+	if err != nil {
+		return nil, err
+	}
+
+	state.dict = append(state.dict, out...)
+	if len(state.dict) > maxDictionarySize {
+		state.dict = append(state.dict[:0], state.dict[len(state.dict)-maxDictionarySize:]...)
+	}
+	return newBufferOf(out), nil
+}
+
+// headerSize returns the size of the gzip or zlib header at the start of the stream.
+func (d *Decompressor) headerSize(data []byte) (int, error) {
+	switch d.algorithm {
+	case Gzip:
+		if len(data) < 10 || data[0] != 0x1f || data[1] != 0x8b || data[2] != 8 {
+			return 0, gzip.ErrHeader
+		}
+		flags := data[3]
+		n := 10
+		if flags&0x04 != 0 { // FEXTRA
+			if len(data) < n+2 {
+				return 0, gzip.ErrHeader
+			}
+			n += 2 + (int(data[n]) | int(data[n+1])<<8)
+		}
+		for _, flag := range []byte{0x08, 0x10} { // FNAME, FCOMMENT
+			if flags&flag != 0 {
+				if n >= len(data) {
+					return 0, gzip.ErrHeader
+				}
+				end := bytes.IndexByte(data[n:], 0)
+				if end < 0 {
+					return 0, gzip.ErrHeader
+				}
+				n += end + 1
+			}
+		}
+		if flags&0x02 != 0 { // FHCRC
+			n += 2
+		}
+		if n > len(data) {
+			return 0, gzip.ErrHeader
+		}
+		return n, nil
+	case Zlib:
+		if len(data) < 2 || data[0]&0x0f != 8 || (uint(data[0])<<8|uint(data[1]))%31 != 0 {
+			return 0, zlib.ErrHeader
+		}
+		if data[1]&0x20 != 0 {
+			return 0, zlib.ErrDictionary
+		}
+		return 2, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (d *Decompressor) readAll(r io.Reader) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(r, int64(d.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > d.maxSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return out, nil
+}
+
+func checkCompression(algorithm int, mode int) {
+	if algorithm < Deflate || algorithm > Zlib {
+		panic(fmt.Sprintf("net: invalid compression algorithm - %d", algorithm))
+	}
+	if mode != PerMessage && mode != Streaming {
+		panic(fmt.Sprintf("net: invalid compression mode - %d", mode))
+	}
+}
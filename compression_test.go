@@ -0,0 +1,67 @@
+package net
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	messages := []string{"short", strings.Repeat("hello, world. ", 100), strings.Repeat("hello, world! ", 100)}
+	for _, algorithm := range []int{Deflate, Gzip, Zlib} {
+		for _, mode := range []int{PerMessage, Streaming} {
+			compressor := NewCompressor(algorithm, mode)
+			compressor.SetMinSize(16)
+			sender, _ := NewEmbeddedPipeline(compressor)
+			receiver, _ := NewEmbeddedPipeline(NewDecompressor(algorithm, mode, 4096))
+
+			for _, msg := range messages {
+				sender.WriteOutbound(msg)
+				compressed := sender.ReadOutbound().(*Buffer)
+				if flag := compressed.Data()[0]; (flag == compressionFlagRaw) != (len(msg) < 16) {
+					t.Errorf("algorithm %d, mode %d: flag %d for %d bytes", algorithm, mode, flag, len(msg))
+				}
+				if err := receiver.WriteInbound(compressed.Data()); err != nil {
+					t.Fatalf("algorithm %d, mode %d: WriteInbound() = %v", algorithm, mode, err)
+				}
+			}
+			if msgs := fmt.Sprint(readAll(receiver)); msgs != fmt.Sprint(messages) {
+				t.Errorf("algorithm %d, mode %d: decompressed = %.40s...", algorithm, mode, msgs)
+			}
+		}
+	}
+}
+
+func TestDecompressedTooLarge(t *testing.T) {
+	sender, _ := NewEmbeddedPipeline(NewCompressor(Gzip, PerMessage))
+	receiver, _ := NewEmbeddedPipeline(NewDecompressor(Gzip, PerMessage, 100))
+
+	sender.WriteOutbound(strings.Repeat("x", 101))
+	if err := receiver.WriteInbound(sender.ReadOutbound().(*Buffer).Data()); err != ErrDecompressedTooLarge {
+		t.Errorf("WriteInbound() = %v", err)
+	}
+}
+
+// TestDecompressGzipHeader verifies that the optional fields of the gzip header are skipped in Streaming mode.
+func TestDecompressGzipHeader(t *testing.T) {
+	var stream bytes.Buffer
+	w := gzip.NewWriter(&stream)
+	w.Extra = make([]byte, 0x1fe) // 2 + the low byte of the length carries into the high byte.
+	w.Name = "message.txt"
+	receiver, _ := NewEmbeddedPipeline(NewDecompressor(Gzip, Streaming, 4096))
+
+	for _, msg := range []string{"first message", "second message"} {
+		stream.Reset()
+		stream.WriteByte(compressionFlagCompressed)
+		w.Write([]byte(msg))
+		w.Flush()
+		if err := receiver.WriteInbound(stream.Bytes()); err != nil {
+			t.Fatalf("WriteInbound() = %v", err)
+		}
+	}
+	if msgs := fmt.Sprint(readAll(receiver)); msgs != "[first message second message]" {
+		t.Errorf("decompressed = %s", msgs)
+	}
+}
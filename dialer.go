@@ -0,0 +1,302 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Dialer makes the connection of a client. *net.Dialer and *tls.Dialer of the standard library satisfy it.
+type Dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// ListenerFactory creates the listener of a server. *net.ListenConfig of the standard library satisfies it.
+type ListenerFactory interface {
+	Listen(ctx context.Context, network string, address string) (net.Listener, error)
+}
+
+var defaultDialer = new(net.Dialer)
+
+// A SOCKS5Dialer is a Dialer that makes connections through a SOCKS5 proxy.
+type SOCKS5Dialer struct {
+	address  string
+	username string
+	password string
+	forward  Dialer
+}
+
+// NewSOCKS5Dialer creates a SOCKS5Dialer that uses the proxy at address. The address has the form "host:port".
+func NewSOCKS5Dialer(address string) *SOCKS5Dialer {
+	dialer := new(SOCKS5Dialer)
+	dialer.address = address
+	return dialer
+}
+
+// SetAuth sets the username and password used to authenticate with the proxy (RFC 1929).
+func (d *SOCKS5Dialer) SetAuth(username string, password string) error {
+	if len(username) == 0 || len(username) > 255 || len(password) > 255 {
+		return errors.New("net: invalid SOCKS5 username or password")
+	}
+	d.username = username
+	d.password = password
+	return nil
+}
+
+// SetForward sets the dialer used to connect to the proxy. By default, the proxy is connected directly.
+func (d *SOCKS5Dialer) SetForward(forward Dialer) error {
+	d.forward = forward
+	return nil
+}
+
+// DialContext connects to address through the proxy. Only TCP networks are supported.
+func (d *SOCKS5Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("net: network %s is not supported by SOCKS5 proxy", network)
+	}
+	request, err := socks5Request(address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialForward(ctx, d.forward, d.address)
+	if err != nil {
+		return nil, err
+	}
+	err = handshakeContext(ctx, conn, func() error {
+		return d.handshake(conn, request)
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *SOCKS5Dialer) handshake(conn net.Conn, request []byte) error {
+	greeting := []byte{5, 1, 0} // no authentication
+	if d.username != "" {
+		greeting = []byte{5, 1, 2} // username/password
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != greeting[2] {
+		return errors.New("net: SOCKS5 proxy rejected authentication method")
+	}
+
+	if d.username != "" {
+		auth := append([]byte{1, byte(len(d.username))}, d.username...)
+		auth = append(append(auth, byte(len(d.password))), d.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[0] != 1 {
+			return fmt.Errorf("net: invalid SOCKS5 authentication version %d", reply[0])
+		}
+		if reply[1] != 0 {
+			return errors.New("net: SOCKS5 proxy authentication failed")
+		}
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	reply = make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("net: invalid SOCKS5 reply version %d", reply[0])
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("net: SOCKS5 proxy replied %d", reply[1])
+	}
+
+	var remain int // remaining size of the bound address and port. The first byte of the address is read already.
+	switch reply[3] {
+	case 1:
+		remain = 4 - 1 + 2
+	case 3:
+		remain = int(reply[4]) + 2
+	case 4:
+		remain = 16 - 1 + 2
+	default:
+		return fmt.Errorf("net: invalid SOCKS5 address type %d", reply[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, remain))
+	return err
+}
+
+// socks5Request returns the CONNECT request of SOCKS5 for address.
+func socks5Request(address string) ([]byte, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("net: invalid port %q", port)
+	}
+
+	request := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(append(request, 1), ip4...)
+		} else {
+			request = append(append(request, 4), ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("net: host name too long %q", host)
+		}
+		request = append(append(request, 3, byte(len(host))), host...)
+	}
+	return append(request, byte(p>>8), byte(p)), nil
+}
+
+// An HTTPProxyDialer is a Dialer that makes connections through an HTTP proxy with the CONNECT method.
+type HTTPProxyDialer struct {
+	address string
+	header  http.Header
+	forward Dialer
+}
+
+// NewHTTPProxyDialer creates an HTTPProxyDialer that uses the proxy at address. The address has the form "host:port".
+// To connect to the proxy with TLS, set a *tls.Dialer with SetForward().
+func NewHTTPProxyDialer(address string) *HTTPProxyDialer {
+	dialer := new(HTTPProxyDialer)
+	dialer.address = address
+	dialer.header = make(http.Header)
+	return dialer
+}
+
+// SetAuth sets the username and password sent to the proxy with the basic authentication scheme.
+func (d *HTTPProxyDialer) SetAuth(username string, password string) error {
+	credential := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	d.header.Set("Proxy-Authorization", "Basic "+credential)
+	return nil
+}
+
+// SetHeader sets additional header fields of the CONNECT request.
+func (d *HTTPProxyDialer) SetHeader(header http.Header) error {
+	for key, values := range header {
+		d.header[key] = values
+	}
+	return nil
+}
+
+// SetForward sets the dialer used to connect to the proxy. By default, the proxy is connected directly.
+func (d *HTTPProxyDialer) SetForward(forward Dialer) error {
+	d.forward = forward
+	return nil
+}
+
+// DialContext connects to address through the proxy. Only TCP networks are supported.
+func (d *HTTPProxyDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("net: network %s is not supported by HTTP proxy", network)
+	}
+
+	conn, err := dialForward(ctx, d.forward, d.address)
+	if err != nil {
+		return nil, err
+	}
+	err = handshakeContext(ctx, conn, func() error {
+		request := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: address},
+			Host:   address,
+			Header: d.header,
+		}
+		if err := request.Write(conn); err != nil {
+			return err
+		}
+
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return fmt.Errorf("net: HTTP proxy returned %s", response.Status)
+		}
+		if reader.Buffered() > 0 { // the destination has sent data already.
+			conn = &bufferedConn{conn, reader}
+		}
+		return nil
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first data is read from a reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// NetConn returns the underlying connection.
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func dialForward(ctx context.Context, forward Dialer, address string) (net.Conn, error) {
+	if forward == nil {
+		forward = defaultDialer
+	}
+	return forward.DialContext(ctx, "tcp", address)
+}
+
+// handshakeContext runs handshake on conn. The handshake is aborted when ctx is done.
+func handshakeContext(ctx context.Context, conn net.Conn, handshake func() error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(time.Unix(1, 0)) // unblock the handshake.
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
+
+	err := handshake()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,279 @@
+package net
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// EmbeddedPipeline runs handlers synchronously without a connection. It is intended for unit tests of handlers.
+//
+// Handlers receive a real SoContext, so Rollback(), SetValue(), ReplaceHandler() and the others behave as on a connection.
+// Events requested by handlers such as Write() and FlushAndClose() are handled in order before each method returns.
+// Messages that pass through the end of the ReadHandler chain are kept for ReadInbound(),
+// and messages that pass through the end of the WriteHandler chain are kept for ReadOutbound() instead of being sent.
+type EmbeddedPipeline struct {
+	ctx          *SoContext
+	svc          *embeddedService
+	events       []event
+	received     []interface{} // messages for ReadInbound()
+	sent         []interface{} // messages for ReadOutbound()
+	errs         []error
+	rollback     bool
+	disconnected bool
+}
+
+type embeddedService struct {
+	pl   *pipeline
+	ch   chan struct{}
+	once sync.Once
+}
+
+// NewEmbeddedPipeline creates an EmbeddedPipeline with handlers and calls the ConnectHandler chain.
+// It returns an error if a handler is not valid or a ConnectHandler returns an error.
+func NewEmbeddedPipeline(handlers ...interface{}) (*EmbeddedPipeline, error) {
+	svc := new(embeddedService)
+	svc.pl = new(pipeline)
+	svc.ch = make(chan struct{})
+	for _, handler := range handlers {
+		if err := svc.pl.AddHandler(handler); err != nil {
+			return nil, err
+		}
+	}
+
+	p := new(EmbeddedPipeline)
+	p.svc = svc
+	conn, peer := net.Pipe()
+	peer.Close()
+	p.ctx = newContext(svc, conn, 0)
+	p.ctx.driver = p
+
+	if !p.ctx.handleConnect() {
+		p.svc.cancel()
+		p.run()
+		if len(p.errs) == 0 {
+			return p, ErrClosed
+		}
+		return p, p.errs[len(p.errs)-1]
+	}
+	return p, p.run()
+}
+
+// Context returns the context passed to handlers.
+func (p *EmbeddedPipeline) Context() *SoContext {
+	return p.ctx
+}
+
+// WriteInbound passes msgs to the ReadHandler chain in order as if they are received from the peer.
+// []byte, string and *Buffer are appended to the read buffer of the context and the chain is called as long as data is consumed.
+// Other messages are passed to the first ReadHandler as they are.
+// It returns the first error raised by handlers during the call.
+func (p *EmbeddedPipeline) WriteInbound(msgs ...interface{}) error {
+	p.rollback = false
+	p.errs = nil
+	for _, msg := range msgs {
+		if !p.svc.isRunning() {
+			p.errs = append(p.errs, ErrClosed)
+			break
+		}
+
+		switch data := msg.(type) {
+		case []byte:
+			p.writeInboundBytes(data)
+		case string:
+			p.writeInboundBytes([]byte(data))
+		case *Buffer:
+			p.writeInboundBytes(data.Data())
+		default:
+			if p.ctx.fireRead(msg) {
+				p.ctx.Commit()
+			}
+		}
+		p.run()
+	}
+	return p.firstError()
+}
+
+func (p *EmbeddedPipeline) writeInboundBytes(data []byte) {
+	p.ctx.handleTraffic(true, data)
+	p.ctx.buffer.Write(data)
+	p.ctx.handleRead()
+}
+
+// WriteEOF notifies the end of the inbound stream as if the peer closes the connection.
+// The ReadHandlers that implement EOFHandler are called and then the context is closed after flush.
+func (p *EmbeddedPipeline) WriteEOF() error {
+	p.rollback = false
+	p.errs = nil
+	if p.svc.isRunning() {
+		p.events = append(p.events, event{eventEOF, nil})
+	}
+	p.run()
+	return p.firstError()
+}
+
+// ReadInbound returns the oldest message that passed through the end of the ReadHandler chain.
+// It returns nil if there is no such message. If the last ReadHandler returns the read buffer of the context, the buffer itself is kept.
+func (p *EmbeddedPipeline) ReadInbound() interface{} {
+	if len(p.received) == 0 {
+		return nil
+	}
+	msg := p.received[0]
+	p.received = p.received[1:]
+	return msg
+}
+
+// WriteOutbound writes msgs to the WriteHandler chain in order as ctx.Write() does.
+// It returns the first error raised by handlers during the call.
+func (p *EmbeddedPipeline) WriteOutbound(msgs ...interface{}) error {
+	p.errs = nil
+	for _, msg := range msgs {
+		if err := p.ctx.Write(msg); err != nil {
+			p.errs = append(p.errs, err)
+			break
+		}
+	}
+	p.run()
+	return p.firstError()
+}
+
+// ReadOutbound returns the oldest message that passed through the end of the WriteHandler chain.
+// It returns nil if there is no such message.
+func (p *EmbeddedPipeline) ReadOutbound() interface{} {
+	if len(p.sent) == 0 {
+		return nil
+	}
+	msg := p.sent[0]
+	p.sent = p.sent[1:]
+	return msg
+}
+
+// FireTimeout calls the TimeoutHandler chain as if the read operation times out.
+func (p *EmbeddedPipeline) FireTimeout() error {
+	p.errs = nil
+	p.ctx.handleTimeout()
+	p.run()
+	return p.firstError()
+}
+
+// Close closes the context and calls the DisconnectHandler chain.
+func (p *EmbeddedPipeline) Close() {
+	p.ctx.Close()
+	p.run()
+}
+
+// IsClosed reports whether the context is closed by Close() or FlushAndClose() of a handler or the pipeline.
+func (p *EmbeddedPipeline) IsClosed() bool {
+	return !p.svc.isRunning()
+}
+
+// IsRollback reports whether a ReadHandler requested rollback during the last WriteInbound() or WriteEOF().
+func (p *EmbeddedPipeline) IsRollback() bool {
+	return p.rollback
+}
+
+// Buffered returns the number of bytes remaining in the read buffer of the context.
+func (p *EmbeddedPipeline) Buffered() int {
+	return p.ctx.buffer.Readable()
+}
+
+// Errors returns the errors raised by handlers during the last call.
+// These errors are also passed to the ErrorHandler chain as on a connection.
+func (p *EmbeddedPipeline) Errors() []error {
+	return p.errs
+}
+
+// queueEvent queues evt to be handled by run().
+func (p *EmbeddedPipeline) queueEvent(evt event) error {
+	if !p.svc.isRunning() {
+		return ErrClosed
+	}
+	p.events = append(p.events, evt)
+	return nil
+}
+
+// inbound keeps msg for ReadInbound().
+func (p *EmbeddedPipeline) inbound(msg interface{}) {
+	p.received = append(p.received, msg)
+}
+
+// outbound keeps msg for ReadOutbound() instead of sending it.
+func (p *EmbeddedPipeline) outbound(msg interface{}) {
+	p.sent = append(p.sent, msg)
+}
+
+func (p *EmbeddedPipeline) rolledBack() {
+	p.rollback = true
+}
+
+// failed keeps err for Errors().
+func (p *EmbeddedPipeline) failed(err error) {
+	p.errs = append(p.errs, err)
+}
+
+// run handles queued events in order and calls the DisconnectHandler chain once if the context is closed.
+func (p *EmbeddedPipeline) run() error {
+	for len(p.events) > 0 && p.svc.isRunning() {
+		evt := p.events[0]
+		p.events = p.events[1:]
+		if !p.ctx.handleEvent(evt) {
+			break
+		}
+	}
+
+	if !p.svc.isRunning() {
+		p.events = nil
+		if !p.disconnected {
+			p.disconnected = true
+			p.ctx.handleDisconnect()
+			p.ctx.conn.Close()
+		}
+	}
+	return p.firstError()
+}
+
+func (p *EmbeddedPipeline) firstError() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs[0]
+}
+
+func (s *embeddedService) pipeline() *pipeline {
+	return s.pl
+}
+
+func (s *embeddedService) readTimeout() time.Duration {
+	return 0
+}
+
+func (s *embeddedService) writeTimeout() time.Duration {
+	return 0
+}
+
+func (s *embeddedService) maxWriteBatch() int {
+	return defaultMaxWriteBatch
+}
+
+func (s *embeddedService) autoFlush() bool {
+	return true
+}
+
+func (s *embeddedService) cancel() {
+	s.once.Do(func() {
+		close(s.ch)
+	})
+}
+
+func (s *embeddedService) done() <-chan struct{} {
+	return s.ch
+}
+
+func (s *embeddedService) isRunning() bool {
+	select {
+	case <-s.ch:
+		return false
+	default:
+		return true
+	}
+}
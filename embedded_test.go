@@ -0,0 +1,170 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type quitHandler struct {
+	disconnected bool
+}
+
+func (h *quitHandler) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	line := string(in.(*Buffer).Data())
+	if line == "quit" {
+		ctx.Write("bye")
+		ctx.FlushAndClose()
+		return nil, nil
+	}
+	return line, nil
+}
+
+func (h *quitHandler) OnDisconnect(ctx *SoContext) {
+	h.disconnected = true
+}
+
+func TestEmbeddedPipeline(t *testing.T) {
+	quit := new(quitHandler)
+	p, err := NewEmbeddedPipeline(NewLineFrameDecoder(8), NewLineFrameEncoder(false), quit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.WriteInbound([]byte("hel")); err != nil || !p.IsRollback() || p.Buffered() != 3 || p.ReadInbound() != nil {
+		t.Errorf("WriteInbound() of partial line = %v, rollback %v, buffered %d", err, p.IsRollback(), p.Buffered())
+	}
+	if err = p.WriteInbound("lo\nworld\n"); err != nil || p.IsRollback() || p.Buffered() != 0 {
+		t.Errorf("WriteInbound() = %v, rollback %v, buffered %d", err, p.IsRollback(), p.Buffered())
+	}
+	if msg := p.ReadInbound(); msg != "hello" {
+		t.Errorf("ReadInbound() = %v", msg)
+	}
+	if msg := p.ReadInbound(); msg != "world" {
+		t.Errorf("ReadInbound() = %v", msg)
+	}
+
+	if err = p.WriteOutbound("ping"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := p.ReadOutbound().(*Buffer); !ok || string(msg.Data()) != "ping\n" {
+		t.Errorf("ReadOutbound() = %v", msg)
+	}
+
+	if err = p.WriteInbound("too long line\n"); err != ErrFrameTooLong || len(p.Errors()) != 1 {
+		t.Errorf("WriteInbound() of long line error = %v", err)
+	}
+	p.Context().buffer.Clear()
+
+	if err = p.WriteInbound("quit\n"); err != nil || !p.IsClosed() || !quit.disconnected {
+		t.Errorf("WriteInbound() of quit = %v, closed %v, disconnected %v", err, p.IsClosed(), quit.disconnected)
+	}
+	if msg, ok := p.ReadOutbound().(*Buffer); !ok || string(msg.Data()) != "bye\n" {
+		t.Errorf("ReadOutbound() = %v", msg)
+	}
+	if err = p.WriteOutbound("late"); err != ErrClosed {
+		t.Errorf("WriteOutbound() after close error = %v", err)
+	}
+}
+
+type countHandler struct {
+	reads int
+}
+
+func (h *countHandler) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	h.reads++
+	ctx.Rollback()
+	return nil, nil
+}
+
+// eofHandler passes the data remaining at the end of the stream to the next handler.
+type eofHandler struct {
+	buffer *Buffer
+}
+
+func (h *eofHandler) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	h.buffer = in.(*Buffer)
+	ctx.Rollback()
+	return nil, nil
+}
+
+func (h *eofHandler) OnEOF(ctx *SoContext) (interface{}, error) {
+	if !ctx.IsEOF() {
+		return nil, errors.New("IsEOF() is false in OnEOF()")
+	}
+	ctx.Write("bye")
+	return "last " + string(h.buffer.Data()), nil
+}
+
+func TestWriteEOF(t *testing.T) {
+	counter := new(countHandler)
+	p, _ := NewEmbeddedPipeline(counter)
+	p.WriteInbound("partial")
+	if err := p.WriteEOF(); err != nil || !p.IsClosed() || counter.reads != 1 {
+		t.Errorf("WriteEOF() without EOFHandler = %v, closed %v, reads %d", err, p.IsClosed(), counter.reads)
+	}
+
+	p, _ = NewEmbeddedPipeline(new(eofHandler))
+	p.WriteInbound("partial")
+	if err := p.WriteEOF(); err != nil || !p.IsClosed() {
+		t.Errorf("WriteEOF() = %v, closed %v", err, p.IsClosed())
+	}
+	if msg := p.ReadInbound(); msg != "last partial" {
+		t.Errorf("ReadInbound() = %v", msg)
+	}
+	if msg := p.ReadOutbound(); msg != "bye" {
+		t.Errorf("ReadOutbound() = %v", msg)
+	}
+}
+
+// oddFilter passes odd numbers only. It is a ReadHandler and a WriteHandler.
+type oddFilter struct{}
+
+func (oddFilter) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	if in.(int)%2 == 0 {
+		return nil, nil
+	}
+	return in, nil
+}
+
+func (oddFilter) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	return oddFilter{}.OnRead(ctx, out)
+}
+
+// collector records the messages that reach it. It is a ReadHandler and a WriteHandler.
+type collector struct {
+	reads  []interface{}
+	writes []interface{}
+}
+
+func (c *collector) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	c.reads = append(c.reads, in)
+	return in, nil
+}
+
+func (c *collector) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	c.writes = append(c.writes, out)
+	return out, nil
+}
+
+// TestNilOutput verifies that a nil result of a handler stops the rest of the chain.
+func TestNilOutput(t *testing.T) {
+	c := new(collector)
+	p, _ := NewEmbeddedPipeline(oddFilter{}, c) // WriteHandlers are called in reverse order.
+
+	p.WriteInbound(1, 2, 3)
+	if reads := fmt.Sprint(c.reads); reads != "[1 3]" {
+		t.Errorf("reads after filter = %s", reads)
+	}
+	if msgs := fmt.Sprint(readAll(p)); msgs != "[1 3]" {
+		t.Errorf("inbound = %s", msgs)
+	}
+
+	p.WriteOutbound(4, 5, 6)
+	if writes := fmt.Sprint(c.writes); writes != "[4 5 6]" {
+		t.Errorf("writes before filter = %s", writes)
+	}
+	if msg := p.ReadOutbound(); msg != 5 || p.ReadOutbound() != nil {
+		t.Errorf("outbound = %v", msg)
+	}
+}
@@ -0,0 +1,18 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrClosed is returned when a message is written to a closed connection.
+var ErrClosed = errors.New("net: connection is closed")
+
+// An UnsupportedMessageError is raised when a message of unsupported type reaches the end of the WriteHandler chain.
+type UnsupportedMessageError struct {
+	Message interface{}
+}
+
+func (e *UnsupportedMessageError) Error() string {
+	return fmt.Sprintf("net: unsupported outbound message type - %T", e.Message)
+}
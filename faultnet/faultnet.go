@@ -0,0 +1,248 @@
+// Package faultnet wraps connections to inject faults for chaos testing: fragmented reads and writes, latency,
+// bandwidth caps, resets, stalled writes and corrupted bytes.
+// Faults are decided by random number generators seeded from the seed of the Injector, so a failing run can be reproduced.
+// Each connection has its own generators for reads and writes, which are seeded by the seed and the order of the connection.
+//
+// Wrapped listeners and dialers can be set on the services of package net with SetListener() and SetDialer().
+package faultnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrReset is returned by a connection reset by an injected fault. It matches syscall.ECONNRESET with errors.Is().
+var ErrReset = fmt.Errorf("faultnet: injected reset: %w", syscall.ECONNRESET)
+
+// Dialer makes connections. *net.Dialer satisfies it as well as the Dialer interface of package github.com/shanpark/net.
+type Dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// Injector decides faults and wraps connections, listeners and dialers.
+// Its settings should be made before connections are wrapped.
+type Injector struct {
+	seed  int64
+	conns int64 // number of wrapped connections
+
+	maxChunk    int
+	minLatency  time.Duration
+	maxLatency  time.Duration
+	bandwidth   int
+	resetRate   float64
+	stallRate   float64
+	stallDur    time.Duration
+	corruptRate float64
+}
+
+// NewInjector creates an Injector that injects no faults until they are set.
+func NewInjector(seed int64) *Injector {
+	injector := new(Injector)
+	injector.seed = seed
+	return injector
+}
+
+// SetFragmentation splits each read and write into random chunks of 1 to maxChunk bytes. Zero disables it.
+func (i *Injector) SetFragmentation(maxChunk int) {
+	i.maxChunk = maxChunk
+}
+
+// SetLatency adds a random delay between min and max before each read and write.
+func (i *Injector) SetLatency(min time.Duration, max time.Duration) {
+	i.minLatency = min
+	i.maxLatency = max
+}
+
+// SetBandwidth caps the throughput of each direction of a connection to bytesPerSecond. Zero means no cap.
+func (i *Injector) SetBandwidth(bytesPerSecond int) {
+	i.bandwidth = bytesPerSecond
+}
+
+// SetResetRate sets the probability that a read or write resets the connection.
+// The operation returns ErrReset and the underlying connection is closed, with RST if it is a TCP connection.
+// After that, reads return io.EOF and writes return ErrReset as the kernel does.
+func (i *Injector) SetResetRate(rate float64) {
+	i.resetRate = rate
+}
+
+// SetStall sets the probability that a write stalls for duration before it is written.
+func (i *Injector) SetStall(rate float64, duration time.Duration) {
+	i.stallRate = rate
+	i.stallDur = duration
+}
+
+// SetCorruptionRate sets the probability that a byte read is corrupted by flipping one of its bits.
+func (i *Injector) SetCorruptionRate(rate float64) {
+	i.corruptRate = rate
+}
+
+// Conn wraps conn to inject faults.
+func (i *Injector) Conn(conn net.Conn) net.Conn {
+	n := atomic.AddInt64(&i.conns, 1)
+	c := new(Conn)
+	c.Conn = conn
+	c.injector = i
+	c.readRand = rand.New(rand.NewSource(i.seed + 2*n))
+	c.writeRand = rand.New(rand.NewSource(i.seed + 2*n + 1))
+	return c
+}
+
+// Listener wraps listener so that accepted connections inject faults.
+func (i *Injector) Listener(listener net.Listener) net.Listener {
+	return &faultListener{listener, i}
+}
+
+// Dialer wraps dialer so that dialed connections inject faults. If dialer is nil, *net.Dialer is used.
+func (i *Injector) Dialer(dialer Dialer) Dialer {
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+	return &faultDialer{dialer, i}
+}
+
+type faultListener struct {
+	net.Listener
+	injector *Injector
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.injector.Conn(conn), nil
+}
+
+type faultDialer struct {
+	dialer   Dialer
+	injector *Injector
+}
+
+func (d *faultDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return d.injector.Conn(conn), nil
+}
+
+// Conn is a connection that injects faults. The random number generators of reads and writes are separated,
+// so concurrent reads and writes don't affect the faults of each other.
+type Conn struct {
+	net.Conn
+	injector  *Injector
+	readRand  *rand.Rand
+	writeRand *rand.Rand
+
+	mu    sync.Mutex
+	reset bool
+}
+
+// Read implements net.Conn interface.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.isReset() {
+		return 0, io.EOF
+	}
+	i := c.injector
+	if c.readRand.Float64() < i.resetRate {
+		c.doReset()
+		return 0, ErrReset
+	}
+	c.delay(c.readRand)
+
+	if i.maxChunk > 0 && len(b) > 1 {
+		if size := 1 + c.readRand.Intn(i.maxChunk); size < len(b) {
+			b = b[:size]
+		}
+	}
+	n, err := c.Conn.Read(b)
+	for k := 0; k < n && i.corruptRate > 0; k++ {
+		if c.readRand.Float64() < i.corruptRate {
+			b[k] ^= 1 << uint(c.readRand.Intn(8))
+		}
+	}
+	c.throttle(n)
+	return n, err
+}
+
+// Write implements net.Conn interface.
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for {
+		if c.isReset() {
+			return written, ErrReset
+		}
+		i := c.injector
+		if c.writeRand.Float64() < i.resetRate {
+			c.doReset()
+			return written, ErrReset
+		}
+		if i.stallRate > 0 && c.writeRand.Float64() < i.stallRate {
+			time.Sleep(i.stallDur)
+		}
+		c.delay(c.writeRand)
+
+		chunk := b[written:]
+		if i.maxChunk > 0 && len(chunk) > 1 {
+			if size := 1 + c.writeRand.Intn(i.maxChunk); size < len(chunk) {
+				chunk = chunk[:size]
+			}
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		c.throttle(n)
+		if err != nil || written == len(b) {
+			return written, err
+		}
+	}
+}
+
+func (c *Conn) delay(r *rand.Rand) {
+	i := c.injector
+	if i.maxLatency <= 0 {
+		return
+	}
+	latency := i.minLatency
+	if i.maxLatency > i.minLatency {
+		latency += time.Duration(r.Int63n(int64(i.maxLatency - i.minLatency)))
+	}
+	time.Sleep(latency)
+}
+
+func (c *Conn) throttle(n int) {
+	if c.injector.bandwidth > 0 && n > 0 {
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(c.injector.bandwidth))
+	}
+}
+
+func (c *Conn) isReset() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reset
+}
+
+// doReset closes the underlying connection. A TCP connection is closed with RST by zero linger.
+func (c *Conn) doReset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reset {
+		return
+	}
+	c.reset = true
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	c.Conn.Close()
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,132 @@
+package faultnet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/shanpark/net"
+	"github.com/shanpark/net/memnet"
+)
+
+// chunkSizes returns the sizes of reads of data through a connection wrapped by injector.
+func chunkSizes(injector *Injector, data []byte) []int {
+	listener := memnet.NewListener("test")
+	defer listener.Close()
+	go func() {
+		conn, _ := listener.Accept()
+		conn.Write(data)
+		conn.Close()
+	}()
+	raw, _ := listener.Dial()
+	conn := injector.Conn(raw)
+
+	var sizes []int
+	buf := make([]byte, 64)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return sizes
+		}
+		sizes = append(sizes, n)
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 100)
+	first := NewInjector(42)
+	first.SetFragmentation(5)
+	second := NewInjector(42)
+	second.SetFragmentation(5)
+
+	sizes := chunkSizes(first, data)
+	if fmt.Sprint(sizes) != fmt.Sprint(chunkSizes(second, data)) {
+		t.Error("fragmentation is not reproducible with the same seed")
+	}
+	total := 0
+	for _, size := range sizes {
+		if size < 1 || size > 5 {
+			t.Errorf("chunk size %d out of range", size)
+		}
+		total += size
+	}
+	if total != len(data) {
+		t.Errorf("total %d bytes read", total)
+	}
+}
+
+func TestResetAndCorruption(t *testing.T) {
+	listener := memnet.NewListener("test")
+	defer listener.Close()
+	go func() {
+		conn, _ := listener.Accept()
+		conn.Write([]byte{0, 0, 0, 0})
+	}()
+	raw, _ := listener.Dial()
+
+	injector := NewInjector(1)
+	injector.SetCorruptionRate(1)
+	conn := injector.Conn(raw)
+	buf := make([]byte, 4)
+	if n, err := io.ReadFull(conn, buf); err != nil || n != 4 || bytes.Equal(buf, []byte{0, 0, 0, 0}) {
+		t.Errorf("ReadFull() = % x, %v", buf, err)
+	}
+
+	injector.SetResetRate(1)
+	if _, err := conn.Write([]byte("x")); err != ErrReset || !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Write() error = %v", err)
+	}
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Errorf("Read() after reset error = %v", err)
+	}
+}
+
+type collector chan string
+
+func (c collector) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	c <- string(in.(*net.Buffer).Data())
+	return nil, nil
+}
+
+// TestFrameDecoder verifies the rollback of a frame decoder under fragmentation and latency of both directions.
+func TestFrameDecoder(t *testing.T) {
+	injector := NewInjector(7)
+	injector.SetFragmentation(3)
+	injector.SetLatency(0, time.Millisecond)
+
+	listener := memnet.NewListener("frames")
+	received := make(collector, 100)
+	server := net.NewTCPServer()
+	server.SetListener(injector.Listener(listener))
+	server.AddHandler(net.NewLengthFieldFrameDecoder(2, 1024), received)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client := net.NewTCPClient()
+	client.SetDialer(injector.Dialer(listener))
+	client.AddHandler(net.NewLengthFieldPrepender(2))
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	for i := 0; i < 20; i++ {
+		client.Write(fmt.Sprintf("frame-%d", i))
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case frame := <-received:
+			if frame != fmt.Sprintf("frame-%d", i) {
+				t.Fatalf("frame %d = %q", i, frame)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("frame %d not received", i)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package net
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+const fileRegionChunkSize = 1024 * 1024
+
+// A FileRegion represents a region of a file to be sent to the peer.
+// When a FileRegion reaches the end of the WriteHandler chain, it is transferred directly from the file to the connection.
+// On TCP connections the transfer uses zero-copy (sendfile/splice) where the OS supports it.
+// On TLS connections or when the pipeline has a TrafficHandler, it falls back to a buffered copy.
+// WriteHandlers that don't handle FileRegion should pass it through as it is.
+type FileRegion struct {
+	file     *os.File
+	offset   int64
+	length   int64
+	progress func(transferred int64, total int64)
+}
+
+// NewFileRegion returns a FileRegion that represents 'length' bytes of the file starting at 'offset'.
+// The transfer moves the current offset of the file, so the file should not be used elsewhere until the transfer is done.
+func NewFileRegion(file *os.File, offset int64, length int64) *FileRegion {
+	region := new(FileRegion)
+	region.file = file
+	region.offset = offset
+	region.length = length
+	return region
+}
+
+// File returns the file of the region.
+func (r *FileRegion) File() *os.File {
+	return r.file
+}
+
+// Offset returns the start offset of the region in the file.
+func (r *FileRegion) Offset() int64 {
+	return r.offset
+}
+
+// Length returns the number of bytes of the region.
+func (r *FileRegion) Length() int64 {
+	return r.length
+}
+
+// SetProgressFunc sets the function to be called whenever a part of the region is transferred.
+// The function is called with the number of bytes transferred so far and the length of the region.
+func (r *FileRegion) SetProgressFunc(progress func(transferred int64, total int64)) {
+	r.progress = progress
+}
+
+func (r *FileRegion) transferTo(nctx *SoContext) error {
+	if _, err := r.file.Seek(r.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	var transferred int64
+	for transferred < r.length {
+		chunk := r.length - transferred
+		if chunk > fileRegionChunkSize {
+			chunk = fileRegionChunkSize
+		}
+
+		nctx.setWriteDeadline()
+		// io.Copy() uses (*net.TCPConn).ReadFrom() that makes use of sendfile for *os.File wrapped in io.LimitedReader.
+		n, err := io.Copy(nctx.directWriter(), &io.LimitedReader{R: r.file, N: chunk})
+		transferred += n
+		if r.progress != nil {
+			r.progress(transferred, r.length)
+		}
+		if err != nil {
+			return err
+		}
+		if n < chunk {
+			return errors.New("net: file region exceeds the end of file")
+		}
+	}
+
+	return nil
+}
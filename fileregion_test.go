@@ -0,0 +1,135 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	stdnet "net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFileServer starts a TCPServer on the loopback interface with handlers and returns a connection to it
+// and the context of the connection.
+func startFileServer(t *testing.T, autoFlush bool, handlers ...interface{}) (stdnet.Conn, *SoContext) {
+	contexts := make(contextCatcher, 1)
+	server := NewTCPServer()
+	server.SetAddress("127.0.0.1:0")
+	server.SetAutoFlush(autoFlush)
+	server.AddHandler(contexts)
+	server.AddHandler(handlers...)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	conn, err := stdnet.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	select {
+	case ctx := <-contexts:
+		return conn, ctx
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not accepted")
+		return nil, nil
+	}
+}
+
+// tempFile creates a file with size bytes of a pattern and returns the file and its content.
+func tempFile(t *testing.T, size int) (*os.File, []byte) {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i * 7 / 3)
+	}
+	name := filepath.Join(t.TempDir(), "region")
+	if err := os.WriteFile(name, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file, content
+}
+
+func TestFileRegion(t *testing.T) {
+	file, content := tempFile(t, 3*fileRegionChunkSize)
+	conn, ctx := startFileServer(t, true)
+
+	const offset, length = 1000, 2*fileRegionChunkSize + 500
+	var progress []int64
+	done := make(chan struct{})
+	region := NewFileRegion(file, offset, length)
+	region.SetProgressFunc(func(transferred int64, total int64) {
+		if total != length {
+			t.Errorf("progress total = %d", total)
+		}
+		progress = append(progress, transferred)
+		if transferred == total {
+			close(done)
+		}
+	})
+	ctx.Write(region)
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received := make([]byte, length)
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, content[offset:offset+length]) {
+		t.Error("received bytes differ from the region")
+	}
+	if data := readWithin(conn, 1, 50*time.Millisecond); data != "" {
+		t.Errorf("%d bytes sent after the region", len(data))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("progress not completed")
+	}
+	// the region is sent in chunks of fileRegionChunkSize bytes at most.
+	if len(progress) != 3 || progress[0] != fileRegionChunkSize || progress[1] != 2*fileRegionChunkSize || progress[2] != length {
+		t.Errorf("progress = %v", progress)
+	}
+}
+
+func TestFileRegionShortFile(t *testing.T) {
+	file, content := tempFile(t, 100)
+	errs := make(errorCatcher, 1)
+	conn, ctx := startFileServer(t, true, errs)
+
+	ctx.Write(NewFileRegion(file, 60, 50))
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "exceeds the end of file") {
+			t.Errorf("error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error for a region beyond the end of file")
+	}
+	if data := readWithin(conn, 40, 5*time.Second); data != string(content[60:]) {
+		t.Errorf("received %q", data)
+	}
+}
+
+// TestFileRegionOrder verifies that a FileRegion is sent after the data written before it and before the data written after it.
+func TestFileRegionOrder(t *testing.T) {
+	file, content := tempFile(t, 64)
+	conn, ctx := startFileServer(t, false) // keeps the earlier writes queued until the region is sent.
+
+	ctx.Write("head:")
+	ctx.Write([]byte("more:"))
+	ctx.Write(NewFileRegion(file, 16, 32))
+	ctx.WriteAndFlush(":tail")
+
+	want := "head:more:" + string(content[16:48]) + ":tail"
+	if data := readWithin(conn, len(want), 5*time.Second); data != want {
+		t.Errorf("received %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,289 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// LengthFieldFrameDecoder is a ReadHandler that splits the inbound stream into frames prefixed with a length field.
+// Each frame is passed to the next handler as a *Buffer without the length field.
+// If a whole frame is not received yet, it requests rollback and waits for more data.
+type LengthFieldFrameDecoder struct {
+	fieldSize      int
+	maxFrameLength int
+	order          binary.ByteOrder
+}
+
+// NewLengthFieldFrameDecoder returns a LengthFieldFrameDecoder.
+// fieldSize is the size of the length field and should be one of 1, 2, 4, 8.
+// The length field is big endian by default and doesn't include the size of the length field itself.
+func NewLengthFieldFrameDecoder(fieldSize int, maxFrameLength int) *LengthFieldFrameDecoder {
+	checkLengthFieldSize(fieldSize)
+	decoder := new(LengthFieldFrameDecoder)
+	decoder.fieldSize = fieldSize
+	decoder.maxFrameLength = maxFrameLength
+	decoder.order = binary.BigEndian
+	return decoder
+}
+
+// SetByteOrder sets the byte order of the length field.
+func (d *LengthFieldFrameDecoder) SetByteOrder(order binary.ByteOrder) {
+	d.order = order
+}
+
+// OnRead implements ReadHandler interface.
+func (d *LengthFieldFrameDecoder) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	return decodeFrames(ctx, d, in, d.decode)
+}
+
+// decode decodes a frame from buffer. It returns nil if a whole frame is not received yet.
+func (d *LengthFieldFrameDecoder) decode(buffer *Buffer) (interface{}, error) {
+	data := buffer.Data()
+	if len(data) < d.fieldSize {
+		return nil, nil
+	}
+
+	length := getLengthField(data, d.fieldSize, d.order)
+	if length > uint64(d.maxFrameLength) {
+		return nil, ErrFrameTooLong
+	}
+	if uint64(len(data)-d.fieldSize) < length {
+		return nil, nil
+	}
+
+	frame := newBufferOf(data[d.fieldSize : d.fieldSize+int(length)])
+	buffer.DataConsume(d.fieldSize + int(length))
+	return frame, nil
+}
+
+// LengthFieldPrepender is a WriteHandler that prepends a length field to the outbound message.
+// The outbound message should be one of *Buffer, []byte and string.
+type LengthFieldPrepender struct {
+	fieldSize int
+	order     binary.ByteOrder
+}
+
+// NewLengthFieldPrepender returns a LengthFieldPrepender.
+// fieldSize is the size of the length field and should be one of 1, 2, 4, 8.
+// The length field is big endian by default and doesn't include the size of the length field itself.
+func NewLengthFieldPrepender(fieldSize int) *LengthFieldPrepender {
+	checkLengthFieldSize(fieldSize)
+	prepender := new(LengthFieldPrepender)
+	prepender.fieldSize = fieldSize
+	prepender.order = binary.BigEndian
+	return prepender
+}
+
+// SetByteOrder sets the byte order of the length field.
+func (p *LengthFieldPrepender) SetByteOrder(order binary.ByteOrder) {
+	p.order = order
+}
+
+// OnWrite implements WriteHandler interface.
+func (p *LengthFieldPrepender) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	data, err := outboundBytes(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.fieldSize < 8 && uint64(len(data)) >= uint64(1)<<(8*uint(p.fieldSize)) {
+		return nil, ErrFrameTooLong
+	}
+
+	buffer := NewBuffer(p.fieldSize + len(data))
+	putLengthField(buffer.Buffer(), p.fieldSize, p.order, uint64(len(data)))
+	buffer.BufferConsume(p.fieldSize)
+	buffer.Write(data)
+	return buffer, nil
+}
+
+// LineFrameDecoder is a ReadHandler that splits the inbound stream into lines.
+// A line is terminated by "\n" or "\r\n" and each line is passed to the next handler as a *Buffer without the line terminator.
+// If a whole line is not received yet, it requests rollback and waits for more data.
+type LineFrameDecoder struct {
+	maxLineLength int
+}
+
+// NewLineFrameDecoder returns a LineFrameDecoder.
+func NewLineFrameDecoder(maxLineLength int) *LineFrameDecoder {
+	decoder := new(LineFrameDecoder)
+	decoder.maxLineLength = maxLineLength
+	return decoder
+}
+
+// OnRead implements ReadHandler interface.
+func (d *LineFrameDecoder) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	return decodeFrames(ctx, d, in, d.decode)
+}
+
+// decode decodes a line from buffer. It returns nil if a whole line is not received yet.
+func (d *LineFrameDecoder) decode(buffer *Buffer) (interface{}, error) {
+	data := buffer.Data()
+	eol := bytes.IndexByte(data, '\n')
+	if eol < 0 {
+		if len(data) > d.maxLineLength {
+			return nil, ErrFrameTooLong
+		}
+		return nil, nil
+	}
+
+	line := data[:eol]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	if len(line) > d.maxLineLength {
+		return nil, ErrFrameTooLong
+	}
+
+	frame := newBufferOf(line)
+	buffer.DataConsume(eol + 1)
+	return frame, nil
+}
+
+// LineFrameEncoder is a WriteHandler that appends a line terminator to the outbound message.
+// The outbound message should be one of *Buffer, []byte and string.
+type LineFrameEncoder struct {
+	eol []byte
+}
+
+// NewLineFrameEncoder returns a LineFrameEncoder. If crlf is true, lines are terminated by "\r\n", otherwise "\n".
+func NewLineFrameEncoder(crlf bool) *LineFrameEncoder {
+	encoder := new(LineFrameEncoder)
+	if crlf {
+		encoder.eol = []byte("\r\n")
+	} else {
+		encoder.eol = []byte("\n")
+	}
+	return encoder
+}
+
+// OnWrite implements WriteHandler interface.
+func (e *LineFrameEncoder) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	data, err := outboundBytes(out)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := NewBuffer(len(data) + len(e.eol))
+	buffer.Write(data)
+	buffer.Write(e.eol)
+	return buffer, nil
+}
+
+func checkLengthFieldSize(fieldSize int) {
+	switch fieldSize {
+	case 1, 2, 4, 8:
+	default:
+		panic(fmt.Sprintf("net: invalid length field size - %d", fieldSize))
+	}
+}
+
+func getLengthField(data []byte, fieldSize int, order binary.ByteOrder) uint64 {
+	switch fieldSize {
+	case 1:
+		return uint64(data[0])
+	case 2:
+		return uint64(order.Uint16(data))
+	case 4:
+		return uint64(order.Uint32(data))
+	default:
+		return order.Uint64(data)
+	}
+}
+
+func putLengthField(data []byte, fieldSize int, order binary.ByteOrder, length uint64) {
+	switch fieldSize {
+	case 1:
+		data[0] = byte(length)
+	case 2:
+		order.PutUint16(data, uint16(length))
+	case 4:
+		order.PutUint32(data, uint32(length))
+	default:
+		order.PutUint64(data, length)
+	}
+}
+
+// ProtobufVarint32FrameDecoder is a ReadHandler that splits the inbound stream into frames prefixed with a varint32 length,
+// as written by writeDelimitedTo() of Protocol Buffers. Each frame is passed to the next handler as a *Buffer without the length.
+// If the length or a whole frame is not received yet, it requests rollback and waits for more data.
+type ProtobufVarint32FrameDecoder struct {
+	maxFrameLength int
+}
+
+// NewProtobufVarint32FrameDecoder returns a ProtobufVarint32FrameDecoder.
+func NewProtobufVarint32FrameDecoder(maxFrameLength int) *ProtobufVarint32FrameDecoder {
+	decoder := new(ProtobufVarint32FrameDecoder)
+	decoder.maxFrameLength = maxFrameLength
+	return decoder
+}
+
+// OnRead implements ReadHandler interface.
+func (d *ProtobufVarint32FrameDecoder) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	return decodeFrames(ctx, d, in, d.decode)
+}
+
+// decode decodes a frame from buffer. It returns nil if the length or a whole frame is not received yet.
+func (d *ProtobufVarint32FrameDecoder) decode(buffer *Buffer) (interface{}, error) {
+	data := buffer.Data()
+	length, n, err := getVarint32(data)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if length > uint32(d.maxFrameLength) {
+		return nil, ErrFrameTooLong
+	}
+	if uint64(len(data)-n) < uint64(length) {
+		return nil, nil
+	}
+
+	frame := newBufferOf(data[n : n+int(length)])
+	buffer.DataConsume(n + int(length))
+	return frame, nil
+}
+
+// ProtobufVarint32LengthFieldPrepender is a WriteHandler that prepends a varint32 length to the outbound message.
+// The outbound message should be one of *Buffer, []byte and string.
+type ProtobufVarint32LengthFieldPrepender struct{}
+
+// OnWrite implements WriteHandler interface.
+func (p ProtobufVarint32LengthFieldPrepender) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	data, err := outboundBytes(out)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) > math.MaxUint32 {
+		return nil, ErrFrameTooLong
+	}
+
+	buffer := NewBuffer(binary.MaxVarintLen32 + len(data))
+	n := binary.PutUvarint(buffer.Buffer(), uint64(len(data)))
+	buffer.BufferConsume(n)
+	buffer.Write(data)
+	return buffer, nil
+}
+
+// getVarint32 decodes a varint32 from data. If data doesn't have a whole varint, n is 0.
+func getVarint32(data []byte) (value uint32, n int, err error) {
+	var shift uint
+	for i, b := range data {
+		if i == binary.MaxVarintLen32 {
+			return 0, 0, errors.New("net: malformed varint32")
+		}
+		value |= uint32(b&0x7f) << shift
+		if b < 0x80 {
+			return value, i + 1, nil
+		}
+		shift += 7
+	}
+	if len(data) >= binary.MaxVarintLen32 {
+		return 0, 0, errors.New("net: malformed varint32")
+	}
+	return 0, 0, nil
+}
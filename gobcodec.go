@@ -0,0 +1,189 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"reflect"
+)
+
+const maxGobFrameSize = 1 << 30
+
+// GobCodec is a ReadHandler and WriteHandler that transfers Go values with encoding/gob.
+// It keeps a gob.Encoder and a gob.Decoder per connection, so type definitions are sent only once on a connection.
+// Each encoded value is sent in a frame prefixed with a 4 bytes big endian length, so GobCodec can be placed on the stream directly.
+// After an encoding error, an empty frame is sent before the next value to make the peer reset its decoder.
+// Because of this framing, GobCodec can't talk to a plain gob.Encoder or gob.Decoder, so both ends should use GobCodec.
+// After a decoding error, the decoder is reset and expects the type definitions to be sent again.
+// If a whole frame is not received yet, it requests rollback and waits for more data.
+//
+// Decoded values are passed to the next handler, and outbound values are encoded into a *Buffer.
+// Outbound *Buffer is regarded as already encoded and passed as it is.
+type GobCodec struct {
+	typ reflect.Type
+}
+
+type gobState struct {
+	enc    *gob.Encoder
+	encBuf bytes.Buffer
+	reset  bool // enc is recreated after an error, so the decoder of the peer should be reset.
+	dec    *gob.Decoder
+	decIn  gobReader
+}
+
+// NewGobCodec returns a GobCodec that transfers values of the type of prototype.
+// If prototype is a pointer, decoded values are pointers to new values.
+// If prototype is nil, values are transferred as interface values, so one connection can carry multiple types.
+// In that case, all types should be registered with Register() on both sides.
+func NewGobCodec(prototype interface{}) *GobCodec {
+	codec := new(GobCodec)
+	if prototype != nil {
+		codec.typ = reflect.TypeOf(prototype)
+	}
+	return codec
+}
+
+// Register records a type that is transferred as an interface value. It calls gob.Register().
+func (c *GobCodec) Register(prototype interface{}) {
+	gob.Register(prototype)
+}
+
+// OnRead implements ReadHandler interface.
+func (c *GobCodec) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	return decodeFrames(ctx, c, in, func(buffer *Buffer) (interface{}, error) {
+		return c.decode(ctx, buffer)
+	})
+}
+
+// decode decodes a value from buffer. It returns nil if a whole frame is not received yet.
+func (c *GobCodec) decode(ctx *SoContext, buffer *Buffer) (interface{}, error) {
+	data := buffer.Data()
+	for len(data) >= 4 && binary.BigEndian.Uint32(data) == 0 {
+		c.state(ctx).dec = nil
+		buffer.DataConsume(4)
+		data = buffer.Data()
+	}
+	if len(data) < 4 {
+		return nil, nil
+	}
+	size := binary.BigEndian.Uint32(data)
+	if size > maxGobFrameSize {
+		return nil, ErrFrameTooLong
+	}
+	if uint64(len(data)-4) < uint64(size) {
+		return nil, nil
+	}
+
+	state := c.state(ctx)
+	if state.dec == nil {
+		state.dec = gob.NewDecoder(&state.decIn)
+	}
+	state.decIn.data = data[4 : 4+int(size)]
+	buffer.DataConsume(4 + int(size))
+
+	var err error
+	if c.typ == nil {
+		var value interface{}
+		if err = state.dec.Decode(&value); err != nil {
+			state.dec = nil
+			return nil, err
+		}
+		return value, nil
+	}
+
+	if c.typ.Kind() == reflect.Ptr {
+		value := reflect.New(c.typ.Elem())
+		if err = state.dec.DecodeValue(value); err != nil {
+			state.dec = nil
+			return nil, err
+		}
+		return value.Interface(), nil
+	}
+
+	value := reflect.New(c.typ)
+	if err = state.dec.DecodeValue(value); err != nil {
+		state.dec = nil
+		return nil, err
+	}
+	return value.Elem().Interface(), nil
+}
+
+// OnWrite implements WriteHandler interface.
+func (c *GobCodec) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	if buffer, ok := out.(*Buffer); ok {
+		return buffer, nil
+	}
+
+	state := c.state(ctx)
+	state.encBuf.Reset()
+	state.encBuf.Write([]byte{0, 0, 0, 0}) // space for the length of the frame.
+
+	var err error
+	if c.typ == nil {
+		err = state.enc.Encode(&out)
+	} else {
+		err = state.enc.Encode(out)
+	}
+	if err != nil {
+		state.resetEncoder()
+		return nil, err
+	}
+
+	frame := state.encBuf.Bytes()
+	if len(frame)-4 > maxGobFrameSize {
+		state.resetEncoder()
+		return nil, ErrFrameTooLong
+	}
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+
+	buffer := NewBuffer(4 + len(frame))
+	if state.reset {
+		buffer.Write([]byte{0, 0, 0, 0}) // an empty frame resets the decoder of the peer.
+		state.reset = false
+	}
+	buffer.Write(frame)
+	return buffer, nil
+}
+
+func (c *GobCodec) state(ctx *SoContext) *gobState {
+	if state, ok := ctx.Value(c).(*gobState); ok {
+		return state
+	}
+
+	state := new(gobState)
+	state.enc = gob.NewEncoder(&state.encBuf)
+	state.dec = gob.NewDecoder(&state.decIn)
+	ctx.SetValue(c, state)
+	return state
+}
+
+// resetEncoder recreates the encoder. After an encoding error, the encoder may regard type definitions
+// that are not sent to the peer as sent, so the encoder and the decoder of the peer start over.
+func (s *gobState) resetEncoder() {
+	s.enc = gob.NewEncoder(&s.encBuf)
+	s.reset = true
+}
+
+// gobReader supplies a whole frame to gob.Decoder. It implements io.ByteReader, so gob.Decoder doesn't read ahead.
+type gobReader struct {
+	data []byte
+}
+
+func (r *gobReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *gobReader) ReadByte() (byte, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b, nil
+}
@@ -1,11 +1,22 @@
 package net
 
 // ReadHandler is the interface that wraps the Read event handler method.
+// The result of OnRead is passed to the next ReadHandler. If the result is nil, the rest of the chain is not called.
 type ReadHandler interface {
 	OnRead(ctx *SoContext, in interface{}) (interface{}, error)
 }
 
+// EOFHandler is the interface that wraps the EOF event handler method. It is implemented by a ReadHandler
+// that processes data delimited by the end of the inbound stream.
+// When the peer closes the connection, OnEOF is called on the ReadHandlers that implement EOFHandler in order,
+// and the result is passed to the ReadHandlers after it. The connection is closed after all data written by handlers is sent.
+// If no ReadHandler implements EOFHandler, the connection is closed as soon as the peer closes it.
+type EOFHandler interface {
+	OnEOF(ctx *SoContext) (interface{}, error)
+}
+
 // WriteHandler is the interface that wraps the Write event handler method.
+// The result of OnWrite is passed to the next WriteHandler. If the result is nil, nothing is written.
 type WriteHandler interface {
 	OnWrite(ctx *SoContext, out interface{}) (interface{}, error)
 }
@@ -30,3 +41,11 @@ type TimeoutHandler interface {
 type ErrorHandler interface {
 	OnError(ctx *SoContext, err error)
 }
+
+// TrafficHandler is the interface that wraps the Traffic event handler method.
+// OnTraffic is called with the bytes read from or written to the connection before decoding or after encoding.
+// On TLS connections, the bytes are the plaintext of the records.
+// OnTraffic can be called concurrently for inbound and outbound bytes, and data should not be retained after it returns.
+type TrafficHandler interface {
+	OnTraffic(ctx *SoContext, inbound bool, data []byte)
+}
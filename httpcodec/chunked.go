@@ -0,0 +1,130 @@
+package httpcodec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+var crlf = []byte("\r\n")
+
+// errIncomplete means that data ends in the middle of a message.
+var errIncomplete = errors.New("httpcodec: incomplete message")
+
+// ErrBodyTooLarge is returned when the body of a message exceeds the size limit.
+var ErrBodyTooLarge = errors.New("httpcodec: body too large")
+
+// ErrHeaderTooLarge is returned when the header section of a message exceeds the size limit.
+var ErrHeaderTooLarge = errors.New("httpcodec: header too large")
+
+// headerEnd returns the size of the header section at the start of data including the empty line.
+// If the header section is not complete, it returns errIncomplete.
+func headerEnd(data []byte, maxSize int) (int, error) {
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(data) > maxSize {
+			return 0, ErrHeaderTooLarge
+		}
+		return 0, errIncomplete
+	}
+	if end+4 > maxSize {
+		return 0, ErrHeaderTooLarge
+	}
+	return end + 4, nil
+}
+
+// chunkedDecoder decodes a chunked body incrementally. The chunks decoded so far are kept,
+// so they are not parsed again when the rest of the body is received.
+type chunkedDecoder struct {
+	body []byte
+	n    int // size of data decoded so far
+}
+
+// decode decodes a chunked body at the start of data. It returns the body, the trailer and the size of data consumed.
+// If the body is not complete, it returns errIncomplete, and data of the next call should start with the same bytes.
+// After the body is decoded or an error is returned, the decoder is reset for the next body.
+func (d *chunkedDecoder) decode(data []byte, maxBodySize int64, maxHeaderSize int) (body []byte, trailer http.Header, n int, err error) {
+	body, trailer, n, err = d.next(data, maxBodySize, maxHeaderSize)
+	if err != errIncomplete {
+		*d = chunkedDecoder{}
+	}
+	return body, trailer, n, err
+}
+
+func (d *chunkedDecoder) next(data []byte, maxBodySize int64, maxHeaderSize int) ([]byte, http.Header, int, error) {
+	if d.body == nil {
+		d.body = []byte{}
+	}
+	for {
+		eol := bytes.Index(data[d.n:], crlf)
+		if eol < 0 {
+			if len(data)-d.n > maxHeaderSize {
+				return nil, nil, 0, errors.New("httpcodec: chunk size line too long")
+			}
+			return nil, nil, 0, errIncomplete
+		}
+
+		line := data[d.n : d.n+eol]
+		if ext := bytes.IndexByte(line, ';'); ext >= 0 {
+			line = line[:ext]
+		}
+		size, err := strconv.ParseUint(string(bytes.TrimSpace(line)), 16, 63)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("httpcodec: invalid chunk size - %q", line)
+		}
+		n := d.n + eol + 2
+
+		if size == 0 {
+			end := bytes.Index(data[n:], crlf)
+			if end == 0 { // no trailer
+				return d.body, nil, n + 2, nil
+			}
+			end, err := headerEnd(data[n:], maxHeaderSize)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			trailer, err := readHeader(data[n : n+end])
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			return d.body, trailer, n + end, nil
+		}
+
+		if int64(len(d.body))+int64(size) > maxBodySize {
+			return nil, nil, 0, ErrBodyTooLarge
+		}
+		if uint64(len(data)-n) < size+2 {
+			return nil, nil, 0, errIncomplete
+		}
+		d.body = append(d.body, data[n:n+int(size)]...)
+		n += int(size)
+		if !bytes.HasPrefix(data[n:], crlf) {
+			return nil, nil, 0, errors.New("httpcodec: missing CRLF after chunk data")
+		}
+		d.n = n + 2
+	}
+}
+
+// readHeader parses header fields terminated by an empty line.
+func readHeader(data []byte) (http.Header, error) {
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(data))).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	return http.Header(header), nil
+}
+
+// appendChunked appends body encoded with the chunked transfer coding.
+func appendChunked(dst []byte, body []byte) []byte {
+	if len(body) > 0 {
+		dst = strconv.AppendInt(dst, int64(len(body)), 16)
+		dst = append(dst, crlf...)
+		dst = append(dst, body...)
+		dst = append(dst, crlf...)
+	}
+	return append(dst, "0\r\n\r\n"...)
+}
@@ -0,0 +1,194 @@
+package httpcodec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/shanpark/net"
+)
+
+// ClientCodec is a net.ReadHandler, net.EOFHandler and net.WriteHandler that encodes HTTP/1.1 requests and decodes responses.
+// ClientCodec should be placed on the stream directly.
+//
+// Requests should be written as *http.Request. Other outbound messages are passed as they are.
+// Responses are correlated with the requests in order, and each response is passed to the next handler as an *http.Response
+// whose body is already read and whose Request field is the request that it answers.
+// Bodies delimited by content-length, chunked transfer coding and the close of the connection are supported.
+// Interim responses (1xx) other than "101 Switching Protocols" are discarded.
+type ClientCodec struct {
+	maxHeaderSize int
+	maxBodySize   int64
+}
+
+type clientState struct {
+	pending []*http.Request // requests waiting for responses
+	buffer  *net.Buffer     // the read buffer for OnEOF()
+	chunked chunkedDecoder
+}
+
+// NewClientCodec returns a ClientCodec with the default limits.
+func NewClientCodec() *ClientCodec {
+	codec := new(ClientCodec)
+	codec.maxHeaderSize = DefaultMaxHeaderSize
+	codec.maxBodySize = DefaultMaxBodySize
+	return codec
+}
+
+// SetMaxHeaderSize sets the maximum size of the status line and the header fields.
+func (c *ClientCodec) SetMaxHeaderSize(size int) {
+	c.maxHeaderSize = size
+}
+
+// SetMaxBodySize sets the maximum size of a response body.
+func (c *ClientCodec) SetMaxBodySize(size int64) {
+	c.maxBodySize = size
+}
+
+// OnWrite implements net.WriteHandler interface.
+func (c *ClientCodec) OnWrite(ctx *net.SoContext, out interface{}) (interface{}, error) {
+	req, ok := out.(*http.Request)
+	if !ok {
+		return out, nil
+	}
+
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	state := c.state(ctx)
+	state.pending = append(state.pending, req)
+
+	buffer := net.NewBuffer(buf.Len())
+	buffer.Write(buf.Bytes())
+	return buffer, nil
+}
+
+// OnRead implements net.ReadHandler interface.
+func (c *ClientCodec) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("httpcodec: unsupported inbound message type - %T", in)
+	}
+
+	state := c.state(ctx)
+	state.buffer = buffer
+	if buffer.Readable() == 0 {
+		ctx.Rollback()
+		return nil, nil
+	}
+
+	for {
+		resp, err := c.next(state, buffer, false)
+		if err == errIncomplete {
+			ctx.Rollback()
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil {
+			return resp, nil
+		}
+		if buffer.Readable() == 0 {
+			return nil, nil
+		}
+	}
+}
+
+// OnEOF implements net.EOFHandler interface. A response delimited by the close of the connection is passed to the next handler.
+// If the connection is closed in the middle of a response, it returns io.ErrUnexpectedEOF.
+func (c *ClientCodec) OnEOF(ctx *net.SoContext) (interface{}, error) {
+	state := c.state(ctx)
+	for state.buffer != nil && state.buffer.Readable() > 0 {
+		resp, err := c.next(state, state.buffer, true)
+		if err == errIncomplete {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil {
+			return resp, nil
+		}
+	}
+	return nil, nil
+}
+
+// next decodes a response from buffer. It returns nil without an error if an interim response is discarded.
+func (c *ClientCodec) next(state *clientState, buffer *net.Buffer, eof bool) (*http.Response, error) {
+	if len(state.pending) == 0 {
+		return nil, errors.New("httpcodec: response without request")
+	}
+
+	resp, size, err := c.readResponse(state, buffer.Data(), state.pending[0], eof)
+	if err != nil {
+		return nil, err
+	}
+	buffer.DataConsume(size)
+
+	if resp.StatusCode/100 == 1 && resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, nil // discard interim responses.
+	}
+	state.pending = state.pending[1:]
+	return resp, nil
+}
+
+// readResponse decodes a response at the start of data. A body delimited by the close of the connection is complete only if eof is true.
+func (c *ClientCodec) readResponse(state *clientState, data []byte, req *http.Request, eof bool) (*http.Response, int, error) {
+	headerSize, err := headerEnd(data, c.maxHeaderSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data[:headerSize])), req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var body []byte
+	var bodySize int
+	switch {
+	case resp.StatusCode/100 == 1 || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified || req.Method == http.MethodHead:
+	case len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked":
+		body, resp.Trailer, bodySize, err = state.chunked.decode(data[headerSize:], c.maxBodySize, c.maxHeaderSize)
+		if err != nil {
+			return nil, 0, err
+		}
+	case resp.ContentLength > c.maxBodySize:
+		return nil, 0, ErrBodyTooLarge
+	case resp.ContentLength >= 0:
+		if int64(len(data)-headerSize) < resp.ContentLength {
+			return nil, 0, errIncomplete
+		}
+		bodySize = int(resp.ContentLength)
+		body = append([]byte(nil), data[headerSize:headerSize+bodySize]...)
+	default: // delimited by the close of the connection.
+		bodySize = len(data) - headerSize
+		if int64(bodySize) > c.maxBodySize {
+			return nil, 0, ErrBodyTooLarge
+		}
+		if !eof {
+			return nil, 0, errIncomplete
+		}
+		body = append([]byte(nil), data[headerSize:]...)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, headerSize + bodySize, nil
+}
+
+func (c *ClientCodec) state(ctx *net.SoContext) *clientState {
+	if state, ok := ctx.Value(c).(*clientState); ok {
+		return state
+	}
+
+	state := new(clientState)
+	ctx.SetValue(c, state)
+	return state
+}
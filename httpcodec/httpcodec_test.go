@@ -0,0 +1,201 @@
+package httpcodec
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shanpark/net"
+)
+
+// readOutbound returns all outbound data of p as a string.
+func readOutbound(p *net.EmbeddedPipeline) string {
+	var sb strings.Builder
+	for msg := p.ReadOutbound(); msg != nil; msg = p.ReadOutbound() {
+		switch data := msg.(type) {
+		case *net.Buffer:
+			sb.Write(data.Data())
+		case string:
+			sb.WriteString(data)
+		}
+	}
+	return sb.String()
+}
+
+// writeFragments writes data to the inbound of p size bytes at a time.
+func writeFragments(t *testing.T, p *net.EmbeddedPipeline, data string, size int) {
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		if err := p.WriteInbound(data[:n]); err != nil {
+			t.Fatalf("WriteInbound(%q) = %v", data[:n], err)
+		}
+		data = data[n:]
+	}
+}
+
+func readBody(body io.Reader) string {
+	data, _ := io.ReadAll(body)
+	return string(data)
+}
+
+func TestServerCodec(t *testing.T) {
+	p, _ := net.NewEmbeddedPipeline(NewServerCodec())
+
+	writeFragments(t, p, "\r\nGET /a HTTP/1.1\r\nHost: x\r\n\r\nPOST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello", 1)
+	get, ok := p.ReadInbound().(*http.Request)
+	if !ok || get.Method != http.MethodGet || get.URL.Path != "/a" || readBody(get.Body) != "" {
+		t.Fatalf("first request = %v", get)
+	}
+	post, ok := p.ReadInbound().(*http.Request)
+	if !ok || post.Method != http.MethodPost || post.URL.Path != "/b" || readBody(post.Body) != "hello" {
+		t.Fatalf("second request = %v", post)
+	}
+
+	resp := NewResponse(get, http.StatusOK, []byte("ok"))
+	resp.Header.Set("Content-Type", "text/plain")
+	p.WriteOutbound(resp)
+	out, err := http.ReadResponse(bufio.NewReader(strings.NewReader(readOutbound(p))), get)
+	if err != nil || out.StatusCode != http.StatusOK || out.ContentLength != 2 || out.Header.Get("Content-Type") != "text/plain" || readBody(out.Body) != "ok" {
+		t.Errorf("response = %v, %v", out, err)
+	}
+
+	resp = NewResponse(post, http.StatusCreated, []byte("created"))
+	resp.Header.Set("Transfer-Encoding", "chunked")
+	resp.Close = true
+	p.WriteOutbound(resp)
+	out, err = http.ReadResponse(bufio.NewReader(strings.NewReader(readOutbound(p))), post)
+	if err != nil || out.StatusCode != http.StatusCreated || !out.Close || readBody(out.Body) != "created" || !p.IsClosed() {
+		t.Errorf("chunked response = %v, %v, closed %v", out, err, p.IsClosed())
+	}
+}
+
+func TestServerCodecChunked(t *testing.T) {
+	p, _ := net.NewEmbeddedPipeline(NewServerCodec())
+
+	header := "POST /upload HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nExpect: 100-continue\r\n\r\n"
+	if err := p.WriteInbound(header); err != nil || !p.IsRollback() || readOutbound(p) != "HTTP/1.1 100 Continue\r\n\r\n" {
+		t.Fatalf("WriteInbound() of header = %v, rollback %v", err, p.IsRollback())
+	}
+	writeFragments(t, p, "5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nX-Sum: 11\r\n\r\n", 3)
+	req, ok := p.ReadInbound().(*http.Request)
+	if !ok || readBody(req.Body) != "hello world" || req.ContentLength != 11 || req.Trailer.Get("X-Sum") != "11" {
+		t.Fatalf("request = %v", req)
+	}
+	if out := readOutbound(p); out != "" {
+		t.Errorf("100 Continue sent again - %q", out)
+	}
+}
+
+func TestServerCodecLimits(t *testing.T) {
+	codec := NewServerCodec()
+	codec.SetMaxHeaderSize(64)
+	codec.SetMaxBodySize(8)
+
+	p, _ := net.NewEmbeddedPipeline(codec)
+	if err := p.WriteInbound("GET / HTTP/1.1\r\nHost: x\r\nX-Long: " + strings.Repeat("a", 64)); err != ErrHeaderTooLarge {
+		t.Errorf("WriteInbound() of long header = %v", err)
+	}
+	if out := readOutbound(p); !strings.HasPrefix(out, "HTTP/1.1 431 ") || !p.IsClosed() {
+		t.Errorf("response = %q, closed %v", out, p.IsClosed())
+	}
+
+	p, _ = net.NewEmbeddedPipeline(codec)
+	if err := p.WriteInbound("POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\n"); err != ErrBodyTooLarge {
+		t.Errorf("WriteInbound() of large chunked body = %v", err)
+	}
+	if out := readOutbound(p); !strings.HasPrefix(out, "HTTP/1.1 413 ") {
+		t.Errorf("response = %q", out)
+	}
+
+	p, _ = net.NewEmbeddedPipeline(codec)
+	if err := p.WriteInbound("GET / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"); err == nil {
+		t.Error("invalid chunk size decoded")
+	}
+}
+
+// TestChunkedDecoder verifies that chunks already decoded are not parsed again as more data is received.
+func TestChunkedDecoder(t *testing.T) {
+	data := []byte("3\r\nabc\r\n4\r\ndefg\r\n0\r\n\r\nnext")
+	var d chunkedDecoder
+	decoded := 0
+	for i := 0; i < len(data)-4; i++ {
+		if _, _, _, err := d.decode(data[:i], 100, 100); err != errIncomplete {
+			t.Fatalf("decode(data[:%d]) = %v", i, err)
+		}
+		if d.n < decoded {
+			t.Fatalf("decoded size decreased from %d to %d", decoded, d.n)
+		}
+		decoded = d.n
+	}
+	if decoded != 17 { // the size of the 2 chunks
+		t.Errorf("decoded size of incomplete body = %d", decoded)
+	}
+
+	body, trailer, n, err := d.decode(data, 100, 100)
+	if err != nil || string(body) != "abcdefg" || trailer != nil || n != len(data)-4 {
+		t.Errorf("decode() = %q, %v, %d, %v", body, trailer, n, err)
+	}
+	if d.n != 0 || d.body != nil {
+		t.Errorf("decoder is not reset - %d, %q", d.n, d.body)
+	}
+}
+
+func TestClientCodec(t *testing.T) {
+	p, _ := net.NewEmbeddedPipeline(NewClientCodec())
+
+	get, _ := http.NewRequest(http.MethodGet, "http://example.com/a", nil)
+	head, _ := http.NewRequest(http.MethodHead, "http://example.com/b", nil)
+	post, _ := http.NewRequest(http.MethodPost, "http://example.com/c", strings.NewReader("data"))
+	p.WriteOutbound(get, head, post)
+	out := readOutbound(p)
+	if !strings.HasPrefix(out, "GET /a HTTP/1.1\r\nHost: example.com\r\n") || !strings.Contains(out, "\r\n\r\nHEAD /b HTTP/1.1\r\n") || !strings.HasSuffix(out, "\r\n\r\ndata") {
+		t.Errorf("requests = %q", out)
+	}
+
+	writeFragments(t, p, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"+
+		"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n"+
+		"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 201 Created\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n", 4)
+	for _, want := range []struct {
+		req    *http.Request
+		status int
+		body   string
+	}{{get, 200, "hello"}, {head, 200, ""}, {post, 201, "ok"}} {
+		resp, ok := p.ReadInbound().(*http.Response)
+		if !ok || resp.Request != want.req || resp.StatusCode != want.status || readBody(resp.Body) != want.body {
+			t.Errorf("response to %s = %v", want.req.Method, resp)
+		}
+	}
+
+	if err := p.WriteInbound("HTTP/1.1 200 OK\r\n\r\n"); err == nil {
+		t.Error("response without request decoded")
+	}
+}
+
+func TestClientCodecEOF(t *testing.T) {
+	p, _ := net.NewEmbeddedPipeline(NewClientCodec())
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	p.WriteOutbound(req)
+
+	if err := p.WriteInbound("HTTP/1.0 200 OK\r\n\r\nuntil "); err != nil || !p.IsRollback() || p.ReadInbound() != nil {
+		t.Fatalf("WriteInbound() = %v, rollback %v", err, p.IsRollback())
+	}
+	p.WriteInbound("close")
+	if err := p.WriteEOF(); err != nil || !p.IsClosed() {
+		t.Fatalf("WriteEOF() = %v, closed %v", err, p.IsClosed())
+	}
+	if resp, ok := p.ReadInbound().(*http.Response); !ok || resp.Request != req || readBody(resp.Body) != "until close" {
+		t.Errorf("response = %v", resp)
+	}
+
+	p, _ = net.NewEmbeddedPipeline(NewClientCodec())
+	p.WriteOutbound(req)
+	p.WriteInbound("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc")
+	if err := p.WriteEOF(); err != io.ErrUnexpectedEOF {
+		t.Errorf("WriteEOF() in the middle of a response = %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package httpcodec
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Response represents an HTTP response written by the handlers to ServerCodec.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// Close indicates that the connection is closed after the response is sent.
+	// The connection is also closed if the request asked for it.
+	Close bool
+
+	// Request is the request that the response answers. It is used for HEAD requests and keep-alive handling.
+	Request *http.Request
+}
+
+// NewResponse returns a Response to the request.
+func NewResponse(req *http.Request, statusCode int, body []byte) *Response {
+	resp := new(Response)
+	resp.StatusCode = statusCode
+	resp.Header = make(http.Header)
+	resp.Body = body
+	resp.Request = req
+	return resp
+}
+
+func (r *Response) closing() bool {
+	return r.Close || (r.Request != nil && r.Request.Close)
+}
+
+func (r *Response) appendTo(dst []byte) []byte {
+	dst = append(dst, "HTTP/1.1 "...)
+	dst = strconv.AppendInt(dst, int64(r.StatusCode), 10)
+	dst = append(dst, ' ')
+	dst = append(dst, http.StatusText(r.StatusCode)...)
+	dst = append(dst, crlf...)
+
+	header := r.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	switch {
+	case r.closing():
+		header.Set("Connection", "close")
+	case r.Request != nil && !r.Request.ProtoAtLeast(1, 1):
+		header.Set("Connection", "keep-alive")
+	}
+
+	noBody := r.StatusCode/100 == 1 || r.StatusCode == http.StatusNoContent || r.StatusCode == http.StatusNotModified
+	chunked := !noBody && header.Get("Transfer-Encoding") == "chunked"
+	if !noBody && !chunked {
+		header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+	}
+
+	var buf bytes.Buffer
+	header.Write(&buf)
+	dst = append(dst, buf.Bytes()...)
+	dst = append(dst, crlf...)
+
+	if noBody || (r.Request != nil && r.Request.Method == http.MethodHead) {
+		return dst
+	}
+	if chunked {
+		return appendChunked(dst, r.Body)
+	}
+	return append(dst, r.Body...)
+}
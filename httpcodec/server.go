@@ -0,0 +1,186 @@
+// Package httpcodec provides HTTP/1.1 codecs that work on the pipeline of package net.
+// ServerCodec decodes requests and encodes responses on a TCPServer or TLSServer,
+// and ClientCodec encodes requests and decodes responses on a TCPClient or TLSClient.
+package httpcodec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/shanpark/net"
+)
+
+// Default limits of the codecs.
+const (
+	DefaultMaxHeaderSize = 64 * 1024
+	DefaultMaxBodySize   = 10 * 1024 * 1024
+)
+
+// ServerCodec is a net.ReadHandler and net.WriteHandler that decodes HTTP/1.1 requests and encodes responses.
+// ServerCodec should be placed on the stream directly.
+//
+// Each request is passed to the next handler as an *http.Request whose body is already read.
+// Chunked requests, keep-alive and pipelining are supported, and "100 Continue" is sent for requests with "Expect: 100-continue".
+// Responses should be written as *Response or *http.Response in the order of the requests.
+// Other outbound messages are passed as they are.
+// If a request is malformed or exceeds the limits, ServerCodec sends an error response, closes the connection and returns the error.
+type ServerCodec struct {
+	maxHeaderSize int
+	maxBodySize   int64
+}
+
+type serverState struct {
+	continueSent bool
+	closing      bool
+	chunked      chunkedDecoder
+}
+
+// NewServerCodec returns a ServerCodec with the default limits.
+func NewServerCodec() *ServerCodec {
+	codec := new(ServerCodec)
+	codec.maxHeaderSize = DefaultMaxHeaderSize
+	codec.maxBodySize = DefaultMaxBodySize
+	return codec
+}
+
+// SetMaxHeaderSize sets the maximum size of the request line and the header fields.
+func (c *ServerCodec) SetMaxHeaderSize(size int) {
+	c.maxHeaderSize = size
+}
+
+// SetMaxBodySize sets the maximum size of a request body.
+func (c *ServerCodec) SetMaxBodySize(size int64) {
+	c.maxBodySize = size
+}
+
+// OnRead implements net.ReadHandler interface.
+func (c *ServerCodec) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("httpcodec: unsupported inbound message type - %T", in)
+	}
+
+	state := c.state(ctx)
+	if state.closing { // ignore requests after the one that closes the connection.
+		ctx.Rollback()
+		return nil, nil
+	}
+
+	data := buffer.Data()
+	for bytes.HasPrefix(data, crlf) { // ignore empty lines before the request line.
+		buffer.DataConsume(2)
+		data = data[2:]
+	}
+
+	headerSize, err := headerEnd(data, c.maxHeaderSize)
+	if err == errIncomplete {
+		ctx.Rollback()
+		return nil, nil
+	}
+	if err != nil {
+		return c.fail(ctx, http.StatusRequestHeaderFieldsTooLarge, err)
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data[:headerSize])))
+	if err != nil {
+		return c.fail(ctx, http.StatusBadRequest, err)
+	}
+
+	var body []byte
+	var bodySize int
+	switch {
+	case len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked":
+		body, req.Trailer, bodySize, err = state.chunked.decode(data[headerSize:], c.maxBodySize, c.maxHeaderSize)
+	case req.ContentLength > c.maxBodySize:
+		err = ErrBodyTooLarge
+	case int64(len(data)-headerSize) < req.ContentLength:
+		err = errIncomplete
+	default:
+		bodySize = int(req.ContentLength)
+		body = append([]byte(nil), data[headerSize:headerSize+bodySize]...)
+	}
+	switch err {
+	case nil:
+	case errIncomplete:
+		if !state.continueSent && req.ProtoAtLeast(1, 1) && strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+			ctx.WriteAndFlush("HTTP/1.1 100 Continue\r\n\r\n")
+			state.continueSent = true
+		}
+		ctx.Rollback()
+		return nil, nil
+	case ErrBodyTooLarge:
+		return c.fail(ctx, http.StatusRequestEntityTooLarge, err)
+	default:
+		return c.fail(ctx, http.StatusBadRequest, err)
+	}
+
+	buffer.DataConsume(headerSize + bodySize)
+	state.continueSent = false
+	state.closing = req.Close
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	if conn := ctx.Conn(); conn != nil {
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			cs := tlsConn.ConnectionState()
+			req.TLS = &cs
+		}
+	}
+	return req, nil
+}
+
+// OnWrite implements net.WriteHandler interface.
+func (c *ServerCodec) OnWrite(ctx *net.SoContext, out interface{}) (interface{}, error) {
+	var data []byte
+	var closing bool
+	switch resp := out.(type) {
+	case *Response:
+		data = resp.appendTo(nil)
+		closing = resp.closing()
+	case *http.Response:
+		r := *resp
+		if r.ProtoMajor == 0 {
+			r.ProtoMajor, r.ProtoMinor = 1, 1
+		}
+		var buf bytes.Buffer
+		if err := r.Write(&buf); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+		closing = r.Close || (r.Request != nil && r.Request.Close)
+	default:
+		return out, nil
+	}
+
+	if closing {
+		ctx.FlushAndClose()
+	}
+
+	buffer := net.NewBuffer(len(data))
+	buffer.Write(data)
+	return buffer, nil
+}
+
+func (c *ServerCodec) state(ctx *net.SoContext) *serverState {
+	if state, ok := ctx.Value(c).(*serverState); ok {
+		return state
+	}
+
+	state := new(serverState)
+	ctx.SetValue(c, state)
+	return state
+}
+
+func (c *ServerCodec) fail(ctx *net.SoContext, statusCode int, err error) (interface{}, error) {
+	c.state(ctx).closing = true
+	resp := NewResponse(nil, statusCode, []byte(http.StatusText(statusCode)))
+	resp.Close = true
+	ctx.Write(resp)
+	return nil, err
+}
@@ -19,6 +19,8 @@ type soProperty interface {
 	pipeline() *pipeline
 	readTimeout() time.Duration
 	writeTimeout() time.Duration
+	maxWriteBatch() int
+	autoFlush() bool
 }
 
 // Stream oriented service
@@ -0,0 +1,143 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// JSONCodec is a ReadHandler and WriteHandler that decodes inbound frames from JSON and encodes outbound values to JSON.
+// JSONCodec doesn't split the stream, so it should be placed after a frame decoder such as LineFrameDecoder or LengthFieldFrameDecoder.
+//
+// Each inbound frame is decoded into a value of the type of the prototype and passed to the next handler.
+// If a type field is set, the type of each frame is determined by the value of the field, so one connection can carry multiple message types.
+// Outbound values are marshaled into a *Buffer. Outbound *Buffer is regarded as already encoded and passed as it is.
+type JSONCodec struct {
+	defaultType reflect.Type
+	typeField   string
+	types       map[string]reflect.Type
+	names       map[reflect.Type]string
+}
+
+// NewJSONCodec returns a JSONCodec that decodes frames into values of the type of prototype.
+// If prototype is a pointer, decoded values are pointers to new values. For example, &Message{} makes *Message values.
+// If prototype is nil, frames are decoded into map[string]interface{}. json.RawMessage(nil) can be used to get raw messages.
+func NewJSONCodec(prototype interface{}) *JSONCodec {
+	codec := new(JSONCodec)
+	if prototype == nil {
+		codec.defaultType = reflect.TypeOf(map[string]interface{}(nil))
+	} else {
+		codec.defaultType = reflect.TypeOf(prototype)
+	}
+	codec.types = make(map[string]reflect.Type)
+	codec.names = make(map[reflect.Type]string)
+	return codec
+}
+
+// SetTypeField sets the name of the type-discriminator field.
+// The field of the inbound frames should have the name that is registered by Register().
+func (c *JSONCodec) SetTypeField(field string) {
+	c.typeField = field
+}
+
+// Register registers a message type with the name.
+// Inbound frames that have the name in the type field are decoded into values of the type of prototype.
+// Outbound values of the type of prototype are encoded with the name in the type field.
+func (c *JSONCodec) Register(name string, prototype interface{}) {
+	typ := reflect.TypeOf(prototype)
+	c.types[name] = typ
+	c.names[typ] = name
+}
+
+// OnRead implements ReadHandler interface.
+func (c *JSONCodec) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	data, err := inboundBytes(in)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := c.defaultType
+	if c.typeField != "" {
+		if typ, err = c.typeOf(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		value := reflect.New(typ.Elem())
+		if err = json.Unmarshal(data, value.Interface()); err != nil {
+			return nil, err
+		}
+		return value.Interface(), nil
+	}
+
+	value := reflect.New(typ)
+	if err = json.Unmarshal(data, value.Interface()); err != nil {
+		return nil, err
+	}
+	return value.Elem().Interface(), nil
+}
+
+// OnWrite implements WriteHandler interface.
+func (c *JSONCodec) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	if buffer, ok := out.(*Buffer); ok {
+		return buffer, nil
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.typeField != "" {
+		if name, ok := c.names[reflect.TypeOf(out)]; ok {
+			if data, err = c.withTypeField(data, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return newBufferOf(data), nil
+}
+
+func (c *JSONCodec) typeOf(data []byte) (reflect.Type, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	raw, ok := fields[c.typeField]
+	if !ok {
+		return c.defaultType, nil
+	}
+
+	var name string
+	if err := json.Unmarshal(raw, &name); err != nil {
+		return nil, fmt.Errorf("net: invalid json type field - %v", err)
+	}
+
+	typ, ok := c.types[name]
+	if !ok {
+		return nil, fmt.Errorf("net: unknown json message type - %q", name)
+	}
+	return typ, nil
+}
+
+func (c *JSONCodec) withTypeField(data []byte, name string) ([]byte, error) {
+	if len(data) < 2 || data[0] != '{' {
+		return nil, fmt.Errorf("net: json message with type field should be an object - %q", name)
+	}
+
+	field, _ := json.Marshal(c.typeField)
+	value, _ := json.Marshal(name)
+
+	result := make([]byte, 0, len(data)+len(field)+len(value)+2)
+	result = append(result, '{')
+	result = append(result, field...)
+	result = append(result, ':')
+	result = append(result, value...)
+	if data[1] != '}' {
+		result = append(result, ',')
+	}
+	return append(result, data[1:]...), nil
+}
@@ -0,0 +1,186 @@
+// Package memnet implements an in-memory transport for tests.
+// A Listener accepts connections made by its own DialContext method, so a server and a client of package net
+// can be wired together without sockets:
+//
+//	listener := memnet.NewListener("server")
+//	server.SetListener(listener)
+//	client.SetDialer(listener)
+//
+// Connections are buffered pipes with deadlines. Latency and partial reads can be simulated with the options of the listener.
+package memnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBufferSize is the default size of the buffer of each direction of a connection.
+const DefaultBufferSize = 64 * 1024
+
+// ErrRefused is returned when a connection is dialed to a closed listener.
+var ErrRefused = errors.New("memnet: connection refused")
+
+// Addr is the address of an in-memory connection.
+type Addr string
+
+// Network returns "memnet".
+func (a Addr) Network() string {
+	return "memnet"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+// Conn is one end of an in-memory connection. It implements net.Conn.
+type Conn struct {
+	local  Addr
+	remote Addr
+	in     *pipe // data from the peer
+	out    *pipe // data to the peer
+	once   sync.Once
+}
+
+// Read implements net.Conn interface. It returns io.EOF after the peer is closed and all data is read.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+// Write implements net.Conn interface. It blocks while the buffer to the peer is full.
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.out.write(b)
+}
+
+// Close implements net.Conn interface.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		c.in.closeRead()
+		c.out.closeWrite()
+	})
+	return nil
+}
+
+// LocalAddr implements net.Conn interface.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr implements net.Conn interface.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline implements net.Conn interface.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.in.setReadDeadline(t)
+	c.out.setWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline implements net.Conn interface.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.in.setReadDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn interface.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.out.setWriteDeadline(t)
+	return nil
+}
+
+// Listener is an in-memory listener. It implements net.Listener of the standard library
+// and the Dialer interface of package github.com/shanpark/net.
+type Listener struct {
+	addr       Addr
+	queue      chan *Conn
+	done       chan struct{}
+	once       sync.Once
+	dialed     int64
+	bufferSize int
+	latency    time.Duration
+	maxRead    int
+}
+
+// NewListener creates a Listener whose address is addr.
+func NewListener(addr string) *Listener {
+	listener := new(Listener)
+	listener.addr = Addr(addr)
+	listener.queue = make(chan *Conn)
+	listener.done = make(chan struct{})
+	listener.bufferSize = DefaultBufferSize
+	return listener
+}
+
+// SetBufferSize sets the size of the buffer of each direction of connections. Writes block while the buffer is full.
+func (l *Listener) SetBufferSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("memnet: invalid buffer size %d", size)
+	}
+	l.bufferSize = size
+	return nil
+}
+
+// SetLatency sets the time taken by written data to become readable by the peer.
+func (l *Listener) SetLatency(latency time.Duration) error {
+	l.latency = latency
+	return nil
+}
+
+// SetMaxRead sets the maximum number of bytes returned by a Read call to simulate partial reads. Zero means no limit.
+func (l *Listener) SetMaxRead(size int) error {
+	l.maxRead = size
+	return nil
+}
+
+// Accept implements net.Listener interface.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.queue:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener interface. Connections already accepted are not closed.
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr implements net.Listener interface.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// DialContext makes a connection to the listener. network and address are ignored.
+// It blocks until the connection is accepted, ctx is done or the listener is closed.
+func (l *Listener) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	clientAddr := Addr(fmt.Sprintf("%s-client-%d", l.addr, atomic.AddInt64(&l.dialed, 1)))
+	toServer := newPipe(l.bufferSize, l.latency, l.maxRead)
+	toClient := newPipe(l.bufferSize, l.latency, l.maxRead)
+	client := &Conn{local: clientAddr, remote: l.addr, in: toClient, out: toServer}
+	server := &Conn{local: l.addr, remote: clientAddr, in: toServer, out: toClient}
+
+	select {
+	case l.queue <- server:
+		return client, nil
+	case <-l.done:
+		return nil, ErrRefused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Dial makes a connection to the listener.
+func (l *Listener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background(), "memnet", string(l.addr))
+}
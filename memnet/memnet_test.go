@@ -0,0 +1,126 @@
+package memnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	snet "github.com/shanpark/net"
+)
+
+func TestConn(t *testing.T) {
+	listener := NewListener("test")
+	listener.SetBufferSize(4)
+	listener.SetMaxRead(3)
+	go func() {
+		conn, _ := listener.Accept()
+		conn.Write([]byte("hello, world"))
+		conn.Close()
+	}()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	n, err := conn.Read(buf)
+	if err != nil || n != 3 {
+		t.Errorf("Read() = %d, %v", n, err)
+	}
+	rest, err := io.ReadAll(conn)
+	if err != nil || string(buf[:n])+string(rest) != "hello, world" {
+		t.Errorf("ReadAll() = %q, %v", rest, err)
+	}
+	if _, err = conn.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("Write() to closed peer error = %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	conn.Close()
+	if _, err = conn.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Read() of closed conn error = %v", err)
+	}
+
+	listener.Close()
+	if _, err = listener.Dial(); err != ErrRefused {
+		t.Errorf("Dial() to closed listener error = %v", err)
+	}
+}
+
+func TestDeadlineAndLatency(t *testing.T) {
+	listener := NewListener("test")
+	listener.SetLatency(50 * time.Millisecond)
+	defer listener.Close()
+	go func() {
+		conn, _ := listener.Accept()
+		conn.Write([]byte("ping"))
+	}()
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 4))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() error = %v", err)
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	if _, err = io.ReadFull(conn, make([]byte, 4)); err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("ReadFull() = %v after %v", err, time.Since(start))
+	}
+}
+
+type echoHandler struct{}
+
+func (echoHandler) OnRead(ctx *snet.SoContext, in interface{}) (interface{}, error) {
+	buffer := in.(*snet.Buffer)
+	data := append([]byte{}, buffer.Data()...)
+	buffer.DataConsume(len(data))
+	ctx.Write(data)
+	return nil, nil
+}
+
+type collector chan string
+
+func (c collector) OnRead(ctx *snet.SoContext, in interface{}) (interface{}, error) {
+	buffer := in.(*snet.Buffer)
+	c <- string(buffer.Data())
+	buffer.DataConsume(buffer.Readable())
+	return nil, nil
+}
+
+func TestService(t *testing.T) {
+	listener := NewListener("echo")
+	server := snet.NewTCPServer()
+	server.SetListener(listener)
+	server.AddHandler(echoHandler{})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	received := make(collector, 1)
+	client := snet.NewTCPClient()
+	client.SetDialer(listener)
+	client.AddHandler(received)
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	client.Write([]byte("hello"))
+	select {
+	case msg := <-received:
+		if msg != "hello" {
+			t.Errorf("received %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no echo")
+	}
+}
@@ -0,0 +1,178 @@
+package memnet
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// chunk is data written to a pipe at once. It is readable from readyAt.
+type chunk struct {
+	data    []byte
+	readyAt time.Time
+}
+
+// pipe is a buffered one-way stream between a writer and a reader.
+type pipe struct {
+	mu       sync.Mutex
+	changed  chan struct{} // closed and replaced whenever the state changes.
+	chunks   []chunk
+	size     int
+	capacity int
+	latency  time.Duration
+	maxRead  int
+
+	readClosed    bool
+	writeClosed   bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newPipe(capacity int, latency time.Duration, maxRead int) *pipe {
+	p := new(pipe)
+	p.changed = make(chan struct{})
+	p.capacity = capacity
+	p.latency = latency
+	p.maxRead = maxRead
+	return p
+}
+
+// notify wakes up all waiters. p.mu should be held.
+func (p *pipe) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		switch {
+		case p.readClosed:
+			p.mu.Unlock()
+			return 0, net.ErrClosed
+		case !p.readDeadline.IsZero() && !now.Before(p.readDeadline):
+			p.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		case len(b) == 0:
+			p.mu.Unlock()
+			return 0, nil
+		}
+
+		if len(p.chunks) > 0 && !now.Before(p.chunks[0].readyAt) {
+			if p.maxRead > 0 && len(b) > p.maxRead {
+				b = b[:p.maxRead]
+			}
+			n := 0
+			for n < len(b) && len(p.chunks) > 0 && !now.Before(p.chunks[0].readyAt) {
+				copied := copy(b[n:], p.chunks[0].data)
+				n += copied
+				if copied == len(p.chunks[0].data) {
+					p.chunks = p.chunks[1:]
+				} else {
+					p.chunks[0].data = p.chunks[0].data[copied:]
+				}
+			}
+			p.size -= n
+			p.notify()
+			p.mu.Unlock()
+			return n, nil
+		}
+		if len(p.chunks) == 0 && p.writeClosed {
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+
+		wakeAt := p.readDeadline
+		if len(p.chunks) > 0 && (wakeAt.IsZero() || p.chunks[0].readyAt.Before(wakeAt)) {
+			wakeAt = p.chunks[0].readyAt
+		}
+		p.wait(wakeAt)
+	}
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	written := 0
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		switch {
+		case p.writeClosed:
+			p.mu.Unlock()
+			return written, net.ErrClosed
+		case p.readClosed:
+			p.mu.Unlock()
+			return written, io.ErrClosedPipe
+		case !p.writeDeadline.IsZero() && !now.Before(p.writeDeadline):
+			p.mu.Unlock()
+			return written, os.ErrDeadlineExceeded
+		case len(b) == 0:
+			p.mu.Unlock()
+			return written, nil
+		}
+
+		if space := p.capacity - p.size; space > 0 {
+			n := len(b)
+			if n > space {
+				n = space
+			}
+			p.chunks = append(p.chunks, chunk{append([]byte{}, b[:n]...), now.Add(p.latency)})
+			p.size += n
+			p.notify()
+			p.mu.Unlock()
+			b = b[n:]
+			written += n
+			continue
+		}
+		p.wait(p.writeDeadline)
+	}
+}
+
+// wait waits for a change of the state or the time wakeAt. p.mu should be held and it is released.
+func (p *pipe) wait(wakeAt time.Time) {
+	changed := p.changed
+	p.mu.Unlock()
+
+	if wakeAt.IsZero() {
+		<-changed
+		return
+	}
+	timer := time.NewTimer(time.Until(wakeAt))
+	defer timer.Stop()
+	select {
+	case <-changed:
+	case <-timer.C:
+	}
+}
+
+func (p *pipe) closeRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readClosed = true
+	p.chunks = nil
+	p.size = 0
+	p.notify()
+}
+
+func (p *pipe) closeWrite() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeClosed = true
+	p.notify()
+}
+
+func (p *pipe) setReadDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readDeadline = t
+	p.notify()
+}
+
+func (p *pipe) setWriteDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeDeadline = t
+	p.notify()
+}
@@ -0,0 +1,117 @@
+package mqtt
+
+import (
+	"fmt"
+
+	"github.com/shanpark/net"
+)
+
+// DefaultMaxPacketSize is the default maximum size of an inbound packet.
+const DefaultMaxPacketSize = 1024 * 1024
+
+// Codec is a net.ReadHandler and net.WriteHandler that decodes inbound MQTT packets and encodes outbound packets.
+// Codec should be placed on the stream directly. If a whole packet is not received yet, it requests rollback and waits for more data.
+//
+// The protocol version of a connection is decided by the CONNECT packet. A server learns it from the inbound CONNECT packet
+// and a client from the outbound one. Until then, the version set by SetVersion() is used.
+// Decoded packets are passed to the next handler as Packet values such as *Publish.
+// Outbound *net.Buffer is regarded as already encoded and passed as it is.
+type Codec struct {
+	version       byte
+	maxPacketSize int
+}
+
+type versionKey struct {
+	codec *Codec
+}
+
+// NewCodec returns a Codec whose default version is MQTT 3.1.1 and whose maximum packet size is DefaultMaxPacketSize.
+func NewCodec() *Codec {
+	codec := new(Codec)
+	codec.version = Version311
+	codec.maxPacketSize = DefaultMaxPacketSize
+	return codec
+}
+
+// SetVersion sets the protocol version used before the CONNECT packet is sent or received.
+func (c *Codec) SetVersion(version byte) {
+	c.version = version
+}
+
+// SetMaxPacketSize sets the maximum size of an inbound packet including the fixed header.
+// The limit is checked with the fixed header, so the connection doesn't have to buffer the whole packet to reject it.
+// If size is zero, the size is limited only by MaxRemainingLength.
+func (c *Codec) SetMaxPacketSize(size int) {
+	c.maxPacketSize = size
+}
+
+// Version returns the protocol version of the connection of ctx.
+func (c *Codec) Version(ctx *net.SoContext) byte {
+	if version, ok := ctx.Value(versionKey{c}).(byte); ok {
+		return version
+	}
+	return c.version
+}
+
+// OnRead implements net.ReadHandler interface.
+func (c *Codec) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("mqtt: unsupported inbound message type - %T", in)
+	}
+
+	data := buffer.Data()
+	size, err := PacketSize(data)
+	if err == ErrIncomplete {
+		ctx.Rollback()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.maxPacketSize > 0 && size > c.maxPacketSize {
+		return nil, net.ErrFrameTooLong
+	}
+	if len(data) < size {
+		ctx.Rollback()
+		return nil, nil
+	}
+
+	packet, n, err := Decode(data[:size], c.Version(ctx))
+	if err != nil {
+		return nil, err
+	}
+	buffer.DataConsume(n)
+
+	if connect, ok := packet.(*Connect); ok {
+		ctx.SetValue(versionKey{c}, connect.ProtocolVersion)
+	}
+	return packet, nil
+}
+
+// OnWrite implements net.WriteHandler interface.
+func (c *Codec) OnWrite(ctx *net.SoContext, out interface{}) (interface{}, error) {
+	var packet Packet
+	switch msg := out.(type) {
+	case *net.Buffer:
+		return msg, nil
+	case Packet:
+		packet = msg
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported outbound message type - %T", out)
+	}
+
+	version := c.Version(ctx)
+	if connect, ok := packet.(*Connect); ok && connect.ProtocolVersion != 0 {
+		version = connect.ProtocolVersion
+		ctx.SetValue(versionKey{c}, version)
+	}
+	data, err := Encode(packet, version)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := net.NewBuffer(len(data))
+	buffer.Write(data)
+	return buffer, nil
+}
@@ -0,0 +1,172 @@
+// Package mqtt implements encoding and decoding of MQTT 3.1.1 and 5.0 control packets without external dependencies.
+//
+// Each control packet is represented by a struct such as Connect or Publish, and pointers to them implement Packet.
+// Fields that exist only in MQTT 5.0 such as reason codes of acknowledgements and properties are ignored with MQTT 3.1.1.
+// Codec works on the pipeline of package net, so a broker or a client can be implemented on the servers and clients of package net.
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Protocol versions. The version is the protocol level of the CONNECT packet.
+const (
+	Version31  = 3
+	Version311 = 4
+	Version5   = 5
+)
+
+// Control packet types.
+const (
+	CONNECT     = 1
+	CONNACK     = 2
+	PUBLISH     = 3
+	PUBACK      = 4
+	PUBREC      = 5
+	PUBREL      = 6
+	PUBCOMP     = 7
+	SUBSCRIBE   = 8
+	SUBACK      = 9
+	UNSUBSCRIBE = 10
+	UNSUBACK    = 11
+	PINGREQ     = 12
+	PINGRESP    = 13
+	DISCONNECT  = 14
+	AUTH        = 15
+)
+
+// MaxRemainingLength is the maximum value of the remaining length of a packet.
+const MaxRemainingLength = 268435455
+
+// ErrIncomplete is returned when data ends in the middle of a packet.
+var ErrIncomplete = errors.New("mqtt: incomplete packet")
+
+// ErrMalformed is returned when a packet violates the format of the protocol.
+var ErrMalformed = errors.New("mqtt: malformed packet")
+
+// Packet is an MQTT control packet.
+type Packet interface {
+	// Type returns the control packet type.
+	Type() byte
+
+	// encode writes the variable header and the payload and returns the flags of the fixed header.
+	encode(w *writer, version byte) (byte, error)
+
+	// decode reads the variable header and the payload.
+	decode(r *reader, flags byte, version byte) error
+}
+
+// Decode decodes a packet at the start of data. It returns the packet and the size of data consumed.
+// version is the protocol version of the connection. It is ignored for CONNECT, which carries its own version.
+// If data doesn't contain a whole packet, it returns ErrIncomplete.
+func Decode(data []byte, version byte) (Packet, int, error) {
+	length, n, err := remainingLength(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := 1 + n + length
+	if len(data) < size {
+		return nil, 0, ErrIncomplete
+	}
+
+	packet := newPacket(data[0] >> 4)
+	if packet == nil {
+		return nil, 0, fmt.Errorf("mqtt: invalid packet type %d", data[0]>>4)
+	}
+	r := &reader{data: data[1+n : size]}
+	if err = packet.decode(r, data[0]&0x0f, version); err != nil {
+		return nil, 0, err
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if len(r.data) != r.pos {
+		return nil, 0, ErrMalformed
+	}
+	return packet, size, nil
+}
+
+// PacketSize returns the size of the packet at the start of data without decoding it.
+// If the fixed header is not complete, it returns ErrIncomplete.
+func PacketSize(data []byte) (int, error) {
+	length, n, err := remainingLength(data)
+	if err != nil {
+		return 0, err
+	}
+	return 1 + n + length, nil
+}
+
+// Encode returns the encoding of packet in version.
+func Encode(packet Packet, version byte) ([]byte, error) {
+	return Append(nil, packet, version)
+}
+
+// Append appends the encoding of packet in version to dst and returns the extended buffer.
+func Append(dst []byte, packet Packet, version byte) ([]byte, error) {
+	var body writer
+	flags, err := packet.encode(&body, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(body.data) > MaxRemainingLength {
+		return nil, errors.New("mqtt: packet too large")
+	}
+
+	dst = append(dst, packet.Type()<<4|flags)
+	dst = appendVarint(dst, uint32(len(body.data)))
+	return append(dst, body.data...), nil
+}
+
+// remainingLength decodes the remaining length of the fixed header at the start of data.
+// It returns the length and the size of the length field.
+func remainingLength(data []byte) (int, int, error) {
+	length := 0
+	for i := 0; i < 4; i++ {
+		if len(data) < i+2 {
+			return 0, 0, ErrIncomplete
+		}
+		b := data[i+1]
+		length |= int(b&0x7f) << (7 * uint(i))
+		if b&0x80 == 0 {
+			return length, i + 1, nil
+		}
+	}
+	return 0, 0, ErrMalformed
+}
+
+func newPacket(typ byte) Packet {
+	switch typ {
+	case CONNECT:
+		return new(Connect)
+	case CONNACK:
+		return new(Connack)
+	case PUBLISH:
+		return new(Publish)
+	case PUBACK:
+		return new(Puback)
+	case PUBREC:
+		return new(Pubrec)
+	case PUBREL:
+		return new(Pubrel)
+	case PUBCOMP:
+		return new(Pubcomp)
+	case SUBSCRIBE:
+		return new(Subscribe)
+	case SUBACK:
+		return new(Suback)
+	case UNSUBSCRIBE:
+		return new(Unsubscribe)
+	case UNSUBACK:
+		return new(Unsuback)
+	case PINGREQ:
+		return new(Pingreq)
+	case PINGRESP:
+		return new(Pingresp)
+	case DISCONNECT:
+		return new(Disconnect)
+	case AUTH:
+		return new(Auth)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package mqtt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/shanpark/net"
+)
+
+func TestConnectEncoding(t *testing.T) {
+	connect := &Connect{ProtocolVersion: Version311, CleanStart: true, KeepAlive: 60, ClientID: "c1"}
+	want := []byte{0x10, 0x0e, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 60, 0, 2, 'c', '1'}
+	data, err := Encode(connect, Version311)
+	if err != nil || !bytes.Equal(data, want) {
+		t.Errorf("Encode() = % x, %v", data, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	expiry := uint32(3600)
+	alias := uint16(7)
+	props := Properties{
+		MessageExpiryInterval:   &expiry,
+		TopicAlias:              &alias,
+		ContentType:             "text/plain",
+		CorrelationData:         []byte{1, 2},
+		SubscriptionIdentifiers: []uint32{1, 300000},
+		UserProperties:          []UserProperty{{"k", "v"}, {"k", "w"}},
+	}
+
+	packets := []Packet{
+		&Connect{ProtocolName: "MQTT", ProtocolVersion: Version5, CleanStart: true, KeepAlive: 30, Properties: props, ClientID: "id",
+			Will:         &Will{QoS: 1, Retain: true, Properties: props, Topic: "will", Payload: []byte("bye")},
+			UsernameFlag: true, Username: "user", PasswordFlag: true, Password: []byte("pw")},
+		&Connack{SessionPresent: true, ReasonCode: 0x87, Properties: props},
+		&Publish{Dup: true, QoS: 2, Retain: true, Topic: "a/b", PacketID: 10, Properties: props, Payload: []byte("payload")},
+		&Publish{Topic: "a/b", Payload: []byte{}},
+		&Puback{PacketID: 1},
+		&Pubrec{PacketID: 2, ReasonCode: 0x10},
+		&Pubrel{PacketID: 3, ReasonCode: 0x92, Properties: props},
+		&Pubcomp{PacketID: 4},
+		&Subscribe{PacketID: 5, Properties: props, Subscriptions: []Subscription{{"a/#", 1, true, true, 2}, {"b/+", 0, false, false, 0}}},
+		&Suback{PacketID: 5, Properties: props, ReasonCodes: []byte{1, 0x80}},
+		&Unsubscribe{PacketID: 6, Properties: props, TopicFilters: []string{"a/#", "b/+"}},
+		&Unsuback{PacketID: 6, Properties: props, ReasonCodes: []byte{0, 0x11}},
+		&Pingreq{},
+		&Pingresp{},
+		&Disconnect{ReasonCode: 0x8e, Properties: props},
+		&Disconnect{},
+		&Auth{ReasonCode: 0x18, Properties: props},
+	}
+
+	for _, packet := range packets {
+		data, err := Encode(packet, Version5)
+		if err != nil {
+			t.Errorf("Encode(%T) error = %v", packet, err)
+			continue
+		}
+		decoded, n, err := Decode(append(data, 0xc0), Version5)
+		if err != nil || n != len(data) || !reflect.DeepEqual(decoded, packet) {
+			t.Errorf("Decode(%T) = %+v, %d, %v", packet, decoded, n, err)
+		}
+		for i := 0; i < len(data); i++ {
+			if _, _, err = Decode(data[:i], Version5); err != ErrIncomplete {
+				t.Errorf("Decode(%T) of %d bytes error = %v", packet, i, err)
+			}
+		}
+	}
+}
+
+func TestVersion311(t *testing.T) {
+	packets := []Packet{
+		&Connack{ReasonCode: 5},
+		&Publish{QoS: 1, Topic: "t", PacketID: 9, Payload: []byte("x")},
+		&Puback{PacketID: 9},
+		&Suback{PacketID: 1, ReasonCodes: []byte{0, 0x80}},
+		&Unsuback{PacketID: 1},
+		&Disconnect{},
+	}
+	for _, packet := range packets {
+		data, err := Encode(packet, Version311)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, _, err := Decode(data, Version311)
+		if err != nil || !reflect.DeepEqual(decoded, packet) {
+			t.Errorf("Decode(%T) = %+v, %v", packet, decoded, err)
+		}
+	}
+
+	if _, _, err := Decode([]byte{0x62, 0x02, 0, 1}, Version311); err != nil {
+		t.Errorf("Decode(PUBREL) error = %v", err)
+	}
+	if _, _, err := Decode([]byte{0x60, 0x02, 0, 1}, Version311); err == nil {
+		t.Error("PUBREL with invalid flags accepted")
+	}
+	if _, _, err := Decode([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}, Version311); err != ErrMalformed {
+		t.Errorf("Decode() of invalid remaining length error = %v", err)
+	}
+}
+
+func TestCodecMaxPacketSize(t *testing.T) {
+	header := []byte{0x30, 0x80, 0x80, 0x80, 0x01} // PUBLISH with the remaining length of 2MB.
+
+	p, _ := net.NewEmbeddedPipeline(NewCodec())
+	if err := p.WriteInbound(header); err != net.ErrFrameTooLong {
+		t.Errorf("WriteInbound() with the default limit = %v", err)
+	}
+
+	codec := NewCodec()
+	codec.SetMaxPacketSize(4 * 1024 * 1024)
+	p, _ = net.NewEmbeddedPipeline(codec)
+	if err := p.WriteInbound(header); err != nil || !p.IsRollback() {
+		t.Errorf("WriteInbound() with a raised limit = %v, rollback %v", err, p.IsRollback())
+	}
+}
@@ -0,0 +1,697 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Connect is the CONNECT packet. ProtocolVersion decides the version of the connection.
+// If ProtocolVersion is 0, the version given to the encoder is used, and if ProtocolName is empty, the name of the version is used.
+type Connect struct {
+	ProtocolName    string
+	ProtocolVersion byte
+	CleanStart      bool // Clean Session in MQTT 3.1.1.
+	KeepAlive       uint16
+	Properties      Properties
+	ClientID        string
+	Will            *Will
+	UsernameFlag    bool
+	Username        string
+	PasswordFlag    bool
+	Password        []byte
+}
+
+// Will is the will message of Connect.
+type Will struct {
+	QoS        byte
+	Retain     bool
+	Properties Properties
+	Topic      string
+	Payload    []byte
+}
+
+// Connack is the CONNACK packet. ReasonCode is the return code in MQTT 3.1.1.
+type Connack struct {
+	SessionPresent bool
+	ReasonCode     byte
+	Properties     Properties
+}
+
+// Publish is the PUBLISH packet. PacketID is used only if QoS is greater than 0.
+type Publish struct {
+	Dup        bool
+	QoS        byte
+	Retain     bool
+	Topic      string
+	PacketID   uint16
+	Properties Properties
+	Payload    []byte
+}
+
+// Puback is the PUBACK packet.
+type Puback struct {
+	PacketID   uint16
+	ReasonCode byte
+	Properties Properties
+}
+
+// Pubrec is the PUBREC packet.
+type Pubrec struct {
+	PacketID   uint16
+	ReasonCode byte
+	Properties Properties
+}
+
+// Pubrel is the PUBREL packet.
+type Pubrel struct {
+	PacketID   uint16
+	ReasonCode byte
+	Properties Properties
+}
+
+// Pubcomp is the PUBCOMP packet.
+type Pubcomp struct {
+	PacketID   uint16
+	ReasonCode byte
+	Properties Properties
+}
+
+// Subscribe is the SUBSCRIBE packet.
+type Subscribe struct {
+	PacketID      uint16
+	Properties    Properties
+	Subscriptions []Subscription
+}
+
+// Subscription is a topic filter and its options of Subscribe.
+// The options other than QoS are used only in MQTT 5.0.
+type Subscription struct {
+	TopicFilter       string
+	QoS               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+// Suback is the SUBACK packet. ReasonCodes are the return codes in MQTT 3.1.1.
+type Suback struct {
+	PacketID    uint16
+	Properties  Properties
+	ReasonCodes []byte
+}
+
+// Unsubscribe is the UNSUBSCRIBE packet.
+type Unsubscribe struct {
+	PacketID     uint16
+	Properties   Properties
+	TopicFilters []string
+}
+
+// Unsuback is the UNSUBACK packet. ReasonCodes are used only in MQTT 5.0.
+type Unsuback struct {
+	PacketID    uint16
+	Properties  Properties
+	ReasonCodes []byte
+}
+
+// Pingreq is the PINGREQ packet.
+type Pingreq struct{}
+
+// Pingresp is the PINGRESP packet.
+type Pingresp struct{}
+
+// Disconnect is the DISCONNECT packet. ReasonCode and Properties are used only in MQTT 5.0.
+type Disconnect struct {
+	ReasonCode byte
+	Properties Properties
+}
+
+// Auth is the AUTH packet of MQTT 5.0.
+type Auth struct {
+	ReasonCode byte
+	Properties Properties
+}
+
+// Type implements Packet interface.
+func (p *Connect) Type() byte { return CONNECT }
+
+// Type implements Packet interface.
+func (p *Connack) Type() byte { return CONNACK }
+
+// Type implements Packet interface.
+func (p *Publish) Type() byte { return PUBLISH }
+
+// Type implements Packet interface.
+func (p *Puback) Type() byte { return PUBACK }
+
+// Type implements Packet interface.
+func (p *Pubrec) Type() byte { return PUBREC }
+
+// Type implements Packet interface.
+func (p *Pubrel) Type() byte { return PUBREL }
+
+// Type implements Packet interface.
+func (p *Pubcomp) Type() byte { return PUBCOMP }
+
+// Type implements Packet interface.
+func (p *Subscribe) Type() byte { return SUBSCRIBE }
+
+// Type implements Packet interface.
+func (p *Suback) Type() byte { return SUBACK }
+
+// Type implements Packet interface.
+func (p *Unsubscribe) Type() byte { return UNSUBSCRIBE }
+
+// Type implements Packet interface.
+func (p *Unsuback) Type() byte { return UNSUBACK }
+
+// Type implements Packet interface.
+func (p *Pingreq) Type() byte { return PINGREQ }
+
+// Type implements Packet interface.
+func (p *Pingresp) Type() byte { return PINGRESP }
+
+// Type implements Packet interface.
+func (p *Disconnect) Type() byte { return DISCONNECT }
+
+// Type implements Packet interface.
+func (p *Auth) Type() byte { return AUTH }
+
+func protocolName(version byte) string {
+	if version == Version31 {
+		return "MQIsdp"
+	}
+	return "MQTT"
+}
+
+func checkFlags(flags byte, want byte) error {
+	if flags != want {
+		return fmt.Errorf("mqtt: invalid flags 0x%x of fixed header", flags)
+	}
+	return nil
+}
+
+func checkQoS(qos byte) error {
+	if qos > 2 {
+		return fmt.Errorf("mqtt: invalid QoS %d", qos)
+	}
+	return nil
+}
+
+func (p *Connect) encode(w *writer, version byte) (byte, error) {
+	if p.ProtocolVersion != 0 {
+		version = p.ProtocolVersion
+	}
+	name := p.ProtocolName
+	if name == "" {
+		name = protocolName(version)
+	}
+	if err := w.string(name); err != nil {
+		return 0, err
+	}
+	w.byte(version)
+
+	var flags byte
+	if p.UsernameFlag {
+		flags |= 0x80
+	}
+	if p.PasswordFlag {
+		flags |= 0x40
+	}
+	if p.Will != nil {
+		if err := checkQoS(p.Will.QoS); err != nil {
+			return 0, err
+		}
+		flags |= 0x04 | p.Will.QoS<<3
+		if p.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if p.CleanStart {
+		flags |= 0x02
+	}
+	w.byte(flags)
+	w.uint16(p.KeepAlive)
+
+	v5 := version >= Version5
+	if v5 {
+		if err := p.Properties.encode(w); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.string(p.ClientID); err != nil {
+		return 0, err
+	}
+	if p.Will != nil {
+		if v5 {
+			if err := p.Will.Properties.encode(w); err != nil {
+				return 0, err
+			}
+		}
+		if err := w.string(p.Will.Topic); err != nil {
+			return 0, err
+		}
+		if err := w.binary(p.Will.Payload); err != nil {
+			return 0, err
+		}
+	}
+	if p.UsernameFlag {
+		if err := w.string(p.Username); err != nil {
+			return 0, err
+		}
+	}
+	if p.PasswordFlag {
+		if err := w.binary(p.Password); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+func (p *Connect) decode(r *reader, flags byte, version byte) error {
+	if err := checkFlags(flags, 0); err != nil {
+		return err
+	}
+	p.ProtocolName = r.string()
+	p.ProtocolVersion = r.byte()
+	if r.err != nil {
+		return r.err
+	}
+	switch p.ProtocolVersion {
+	case Version31, Version311, Version5:
+		if p.ProtocolName != protocolName(p.ProtocolVersion) {
+			return fmt.Errorf("mqtt: invalid protocol name %q", p.ProtocolName)
+		}
+	default:
+		return fmt.Errorf("mqtt: unsupported protocol version %d", p.ProtocolVersion)
+	}
+
+	connectFlags := r.byte()
+	if connectFlags&0x01 != 0 {
+		return ErrMalformed
+	}
+	p.UsernameFlag = connectFlags&0x80 != 0
+	p.PasswordFlag = connectFlags&0x40 != 0
+	p.CleanStart = connectFlags&0x02 != 0
+	p.KeepAlive = r.uint16()
+
+	v5 := p.ProtocolVersion >= Version5
+	if v5 {
+		if err := p.Properties.decode(r); err != nil {
+			return err
+		}
+	}
+	p.ClientID = r.string()
+	if connectFlags&0x04 != 0 {
+		p.Will = &Will{QoS: connectFlags >> 3 & 0x03, Retain: connectFlags&0x20 != 0}
+		if err := checkQoS(p.Will.QoS); err != nil {
+			return err
+		}
+		if v5 {
+			if err := p.Will.Properties.decode(r); err != nil {
+				return err
+			}
+		}
+		p.Will.Topic = r.string()
+		p.Will.Payload = r.binary()
+	} else if connectFlags&0x38 != 0 {
+		return ErrMalformed
+	}
+	if p.UsernameFlag {
+		p.Username = r.string()
+	}
+	if p.PasswordFlag {
+		p.Password = r.binary()
+	}
+	return r.err
+}
+
+func (p *Connack) encode(w *writer, version byte) (byte, error) {
+	if p.SessionPresent {
+		w.byte(0x01)
+	} else {
+		w.byte(0x00)
+	}
+	w.byte(p.ReasonCode)
+	if version >= Version5 {
+		return 0, p.Properties.encode(w)
+	}
+	return 0, nil
+}
+
+func (p *Connack) decode(r *reader, flags byte, version byte) error {
+	if err := checkFlags(flags, 0); err != nil {
+		return err
+	}
+	ackFlags := r.byte()
+	if ackFlags&0xfe != 0 {
+		return ErrMalformed
+	}
+	p.SessionPresent = ackFlags&0x01 != 0
+	p.ReasonCode = r.byte()
+	if version >= Version5 && r.err == nil {
+		return p.Properties.decode(r)
+	}
+	return r.err
+}
+
+func (p *Publish) encode(w *writer, version byte) (byte, error) {
+	if err := checkQoS(p.QoS); err != nil {
+		return 0, err
+	}
+	if err := w.string(p.Topic); err != nil {
+		return 0, err
+	}
+	if p.QoS > 0 {
+		w.uint16(p.PacketID)
+	}
+	if version >= Version5 {
+		if err := p.Properties.encode(w); err != nil {
+			return 0, err
+		}
+	}
+	w.data = append(w.data, p.Payload...)
+
+	flags := p.QoS << 1
+	if p.Dup {
+		flags |= 0x08
+	}
+	if p.Retain {
+		flags |= 0x01
+	}
+	return flags, nil
+}
+
+func (p *Publish) decode(r *reader, flags byte, version byte) error {
+	p.Dup = flags&0x08 != 0
+	p.QoS = flags >> 1 & 0x03
+	p.Retain = flags&0x01 != 0
+	if err := checkQoS(p.QoS); err != nil {
+		return err
+	}
+
+	p.Topic = r.string()
+	if p.QoS > 0 {
+		p.PacketID = r.uint16()
+	}
+	if version >= Version5 && r.err == nil {
+		if err := p.Properties.decode(r); err != nil {
+			return err
+		}
+	}
+	p.Payload = r.rest()
+	return r.err
+}
+
+// encodeAck writes the variable header of PUBACK, PUBREC, PUBREL and PUBCOMP.
+// The reason code and the properties are omitted if they are not needed.
+func encodeAck(w *writer, version byte, packetID uint16, reasonCode byte, props *Properties) error {
+	w.uint16(packetID)
+	if version < Version5 {
+		return nil
+	}
+	emptyProps := props.empty()
+	if reasonCode == 0 && emptyProps {
+		return nil
+	}
+	w.byte(reasonCode)
+	if emptyProps {
+		return nil
+	}
+	return props.encode(w)
+}
+
+func decodeAck(r *reader, version byte) (packetID uint16, reasonCode byte, props Properties, err error) {
+	packetID = r.uint16()
+	if version >= Version5 && r.remaining() > 0 {
+		reasonCode = r.byte()
+		if r.remaining() > 0 {
+			err = props.decode(r)
+		}
+	}
+	if err == nil {
+		err = r.err
+	}
+	return
+}
+
+func (p *Puback) encode(w *writer, version byte) (byte, error) {
+	return 0, encodeAck(w, version, p.PacketID, p.ReasonCode, &p.Properties)
+}
+
+func (p *Puback) decode(r *reader, flags byte, version byte) (err error) {
+	if err = checkFlags(flags, 0); err == nil {
+		p.PacketID, p.ReasonCode, p.Properties, err = decodeAck(r, version)
+	}
+	return err
+}
+
+func (p *Pubrec) encode(w *writer, version byte) (byte, error) {
+	return 0, encodeAck(w, version, p.PacketID, p.ReasonCode, &p.Properties)
+}
+
+func (p *Pubrec) decode(r *reader, flags byte, version byte) (err error) {
+	if err = checkFlags(flags, 0); err == nil {
+		p.PacketID, p.ReasonCode, p.Properties, err = decodeAck(r, version)
+	}
+	return err
+}
+
+func (p *Pubrel) encode(w *writer, version byte) (byte, error) {
+	return 0x02, encodeAck(w, version, p.PacketID, p.ReasonCode, &p.Properties)
+}
+
+func (p *Pubrel) decode(r *reader, flags byte, version byte) (err error) {
+	if err = checkFlags(flags, 0x02); err == nil {
+		p.PacketID, p.ReasonCode, p.Properties, err = decodeAck(r, version)
+	}
+	return err
+}
+
+func (p *Pubcomp) encode(w *writer, version byte) (byte, error) {
+	return 0, encodeAck(w, version, p.PacketID, p.ReasonCode, &p.Properties)
+}
+
+func (p *Pubcomp) decode(r *reader, flags byte, version byte) (err error) {
+	if err = checkFlags(flags, 0); err == nil {
+		p.PacketID, p.ReasonCode, p.Properties, err = decodeAck(r, version)
+	}
+	return err
+}
+
+func (p *Subscribe) encode(w *writer, version byte) (byte, error) {
+	if len(p.Subscriptions) == 0 {
+		return 0, errors.New("mqtt: no subscription in SUBSCRIBE")
+	}
+	w.uint16(p.PacketID)
+	if version >= Version5 {
+		if err := p.Properties.encode(w); err != nil {
+			return 0, err
+		}
+	}
+	for _, s := range p.Subscriptions {
+		if err := checkQoS(s.QoS); err != nil {
+			return 0, err
+		}
+		if err := w.string(s.TopicFilter); err != nil {
+			return 0, err
+		}
+		options := s.QoS
+		if version >= Version5 {
+			if s.NoLocal {
+				options |= 0x04
+			}
+			if s.RetainAsPublished {
+				options |= 0x08
+			}
+			options |= (s.RetainHandling & 0x03) << 4
+		}
+		w.byte(options)
+	}
+	return 0x02, nil
+}
+
+func (p *Subscribe) decode(r *reader, flags byte, version byte) error {
+	if err := checkFlags(flags, 0x02); err != nil {
+		return err
+	}
+	p.PacketID = r.uint16()
+	if version >= Version5 && r.err == nil {
+		if err := p.Properties.decode(r); err != nil {
+			return err
+		}
+	}
+	for r.remaining() > 0 && r.err == nil {
+		s := Subscription{TopicFilter: r.string()}
+		options := r.byte()
+		s.QoS = options & 0x03
+		if version >= Version5 {
+			s.NoLocal = options&0x04 != 0
+			s.RetainAsPublished = options&0x08 != 0
+			s.RetainHandling = options >> 4 & 0x03
+			if options&0xc0 != 0 || s.RetainHandling == 3 {
+				return ErrMalformed
+			}
+		} else if options&0xfc != 0 {
+			return ErrMalformed
+		}
+		if err := checkQoS(s.QoS); err != nil {
+			return err
+		}
+		p.Subscriptions = append(p.Subscriptions, s)
+	}
+	if r.err == nil && len(p.Subscriptions) == 0 {
+		return errors.New("mqtt: no subscription in SUBSCRIBE")
+	}
+	return r.err
+}
+
+func (p *Suback) encode(w *writer, version byte) (byte, error) {
+	w.uint16(p.PacketID)
+	if version >= Version5 {
+		if err := p.Properties.encode(w); err != nil {
+			return 0, err
+		}
+	}
+	w.data = append(w.data, p.ReasonCodes...)
+	return 0, nil
+}
+
+func (p *Suback) decode(r *reader, flags byte, version byte) error {
+	if err := checkFlags(flags, 0); err != nil {
+		return err
+	}
+	p.PacketID = r.uint16()
+	if version >= Version5 && r.err == nil {
+		if err := p.Properties.decode(r); err != nil {
+			return err
+		}
+	}
+	p.ReasonCodes = r.rest()
+	return r.err
+}
+
+func (p *Unsubscribe) encode(w *writer, version byte) (byte, error) {
+	if len(p.TopicFilters) == 0 {
+		return 0, errors.New("mqtt: no topic filter in UNSUBSCRIBE")
+	}
+	w.uint16(p.PacketID)
+	if version >= Version5 {
+		if err := p.Properties.encode(w); err != nil {
+			return 0, err
+		}
+	}
+	for _, filter := range p.TopicFilters {
+		if err := w.string(filter); err != nil {
+			return 0, err
+		}
+	}
+	return 0x02, nil
+}
+
+func (p *Unsubscribe) decode(r *reader, flags byte, version byte) error {
+	if err := checkFlags(flags, 0x02); err != nil {
+		return err
+	}
+	p.PacketID = r.uint16()
+	if version >= Version5 && r.err == nil {
+		if err := p.Properties.decode(r); err != nil {
+			return err
+		}
+	}
+	for r.remaining() > 0 && r.err == nil {
+		p.TopicFilters = append(p.TopicFilters, r.string())
+	}
+	if r.err == nil && len(p.TopicFilters) == 0 {
+		return errors.New("mqtt: no topic filter in UNSUBSCRIBE")
+	}
+	return r.err
+}
+
+func (p *Unsuback) encode(w *writer, version byte) (byte, error) {
+	w.uint16(p.PacketID)
+	if version >= Version5 {
+		if err := p.Properties.encode(w); err != nil {
+			return 0, err
+		}
+		w.data = append(w.data, p.ReasonCodes...)
+	}
+	return 0, nil
+}
+
+func (p *Unsuback) decode(r *reader, flags byte, version byte) error {
+	if err := checkFlags(flags, 0); err != nil {
+		return err
+	}
+	p.PacketID = r.uint16()
+	if version >= Version5 && r.err == nil {
+		if err := p.Properties.decode(r); err != nil {
+			return err
+		}
+		p.ReasonCodes = r.rest()
+	}
+	return r.err
+}
+
+func (p *Pingreq) encode(w *writer, version byte) (byte, error) {
+	return 0, nil
+}
+
+func (p *Pingreq) decode(r *reader, flags byte, version byte) error {
+	return checkFlags(flags, 0)
+}
+
+func (p *Pingresp) encode(w *writer, version byte) (byte, error) {
+	return 0, nil
+}
+
+func (p *Pingresp) decode(r *reader, flags byte, version byte) error {
+	return checkFlags(flags, 0)
+}
+
+// encodeReason writes the variable header of DISCONNECT and AUTH. It is omitted if it is not needed.
+func encodeReason(w *writer, version byte, reasonCode byte, props *Properties) error {
+	if version < Version5 || (reasonCode == 0 && props.empty()) {
+		return nil
+	}
+	w.byte(reasonCode)
+	return props.encode(w)
+}
+
+func decodeReason(r *reader, version byte) (reasonCode byte, props Properties, err error) {
+	if version >= Version5 && r.remaining() > 0 {
+		reasonCode = r.byte()
+		if r.remaining() > 0 {
+			err = props.decode(r)
+		}
+	}
+	return reasonCode, props, err
+}
+
+func (p *Disconnect) encode(w *writer, version byte) (byte, error) {
+	return 0, encodeReason(w, version, p.ReasonCode, &p.Properties)
+}
+
+func (p *Disconnect) decode(r *reader, flags byte, version byte) (err error) {
+	if err = checkFlags(flags, 0); err == nil {
+		p.ReasonCode, p.Properties, err = decodeReason(r, version)
+	}
+	return err
+}
+
+func (p *Auth) encode(w *writer, version byte) (byte, error) {
+	if version < Version5 {
+		return 0, errors.New("mqtt: AUTH is not supported before MQTT 5.0")
+	}
+	return 0, encodeReason(w, version, p.ReasonCode, &p.Properties)
+}
+
+func (p *Auth) decode(r *reader, flags byte, version byte) (err error) {
+	if version < Version5 {
+		return errors.New("mqtt: AUTH is not supported before MQTT 5.0")
+	}
+	if err = checkFlags(flags, 0); err == nil {
+		p.ReasonCode, p.Properties, err = decodeReason(r, version)
+	}
+	return err
+}
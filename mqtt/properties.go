@@ -0,0 +1,248 @@
+package mqtt
+
+import "fmt"
+
+// Property identifiers of MQTT 5.0.
+const (
+	PropPayloadFormatIndicator          = 0x01
+	PropMessageExpiryInterval           = 0x02
+	PropContentType                     = 0x03
+	PropResponseTopic                   = 0x08
+	PropCorrelationData                 = 0x09
+	PropSubscriptionIdentifier          = 0x0b
+	PropSessionExpiryInterval           = 0x11
+	PropAssignedClientIdentifier        = 0x12
+	PropServerKeepAlive                 = 0x13
+	PropAuthenticationMethod            = 0x15
+	PropAuthenticationData              = 0x16
+	PropRequestProblemInformation       = 0x17
+	PropWillDelayInterval               = 0x18
+	PropRequestResponseInformation      = 0x19
+	PropResponseInformation             = 0x1a
+	PropServerReference                 = 0x1c
+	PropReasonString                    = 0x1f
+	PropReceiveMaximum                  = 0x21
+	PropTopicAliasMaximum               = 0x22
+	PropTopicAlias                      = 0x23
+	PropMaximumQoS                      = 0x24
+	PropRetainAvailable                 = 0x25
+	PropUserProperty                    = 0x26
+	PropMaximumPacketSize               = 0x27
+	PropWildcardSubscriptionAvailable   = 0x28
+	PropSubscriptionIdentifierAvailable = 0x29
+	PropSharedSubscriptionAvailable     = 0x2a
+)
+
+// Properties is the set of properties of an MQTT 5.0 packet.
+// Optional numeric properties are pointers, and nil means that the property is absent.
+// Empty strings and nil byte slices are also regarded as absent.
+// Which properties are allowed depends on the packet type, and it is up to the application to set only allowed ones.
+type Properties struct {
+	PayloadFormatIndicator          *byte
+	MessageExpiryInterval           *uint32
+	ContentType                     string
+	ResponseTopic                   string
+	CorrelationData                 []byte
+	SubscriptionIdentifiers         []uint32
+	SessionExpiryInterval           *uint32
+	AssignedClientIdentifier        string
+	ServerKeepAlive                 *uint16
+	AuthenticationMethod            string
+	AuthenticationData              []byte
+	RequestProblemInformation       *byte
+	WillDelayInterval               *uint32
+	RequestResponseInformation      *byte
+	ResponseInformation             string
+	ServerReference                 string
+	ReasonString                    string
+	ReceiveMaximum                  *uint16
+	TopicAliasMaximum               *uint16
+	TopicAlias                      *uint16
+	MaximumQoS                      *byte
+	RetainAvailable                 *byte
+	UserProperties                  []UserProperty
+	MaximumPacketSize               *uint32
+	WildcardSubscriptionAvailable   *byte
+	SubscriptionIdentifierAvailable *byte
+	SharedSubscriptionAvailable     *byte
+}
+
+// UserProperty is a name and value pair of the user property.
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// empty reports whether no property is set.
+func (p *Properties) empty() bool {
+	var w writer
+	p.encodeTo(&w)
+	return len(w.data) == 0
+}
+
+// encode writes the property length and the properties.
+func (p *Properties) encode(w *writer) error {
+	var props writer
+	if err := p.encodeTo(&props); err != nil {
+		return err
+	}
+	w.varint(uint32(len(props.data)))
+	w.data = append(w.data, props.data...)
+	return nil
+}
+
+func (p *Properties) encodeTo(w *writer) error {
+	putByte := func(id byte, v *byte) {
+		if v != nil {
+			w.byte(id)
+			w.byte(*v)
+		}
+	}
+	putUint16 := func(id byte, v *uint16) {
+		if v != nil {
+			w.byte(id)
+			w.uint16(*v)
+		}
+	}
+	putUint32 := func(id byte, v *uint32) {
+		if v != nil {
+			w.byte(id)
+			w.uint32(*v)
+		}
+	}
+	var err error
+	putString := func(id byte, v string) {
+		if v != "" && err == nil {
+			w.byte(id)
+			err = w.string(v)
+		}
+	}
+	putBinary := func(id byte, v []byte) {
+		if v != nil && err == nil {
+			w.byte(id)
+			err = w.binary(v)
+		}
+	}
+
+	putByte(PropPayloadFormatIndicator, p.PayloadFormatIndicator)
+	putUint32(PropMessageExpiryInterval, p.MessageExpiryInterval)
+	putString(PropContentType, p.ContentType)
+	putString(PropResponseTopic, p.ResponseTopic)
+	putBinary(PropCorrelationData, p.CorrelationData)
+	for _, id := range p.SubscriptionIdentifiers {
+		w.byte(PropSubscriptionIdentifier)
+		w.varint(id)
+	}
+	putUint32(PropSessionExpiryInterval, p.SessionExpiryInterval)
+	putString(PropAssignedClientIdentifier, p.AssignedClientIdentifier)
+	putUint16(PropServerKeepAlive, p.ServerKeepAlive)
+	putString(PropAuthenticationMethod, p.AuthenticationMethod)
+	putBinary(PropAuthenticationData, p.AuthenticationData)
+	putByte(PropRequestProblemInformation, p.RequestProblemInformation)
+	putUint32(PropWillDelayInterval, p.WillDelayInterval)
+	putByte(PropRequestResponseInformation, p.RequestResponseInformation)
+	putString(PropResponseInformation, p.ResponseInformation)
+	putString(PropServerReference, p.ServerReference)
+	putString(PropReasonString, p.ReasonString)
+	putUint16(PropReceiveMaximum, p.ReceiveMaximum)
+	putUint16(PropTopicAliasMaximum, p.TopicAliasMaximum)
+	putUint16(PropTopicAlias, p.TopicAlias)
+	putByte(PropMaximumQoS, p.MaximumQoS)
+	putByte(PropRetainAvailable, p.RetainAvailable)
+	for _, up := range p.UserProperties {
+		if err == nil {
+			w.byte(PropUserProperty)
+			if err = w.string(up.Key); err == nil {
+				err = w.string(up.Value)
+			}
+		}
+	}
+	putUint32(PropMaximumPacketSize, p.MaximumPacketSize)
+	putByte(PropWildcardSubscriptionAvailable, p.WildcardSubscriptionAvailable)
+	putByte(PropSubscriptionIdentifierAvailable, p.SubscriptionIdentifierAvailable)
+	putByte(PropSharedSubscriptionAvailable, p.SharedSubscriptionAvailable)
+	return err
+}
+
+// decode reads the property length and the properties.
+func (p *Properties) decode(r *reader) error {
+	length := int(r.varint())
+	if r.err != nil {
+		return r.err
+	}
+	if length > r.remaining() {
+		return ErrMalformed
+	}
+	end := r.pos + length
+
+	getByte := func() *byte { v := r.byte(); return &v }
+	getUint16 := func() *uint16 { v := r.uint16(); return &v }
+	getUint32 := func() *uint32 { v := r.uint32(); return &v }
+	for r.pos < end && r.err == nil {
+		switch id := r.varint(); id {
+		case PropPayloadFormatIndicator:
+			p.PayloadFormatIndicator = getByte()
+		case PropMessageExpiryInterval:
+			p.MessageExpiryInterval = getUint32()
+		case PropContentType:
+			p.ContentType = r.string()
+		case PropResponseTopic:
+			p.ResponseTopic = r.string()
+		case PropCorrelationData:
+			p.CorrelationData = r.binary()
+		case PropSubscriptionIdentifier:
+			p.SubscriptionIdentifiers = append(p.SubscriptionIdentifiers, r.varint())
+		case PropSessionExpiryInterval:
+			p.SessionExpiryInterval = getUint32()
+		case PropAssignedClientIdentifier:
+			p.AssignedClientIdentifier = r.string()
+		case PropServerKeepAlive:
+			p.ServerKeepAlive = getUint16()
+		case PropAuthenticationMethod:
+			p.AuthenticationMethod = r.string()
+		case PropAuthenticationData:
+			p.AuthenticationData = r.binary()
+		case PropRequestProblemInformation:
+			p.RequestProblemInformation = getByte()
+		case PropWillDelayInterval:
+			p.WillDelayInterval = getUint32()
+		case PropRequestResponseInformation:
+			p.RequestResponseInformation = getByte()
+		case PropResponseInformation:
+			p.ResponseInformation = r.string()
+		case PropServerReference:
+			p.ServerReference = r.string()
+		case PropReasonString:
+			p.ReasonString = r.string()
+		case PropReceiveMaximum:
+			p.ReceiveMaximum = getUint16()
+		case PropTopicAliasMaximum:
+			p.TopicAliasMaximum = getUint16()
+		case PropTopicAlias:
+			p.TopicAlias = getUint16()
+		case PropMaximumQoS:
+			p.MaximumQoS = getByte()
+		case PropRetainAvailable:
+			p.RetainAvailable = getByte()
+		case PropUserProperty:
+			key := r.string()
+			p.UserProperties = append(p.UserProperties, UserProperty{key, r.string()})
+		case PropMaximumPacketSize:
+			p.MaximumPacketSize = getUint32()
+		case PropWildcardSubscriptionAvailable:
+			p.WildcardSubscriptionAvailable = getByte()
+		case PropSubscriptionIdentifierAvailable:
+			p.SubscriptionIdentifierAvailable = getByte()
+		case PropSharedSubscriptionAvailable:
+			p.SharedSubscriptionAvailable = getByte()
+		default:
+			if r.err == nil {
+				return fmt.Errorf("mqtt: invalid property identifier 0x%02x", id)
+			}
+		}
+	}
+	if r.err == nil && r.pos != end {
+		return ErrMalformed
+	}
+	return r.err
+}
@@ -0,0 +1,140 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf8"
+)
+
+// writer builds the variable header and the payload of a packet.
+type writer struct {
+	data []byte
+}
+
+func (w *writer) byte(b byte) {
+	w.data = append(w.data, b)
+}
+
+func (w *writer) uint16(v uint16) {
+	w.data = append(w.data, byte(v>>8), byte(v))
+}
+
+func (w *writer) uint32(v uint32) {
+	w.data = append(w.data, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *writer) varint(v uint32) {
+	w.data = appendVarint(w.data, v)
+}
+
+func (w *writer) string(s string) error {
+	if len(s) > 0xffff {
+		return errors.New("mqtt: string too long")
+	}
+	w.uint16(uint16(len(s)))
+	w.data = append(w.data, s...)
+	return nil
+}
+
+func (w *writer) binary(b []byte) error {
+	if len(b) > 0xffff {
+		return errors.New("mqtt: binary data too long")
+	}
+	w.uint16(uint16(len(b)))
+	w.data = append(w.data, b...)
+	return nil
+}
+
+func appendVarint(dst []byte, v uint32) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(dst, b)
+		}
+		dst = append(dst, b|0x80)
+	}
+}
+
+// reader reads the variable header and the payload of a packet.
+// The first error is kept in err and the following reads return zero values.
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.remaining() < n {
+		r.err = ErrMalformed
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) varint() uint32 {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		b := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		v |= uint32(b&0x7f) << (7 * uint(i))
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+	r.err = ErrMalformed
+	return 0
+}
+
+func (r *reader) string() string {
+	b := r.next(int(r.uint16()))
+	if r.err == nil && !utf8.Valid(b) {
+		r.err = ErrMalformed
+	}
+	return string(b)
+}
+
+func (r *reader) binary() []byte {
+	b := r.next(int(r.uint16()))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// rest returns a copy of the remaining data.
+func (r *reader) rest() []byte {
+	return append([]byte{}, r.next(r.remaining())...)
+}
@@ -0,0 +1,647 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+const maxNestingDepth = 512
+
+var (
+	errInvalidCode = errors.New("msgpack: invalid code")
+	errTooDeep     = errors.New("msgpack: nesting too deep")
+)
+
+// Unmarshal decodes the MessagePack object in data and stores the result in the value pointed to by v.
+// data should contain exactly one object.
+func Unmarshal(data []byte, v interface{}) error {
+	size, err := Size(data)
+	if err != nil {
+		return err
+	}
+	if size != len(data) {
+		return errors.New("msgpack: trailing data after object")
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: Unmarshal() requires a non-nil pointer - %T", v)
+	}
+
+	d := decoder{data: data}
+	return d.decode(rv.Elem())
+}
+
+// Size returns the size of the MessagePack object at the start of data.
+// If data ends in the middle of the object, it returns ErrIncomplete.
+// It doesn't decode the object, so it can be used to detect whether a whole object has been received.
+func Size(data []byte) (int, error) {
+	pos := 0
+	remain := 1 // number of objects to skip
+	for remain > 0 {
+		if pos >= len(data) {
+			return 0, ErrIncomplete
+		}
+		code := data[pos]
+		remain--
+
+		switch {
+		case code <= 0x7f, code >= 0xe0, code == codeNil, code == codeFalse, code == codeTrue:
+			pos++
+		case code&0xf0 == 0x80:
+			remain += 2 * int(code&0x0f)
+			pos++
+		case code&0xf0 == 0x90:
+			remain += int(code & 0x0f)
+			pos++
+		case code&0xe0 == 0xa0:
+			pos += 1 + int(code&0x1f)
+		default:
+			switch code {
+			case codeBin8, codeBin16, codeBin32, codeStr8, codeStr16, codeStr32:
+				n, size, ok := lengthAt(data, pos, code)
+				if !ok {
+					return 0, ErrIncomplete
+				}
+				pos += 1 + size + n
+			case codeExt8, codeExt16, codeExt32:
+				n, size, ok := lengthAt(data, pos, code)
+				if !ok {
+					return 0, ErrIncomplete
+				}
+				pos += 2 + size + n
+			case codeArray16, codeArray32:
+				n, size, ok := lengthAt(data, pos, code)
+				if !ok {
+					return 0, ErrIncomplete
+				}
+				remain += n
+				pos += 1 + size
+			case codeMap16, codeMap32:
+				n, size, ok := lengthAt(data, pos, code)
+				if !ok {
+					return 0, ErrIncomplete
+				}
+				remain += 2 * n
+				pos += 1 + size
+			case codeUint8, codeInt8:
+				pos += 2
+			case codeUint16, codeInt16:
+				pos += 3
+			case codeUint32, codeInt32, codeFloat32:
+				pos += 5
+			case codeUint64, codeInt64, codeFloat64:
+				pos += 9
+			case codeFixExt1:
+				pos += 3
+			case codeFixExt2:
+				pos += 4
+			case codeFixExt4:
+				pos += 6
+			case codeFixExt8:
+				pos += 10
+			case codeFixExt16:
+				pos += 18
+			default:
+				return 0, errInvalidCode
+			}
+		}
+	}
+
+	if pos > len(data) {
+		return 0, ErrIncomplete
+	}
+	return pos, nil
+}
+
+// lengthAt returns the length that follows the code at pos and the size of the length field.
+func lengthAt(data []byte, pos int, code byte) (n int, size int, ok bool) {
+	switch code {
+	case codeBin8, codeStr8, codeExt8:
+		size = 1
+	case codeBin16, codeStr16, codeExt16, codeArray16, codeMap16:
+		size = 2
+	default:
+		size = 4
+	}
+	if len(data) < pos+1+size {
+		return 0, 0, false
+	}
+
+	field := data[pos+1 : pos+1+size]
+	switch size {
+	case 1:
+		n = int(field[0])
+	case 2:
+		n = int(binary.BigEndian.Uint16(field))
+	default:
+		n = int(binary.BigEndian.Uint32(field))
+	}
+	return n, size, true
+}
+
+// decoder decodes objects that are already checked to be complete by Size().
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int // nesting depth of arrays and maps being decoded
+}
+
+func (d *decoder) next(n int) []byte {
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) skip() error {
+	n, err := Size(d.data[d.pos:])
+	if err != nil {
+		return err
+	}
+	d.pos += n
+	return nil
+}
+
+func (d *decoder) decode(v reflect.Value) error {
+	if d.depth++; d.depth > maxNestingDepth {
+		return errTooDeep
+	}
+	defer func() { d.depth-- }()
+
+	code := d.data[d.pos]
+	if code == codeNil {
+		d.pos++
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		t, err := d.decodeTime()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case extType:
+		typ, data, err := d.decodeExt()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(Ext{typ, append([]byte(nil), data...)}))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			if v.IsNil() {
+				return fmt.Errorf("msgpack: cannot decode into non-empty interface - %v", v.Type())
+			}
+			return d.decode(v.Elem())
+		}
+		x, err := d.decodeInterface()
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+	case reflect.Bool:
+		switch code {
+		case codeTrue:
+			v.SetBool(true)
+		case codeFalse:
+			v.SetBool(false)
+		default:
+			return d.typeError(v)
+		}
+		d.pos++
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := d.decodeInt()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("msgpack: %d overflows %v", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := d.decodeUint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("msgpack: %d overflows %v", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := d.decodeFloat()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		b, err := d.decodeBytes()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.decodeBytes()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		n, err := d.decodeArrayLen()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		for i := 0; i < n; i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.decodeBytes()
+			if err != nil {
+				return err
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+		n, err := d.decodeArrayLen()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if i < v.Len() {
+				err = d.decode(v.Index(i))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := d.decodeMapLen()
+		if err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		}
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(value); err != nil {
+				return err
+			}
+			if !key.Comparable() {
+				return fmt.Errorf("msgpack: unhashable map key - %T", key.Interface())
+			}
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		return d.decodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type - %v", v.Type())
+	}
+	return nil
+}
+
+func (d *decoder) decodeStruct(v reflect.Value) error {
+	n, err := d.decodeMapLen()
+	if err != nil {
+		return err
+	}
+
+	fields := fieldsOf(v.Type())
+	for i := 0; i < n; i++ {
+		key, err := d.decodeBytes()
+		if err != nil {
+			return err
+		}
+
+		var f *field
+		for j := range fields {
+			if fields[j].name == string(key) {
+				f = &fields[j]
+				break
+			}
+		}
+		if f == nil {
+			if err = d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = d.decode(v.FieldByIndex(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) decodeInterface() (interface{}, error) {
+	if d.depth++; d.depth > maxNestingDepth {
+		return nil, errTooDeep
+	}
+	defer func() { d.depth-- }()
+
+	code := d.data[d.pos]
+	switch {
+	case code <= 0x7f, code >= 0xe0:
+		return d.decodeInt()
+	case code&0xf0 == 0x80, code == codeMap16, code == codeMap32:
+		return d.decodeMap()
+	case code&0xf0 == 0x90, code == codeArray16, code == codeArray32:
+		n, err := d.decodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = d.decodeInterface(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case code&0xe0 == 0xa0:
+		b, err := d.decodeBytes()
+		return string(b), err
+	}
+
+	switch code {
+	case codeNil:
+		d.pos++
+		return nil, nil
+	case codeFalse, codeTrue:
+		d.pos++
+		return code == codeTrue, nil
+	case codeUint8, codeUint16, codeUint32, codeUint64:
+		return d.decodeUint()
+	case codeInt8, codeInt16, codeInt32, codeInt64:
+		return d.decodeInt()
+	case codeFloat32:
+		return math.Float32frombits(binary.BigEndian.Uint32(d.next(5)[1:])), nil
+	case codeFloat64:
+		return d.decodeFloat()
+	case codeStr8, codeStr16, codeStr32:
+		b, err := d.decodeBytes()
+		return string(b), err
+	case codeBin8, codeBin16, codeBin32:
+		b, err := d.decodeBytes()
+		return append([]byte(nil), b...), err
+	case codeFixExt1, codeFixExt2, codeFixExt4, codeFixExt8, codeFixExt16, codeExt8, codeExt16, codeExt32:
+		start := d.pos
+		typ, data, err := d.decodeExt()
+		if err != nil {
+			return nil, err
+		}
+		if typ == TimestampType {
+			d.pos = start
+			return d.decodeTime()
+		}
+		return Ext{typ, append([]byte(nil), data...)}, nil
+	default:
+		return nil, errInvalidCode
+	}
+}
+
+func (d *decoder) decodeMap() (interface{}, error) {
+	n, err := d.decodeMapLen()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]interface{}, n)
+	values := make([]interface{}, n)
+	stringKeys := true
+	for i := 0; i < n; i++ {
+		if keys[i], err = d.decodeInterface(); err != nil {
+			return nil, err
+		}
+		if values[i], err = d.decodeInterface(); err != nil {
+			return nil, err
+		}
+		if _, ok := keys[i].(string); !ok {
+			stringKeys = false
+		}
+	}
+
+	if stringKeys {
+		m := make(map[string]interface{}, n)
+		for i, key := range keys {
+			m[key.(string)] = values[i]
+		}
+		return m, nil
+	}
+
+	m := make(map[interface{}]interface{}, n)
+	for i, key := range keys {
+		if key != nil && !reflect.ValueOf(key).Comparable() {
+			return nil, fmt.Errorf("msgpack: unhashable map key - %T", key)
+		}
+		m[key] = values[i]
+	}
+	return m, nil
+}
+
+func (d *decoder) decodeInt() (int64, error) {
+	code := d.data[d.pos]
+	switch {
+	case code <= 0x7f:
+		d.pos++
+		return int64(code), nil
+	case code >= 0xe0:
+		d.pos++
+		return int64(int8(code)), nil
+	}
+
+	switch code {
+	case codeInt8:
+		return int64(int8(d.next(2)[1])), nil
+	case codeInt16:
+		return int64(int16(binary.BigEndian.Uint16(d.next(3)[1:]))), nil
+	case codeInt32:
+		return int64(int32(binary.BigEndian.Uint32(d.next(5)[1:]))), nil
+	case codeInt64:
+		return int64(binary.BigEndian.Uint64(d.next(9)[1:])), nil
+	case codeUint8, codeUint16, codeUint32, codeUint64:
+		n, err := d.decodeUint()
+		if err != nil {
+			return 0, err
+		}
+		if n > math.MaxInt64 {
+			return 0, fmt.Errorf("msgpack: %d overflows int64", n)
+		}
+		return int64(n), nil
+	default:
+		return 0, fmt.Errorf("msgpack: invalid code for integer - 0x%02x", code)
+	}
+}
+
+func (d *decoder) decodeUint() (uint64, error) {
+	code := d.data[d.pos]
+	switch code {
+	case codeUint8:
+		return uint64(d.next(2)[1]), nil
+	case codeUint16:
+		return uint64(binary.BigEndian.Uint16(d.next(3)[1:])), nil
+	case codeUint32:
+		return uint64(binary.BigEndian.Uint32(d.next(5)[1:])), nil
+	case codeUint64:
+		return binary.BigEndian.Uint64(d.next(9)[1:]), nil
+	}
+
+	n, err := d.decodeInt()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("msgpack: %d overflows unsigned integer", n)
+	}
+	return uint64(n), nil
+}
+
+func (d *decoder) decodeFloat() (float64, error) {
+	code := d.data[d.pos]
+	switch code {
+	case codeFloat32:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(d.next(5)[1:]))), nil
+	case codeFloat64:
+		return math.Float64frombits(binary.BigEndian.Uint64(d.next(9)[1:])), nil
+	case codeUint64:
+		n, err := d.decodeUint()
+		return float64(n), err
+	}
+
+	n, err := d.decodeInt()
+	if err != nil {
+		return 0, fmt.Errorf("msgpack: invalid code for float - 0x%02x", code)
+	}
+	return float64(n), nil
+}
+
+// decodeBytes decodes str or bin. The returned slice refers to the data of the decoder.
+func (d *decoder) decodeBytes() ([]byte, error) {
+	code := d.data[d.pos]
+	if code&0xe0 == 0xa0 {
+		n := int(code & 0x1f)
+		return d.next(1 + n)[1:], nil
+	}
+
+	switch code {
+	case codeStr8, codeStr16, codeStr32, codeBin8, codeBin16, codeBin32:
+		n, size, _ := lengthAt(d.data, d.pos, code)
+		return d.next(1 + size + n)[1+size:], nil
+	default:
+		return nil, fmt.Errorf("msgpack: invalid code for string or binary - 0x%02x", code)
+	}
+}
+
+func (d *decoder) decodeArrayLen() (int, error) {
+	code := d.data[d.pos]
+	if code&0xf0 == 0x90 {
+		d.pos++
+		return int(code & 0x0f), nil
+	}
+
+	switch code {
+	case codeArray16, codeArray32:
+		n, size, _ := lengthAt(d.data, d.pos, code)
+		d.pos += 1 + size
+		return n, nil
+	default:
+		return 0, fmt.Errorf("msgpack: invalid code for array - 0x%02x", code)
+	}
+}
+
+func (d *decoder) decodeMapLen() (int, error) {
+	code := d.data[d.pos]
+	if code&0xf0 == 0x80 {
+		d.pos++
+		return int(code & 0x0f), nil
+	}
+
+	switch code {
+	case codeMap16, codeMap32:
+		n, size, _ := lengthAt(d.data, d.pos, code)
+		d.pos += 1 + size
+		return n, nil
+	default:
+		return 0, fmt.Errorf("msgpack: invalid code for map - 0x%02x", code)
+	}
+}
+
+// decodeExt decodes an extension type. The returned slice refers to the data of the decoder.
+func (d *decoder) decodeExt() (int8, []byte, error) {
+	code := d.data[d.pos]
+	var n, size int
+	switch code {
+	case codeFixExt1:
+		n = 1
+	case codeFixExt2:
+		n = 2
+	case codeFixExt4:
+		n = 4
+	case codeFixExt8:
+		n = 8
+	case codeFixExt16:
+		n = 16
+	case codeExt8, codeExt16, codeExt32:
+		n, size, _ = lengthAt(d.data, d.pos, code)
+	default:
+		return 0, nil, fmt.Errorf("msgpack: invalid code for extension - 0x%02x", code)
+	}
+
+	b := d.next(1 + size + 1 + n)
+	return int8(b[1+size]), b[2+size:], nil
+}
+
+func (d *decoder) decodeTime() (time.Time, error) {
+	typ, data, err := d.decodeExt()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if typ != TimestampType {
+		return time.Time{}, fmt.Errorf("msgpack: invalid extension type for timestamp - %d", typ)
+	}
+
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), nil
+	case 8:
+		n := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(n&(1<<34-1)), int64(n>>34)), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))), nil
+	default:
+		return time.Time{}, fmt.Errorf("msgpack: invalid timestamp length - %d", len(data))
+	}
+}
+
+func (d *decoder) typeError(v reflect.Value) error {
+	return fmt.Errorf("msgpack: cannot decode 0x%02x into %v", d.data[d.pos], v.Type())
+}
@@ -0,0 +1,300 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Marshal returns the MessagePack encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	return Append(nil, v)
+}
+
+// Append appends the MessagePack encoding of v to data and returns the extended slice.
+func Append(data []byte, v interface{}) ([]byte, error) {
+	e := encoder{data}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.data, nil
+}
+
+type encoder struct {
+	data []byte
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.data = append(e.data, codeNil)
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		e.encodeTime(v.Interface().(time.Time))
+		return nil
+	case extType:
+		ext := v.Interface().(Ext)
+		e.encodeExt(ext.Type, ext.Data)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.data = append(e.data, codeNil)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.data = append(e.data, codeTrue)
+		} else {
+			e.data = append(e.data, codeFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.data = append(e.data, codeFloat32)
+		e.data = binary.BigEndian.AppendUint32(e.data, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.data = append(e.data, codeFloat64)
+		e.data = binary.BigEndian.AppendUint64(e.data, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.data = append(e.data, codeNil)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBin(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			bin := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bin), v)
+			e.encodeBin(bin)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.data = append(e.data, codeNil)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type - %v", v.Type())
+	}
+	return nil
+}
+
+func (e *encoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.data = append(e.data, byte(n))
+	case n >= math.MinInt8:
+		e.data = append(e.data, codeInt8, byte(n))
+	case n >= math.MinInt16:
+		e.data = append(e.data, codeInt16)
+		e.data = binary.BigEndian.AppendUint16(e.data, uint16(n))
+	case n >= math.MinInt32:
+		e.data = append(e.data, codeInt32)
+		e.data = binary.BigEndian.AppendUint32(e.data, uint32(n))
+	default:
+		e.data = append(e.data, codeInt64)
+		e.data = binary.BigEndian.AppendUint64(e.data, uint64(n))
+	}
+}
+
+func (e *encoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.data = append(e.data, byte(n))
+	case n <= math.MaxUint8:
+		e.data = append(e.data, codeUint8, byte(n))
+	case n <= math.MaxUint16:
+		e.data = append(e.data, codeUint16)
+		e.data = binary.BigEndian.AppendUint16(e.data, uint16(n))
+	case n <= math.MaxUint32:
+		e.data = append(e.data, codeUint32)
+		e.data = binary.BigEndian.AppendUint32(e.data, uint32(n))
+	default:
+		e.data = append(e.data, codeUint64)
+		e.data = binary.BigEndian.AppendUint64(e.data, n)
+	}
+}
+
+func (e *encoder) encodeString(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.data = append(e.data, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.data = append(e.data, codeStr8, byte(n))
+	case n <= math.MaxUint16:
+		e.data = append(e.data, codeStr16)
+		e.data = binary.BigEndian.AppendUint16(e.data, uint16(n))
+	default:
+		e.data = append(e.data, codeStr32)
+		e.data = binary.BigEndian.AppendUint32(e.data, uint32(n))
+	}
+	e.data = append(e.data, s...)
+}
+
+func (e *encoder) encodeBin(bin []byte) {
+	n := len(bin)
+	switch {
+	case n <= math.MaxUint8:
+		e.data = append(e.data, codeBin8, byte(n))
+	case n <= math.MaxUint16:
+		e.data = append(e.data, codeBin16)
+		e.data = binary.BigEndian.AppendUint16(e.data, uint16(n))
+	default:
+		e.data = append(e.data, codeBin32)
+		e.data = binary.BigEndian.AppendUint32(e.data, uint32(n))
+	}
+	e.data = append(e.data, bin...)
+}
+
+func (e *encoder) encodeArrayLen(n int) {
+	switch {
+	case n < 16:
+		e.data = append(e.data, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.data = append(e.data, codeArray16)
+		e.data = binary.BigEndian.AppendUint16(e.data, uint16(n))
+	default:
+		e.data = append(e.data, codeArray32)
+		e.data = binary.BigEndian.AppendUint32(e.data, uint32(n))
+	}
+}
+
+func (e *encoder) encodeMapLen(n int) {
+	switch {
+	case n < 16:
+		e.data = append(e.data, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.data = append(e.data, codeMap16)
+		e.data = binary.BigEndian.AppendUint16(e.data, uint16(n))
+	default:
+		e.data = append(e.data, codeMap32)
+		e.data = binary.BigEndian.AppendUint32(e.data, uint32(n))
+	}
+}
+
+func (e *encoder) encodeArray(v reflect.Value) error {
+	e.encodeArrayLen(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeMap(v reflect.Value) error {
+	e.encodeMapLen(v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		if err := e.encode(iter.Key()); err != nil {
+			return err
+		}
+		if err := e.encode(iter.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) error {
+	fields := fieldsOf(v.Type())
+	values := make([]reflect.Value, 0, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		values = append(values, fv)
+		names = append(names, f.name)
+	}
+
+	e.encodeMapLen(len(values))
+	for i, fv := range values {
+		e.encodeString(names[i])
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeExt(typ int8, data []byte) {
+	n := len(data)
+	switch n {
+	case 1:
+		e.data = append(e.data, codeFixExt1)
+	case 2:
+		e.data = append(e.data, codeFixExt2)
+	case 4:
+		e.data = append(e.data, codeFixExt4)
+	case 8:
+		e.data = append(e.data, codeFixExt8)
+	case 16:
+		e.data = append(e.data, codeFixExt16)
+	default:
+		switch {
+		case n <= math.MaxUint8:
+			e.data = append(e.data, codeExt8, byte(n))
+		case n <= math.MaxUint16:
+			e.data = append(e.data, codeExt16)
+			e.data = binary.BigEndian.AppendUint16(e.data, uint16(n))
+		default:
+			e.data = append(e.data, codeExt32)
+			e.data = binary.BigEndian.AppendUint32(e.data, uint32(n))
+		}
+	}
+	e.data = append(e.data, byte(typ))
+	e.data = append(e.data, data...)
+}
+
+func (e *encoder) encodeTime(t time.Time) {
+	sec := t.Unix()
+	nsec := uint32(t.Nanosecond())
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32: // timestamp 32
+		e.encodeExt(TimestampType, binary.BigEndian.AppendUint32(nil, uint32(sec)))
+	case sec>>34 == 0: // timestamp 64
+		e.encodeExt(TimestampType, binary.BigEndian.AppendUint64(nil, uint64(nsec)<<34|uint64(sec)))
+	default: // timestamp 96
+		data := binary.BigEndian.AppendUint32(nil, nsec)
+		e.encodeExt(TimestampType, binary.BigEndian.AppendUint64(data, uint64(sec)))
+	}
+}
+
+// fieldByIndex returns the field of the struct. It returns false if an embedded pointer in the path is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,140 @@
+// Package msgpack implements encoding and decoding of MessagePack without external dependencies.
+//
+// Go values are mapped to MessagePack as follows. Booleans, integers, floats, strings and nil are mapped to the
+// corresponding MessagePack types. []byte and [N]byte are mapped to bin. Other slices and arrays are mapped to array.
+// Maps and structs are mapped to map. time.Time is mapped to the timestamp extension type and Ext to other extension types.
+//
+// Struct fields are encoded with the field name as the key. The key can be changed by the "msgpack" struct tag.
+// The "omitempty" option omits the field if it has an empty value, and the tag "-" skips the field.
+//
+//	Name string `msgpack:"name,omitempty"`
+//
+// When a MessagePack object is decoded into an interface value, nil, bool, int64, uint64, float32, float64, string, []byte,
+// []interface{}, map[string]interface{}, time.Time and Ext are used. A map that has non-string keys is decoded into
+// map[interface{}]interface{}.
+package msgpack
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIncomplete is returned when data ends in the middle of a MessagePack object.
+var ErrIncomplete = errors.New("msgpack: incomplete object")
+
+// TimestampType is the extension type of the timestamp.
+const TimestampType int8 = -1
+
+// Ext represents a MessagePack extension type other than timestamp.
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+const (
+	codeNil      = 0xc0
+	codeFalse    = 0xc2
+	codeTrue     = 0xc3
+	codeBin8     = 0xc4
+	codeBin16    = 0xc5
+	codeBin32    = 0xc6
+	codeExt8     = 0xc7
+	codeExt16    = 0xc8
+	codeExt32    = 0xc9
+	codeFloat32  = 0xca
+	codeFloat64  = 0xcb
+	codeUint8    = 0xcc
+	codeUint16   = 0xcd
+	codeUint32   = 0xce
+	codeUint64   = 0xcf
+	codeInt8     = 0xd0
+	codeInt16    = 0xd1
+	codeInt32    = 0xd2
+	codeInt64    = 0xd3
+	codeFixExt1  = 0xd4
+	codeFixExt2  = 0xd5
+	codeFixExt4  = 0xd6
+	codeFixExt8  = 0xd7
+	codeFixExt16 = 0xd8
+	codeStr8     = 0xd9
+	codeStr16    = 0xda
+	codeStr32    = 0xdb
+	codeArray16  = 0xdc
+	codeArray32  = 0xdd
+	codeMap16    = 0xde
+	codeMap32    = 0xdf
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	extType  = reflect.TypeOf(Ext{})
+)
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// fieldsOf returns the encodable fields of the struct type. Fields of embedded structs are promoted.
+func fieldsOf(typ reflect.Type) []field {
+	if fields, ok := fieldCache.Load(typ); ok {
+		return fields.([]field)
+	}
+
+	var fields []field
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get("msgpack")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			for _, f := range fieldsOf(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{name, []int{i}, opts == "omitempty"})
+	}
+
+	fieldCache.Store(typ, fields)
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
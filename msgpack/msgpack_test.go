@@ -0,0 +1,152 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shanpark/net"
+)
+
+type embedded struct {
+	ID int64 `msgpack:"id"`
+}
+
+type message struct {
+	embedded
+	Name    string            `msgpack:"name"`
+	Tags    []string          `msgpack:"tags,omitempty"`
+	Attrs   map[string]int    `msgpack:"attrs"`
+	Payload []byte            `msgpack:"payload"`
+	Score   float64           `msgpack:"score"`
+	Ratio   float32           `msgpack:"ratio"`
+	Created time.Time         `msgpack:"created"`
+	Ext     Ext               `msgpack:"ext"`
+	Next    *message          `msgpack:"next"`
+	Any     interface{}       `msgpack:"any"`
+	Skipped string            `msgpack:"-"`
+	Extra   map[string]string `msgpack:"extra,omitempty"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := message{
+		embedded: embedded{ID: -1 << 40},
+		Name:     "hello",
+		Tags:     []string{"a", "b"},
+		Attrs:    map[string]int{"x": 1, "y": -200, "z": math.MaxInt32 + 1},
+		Payload:  make([]byte, 300),
+		Score:    3.25,
+		Ratio:    0.5,
+		Created:  time.Unix(1<<35, 123).UTC(),
+		Ext:      Ext{Type: 5, Data: []byte{1, 2, 3}},
+		Next:     &message{Name: "next", Created: time.Unix(100, 0).UTC()},
+		Any:      []interface{}{int64(1), "two", nil, true},
+		Skipped:  "skipped",
+	}
+
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out message
+	if err = Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	out.Created = out.Created.UTC()
+	out.Next.Created = out.Next.Created.UTC()
+	in.Skipped = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch\n got: %+v\nwant: %+v", out, in)
+	}
+}
+
+func TestSizeIncomplete(t *testing.T) {
+	data, err := Marshal(map[string]interface{}{"list": []int{1, 2, 3}, "text": "abcdefghijklmnopqrstuvwxyz0123456789"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err := Size(data[:i]); err != ErrIncomplete {
+			t.Fatalf("Size(data[:%d]) = %v, want ErrIncomplete", i, err)
+		}
+	}
+	if n, err := Size(append(data, 0xc0)); err != nil || n != len(data) {
+		t.Fatalf("Size() = %d, %v, want %d", n, err, len(data))
+	}
+}
+
+func TestDecodeInterface(t *testing.T) {
+	data, err := Marshal(map[interface{}]interface{}{"a": uint64(math.MaxUint64), int64(1): -1.5, "t": time.Unix(7, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out interface{}
+	if err = Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	m := out.(map[interface{}]interface{})
+	if m["a"] != uint64(math.MaxUint64) || m[int64(1)] != -1.5 || !m["t"].(time.Time).Equal(time.Unix(7, 0)) {
+		t.Errorf("unexpected result - %#v", m)
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		data := bytes.Repeat([]byte{0x91}, depth) // fixarray of one element
+		return append(data, codeNil)
+	}
+
+	var out interface{}
+	if err := Unmarshal(nested(100), &out); err != nil {
+		t.Errorf("Unmarshal() of 100 levels = %v", err)
+	}
+	if err := Unmarshal(nested(5<<20), &out); err != errTooDeep {
+		t.Errorf("Unmarshal() into interface = %v, want errTooDeep", err)
+	}
+	var list []interface{}
+	if err := Unmarshal(nested(5<<20), &list); err != errTooDeep {
+		t.Errorf("Unmarshal() into slice = %v, want errTooDeep", err)
+	}
+}
+
+// TestUnhashableKey verifies that a map key that cannot be hashed is an error instead of a panic.
+func TestUnhashableKey(t *testing.T) {
+	data := []byte{0x81, 0x91, 0x01, 0x01} // {[1]: 1}
+	var m map[interface{}]interface{}
+	if err := Unmarshal(data, &m); err == nil || !strings.Contains(err.Error(), "unhashable") {
+		t.Errorf("Unmarshal() into map = %v", err)
+	}
+	var out interface{}
+	if err := Unmarshal(data, &out); err == nil || !strings.Contains(err.Error(), "unhashable") {
+		t.Errorf("Unmarshal() into interface = %v", err)
+	}
+}
+
+func TestCodecMaxObjectSize(t *testing.T) {
+	codec := NewCodec(nil)
+	if codec.maxObjectSize != DefaultMaxObjectSize {
+		t.Errorf("default max object size = %d", codec.maxObjectSize)
+	}
+	codec.SetMaxObjectSize(16)
+	p, _ := net.NewEmbeddedPipeline(codec)
+
+	if err := p.WriteInbound([]byte{0xa3, 'a', 'b', 'c', 0xa2, 'd'}); err != nil || p.ReadInbound() != "abc" || !p.IsRollback() {
+		t.Errorf("WriteInbound() = %v, rollback %v", err, p.IsRollback())
+	}
+	if err := p.WriteInbound([]byte{'e'}); err != nil || p.ReadInbound() != "de" {
+		t.Errorf("WriteInbound() of rest = %v", err)
+	}
+	if err := p.WriteInbound(append([]byte{0xc4, 32}, make([]byte, 16)...)); err != net.ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long incomplete object = %v", err)
+	}
+	p, _ = net.NewEmbeddedPipeline(codec)
+	if err := p.WriteInbound(append([]byte{0xc4, 20}, make([]byte, 20)...)); err != net.ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long object = %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package net
 
-import "errors"
+import (
+	"errors"
+	"reflect"
+)
 
 type pipeline struct {
 	readHandlers       []ReadHandler
@@ -9,6 +12,9 @@ type pipeline struct {
 	disconnectHandlers []DisconnectHandler
 	timeoutHandlers    []TimeoutHandler
 	errorHandlers      []ErrorHandler
+	trafficHandlers    []TrafficHandler
+
+	handlers []interface{} // all handlers in the order they were added
 }
 
 func (pl *pipeline) AddHandler(handler interface{}) error {
@@ -39,14 +45,52 @@ func (pl *pipeline) AddHandler(handler interface{}) error {
 		pl.errorHandlers = append(pl.errorHandlers, handler.(ErrorHandler))
 		added = true
 	}
+	if _, ok = handler.(TrafficHandler); ok {
+		pl.trafficHandlers = append(pl.trafficHandlers, handler.(TrafficHandler))
+		added = true
+	}
 
 	if !added {
 		return errors.New("net: invalid handler - no handler method not found")
 	}
 
+	pl.handlers = append(pl.handlers, handler)
 	return nil
 }
 
+// replaceHandler returns a new pipeline in which old is replaced with handlers.
+// handlers take the position of old in the order of addition. pl is not modified.
+func (pl *pipeline) replaceHandler(old interface{}, handlers ...interface{}) (*pipeline, error) {
+	npl := new(pipeline)
+	found := false
+	for _, handler := range pl.handlers {
+		if !found && sameHandler(handler, old) {
+			found = true
+			for _, newHandler := range handlers {
+				if err := npl.AddHandler(newHandler); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		npl.AddHandler(handler)
+	}
+
+	if !found {
+		return nil, errors.New("net: handler not found in the pipeline")
+	}
+	return npl, nil
+}
+
+// sameHandler reports whether a and b are the same handler without panicking on uncomparable types.
+func sameHandler(a interface{}, b interface{}) bool {
+	t := reflect.TypeOf(a)
+	if (t != reflect.TypeOf(b)) || !t.Comparable() {
+		return false
+	}
+	return a == b
+}
+
 func (pl *pipeline) prependWriteHandler(writeHandler WriteHandler) {
 	pl.writeHandlers = append(pl.writeHandlers, nil)
 	copy(pl.writeHandlers[1:], pl.writeHandlers)
@@ -0,0 +1,66 @@
+// Package protobuf provides handlers that convert frames and Protocol Buffers messages.
+// Frames should be split by a frame decoder such as net.ProtobufVarint32FrameDecoder.
+// This package is separated from package net not to force the protobuf dependency on the users who don't need it.
+package protobuf
+
+import (
+	"fmt"
+
+	"github.com/shanpark/net"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec is a ReadHandler and WriteHandler that decodes inbound frames into proto.Message and encodes outbound proto.Message.
+type Codec struct {
+	factory func() proto.Message
+}
+
+// NewCodec returns a Codec. Inbound frames are decoded into messages made by the factory.
+// If factory is nil, inbound frames are passed to the next handler as they are.
+func NewCodec(factory func() proto.Message) *Codec {
+	codec := new(Codec)
+	codec.factory = factory
+	return codec
+}
+
+// OnRead implements net.ReadHandler interface.
+func (c *Codec) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	if c.factory == nil {
+		return in, nil
+	}
+
+	var data []byte
+	switch frame := in.(type) {
+	case *net.Buffer:
+		data = frame.Data()
+		frame.DataConsume(len(data))
+	case []byte:
+		data = frame
+	default:
+		return nil, fmt.Errorf("protobuf: unsupported inbound message type - %T", in)
+	}
+
+	msg := c.factory()
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// OnWrite implements net.WriteHandler interface.
+// Outbound values other than proto.Message are passed to the next handler as they are.
+func (c *Codec) OnWrite(ctx *net.SoContext, out interface{}) (interface{}, error) {
+	msg, ok := out.(proto.Message)
+	if !ok {
+		return out, nil
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := net.NewBuffer(len(data))
+	buffer.Write(data)
+	return buffer, nil
+}
@@ -0,0 +1,53 @@
+package protobuf
+
+import (
+	"testing"
+
+	"github.com/shanpark/net"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	codec := NewCodec(func() proto.Message { return new(wrapperspb.StringValue) })
+	p, err := net.NewEmbeddedPipeline(net.NewProtobufVarint32FrameDecoder(1024), net.ProtobufVarint32LengthFieldPrepender{}, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.WriteOutbound(wrapperspb.String("hello"), wrapperspb.String("world")); err != nil {
+		t.Fatal(err)
+	}
+	var stream []byte
+	for msg := p.ReadOutbound(); msg != nil; msg = p.ReadOutbound() {
+		stream = append(stream, msg.(*net.Buffer).Data()...)
+	}
+
+	// the stream is fed byte by byte to exercise rollback on fragmented input.
+	for _, b := range stream {
+		if err = p.WriteInbound([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []string{"hello", "world"} {
+		if msg, ok := p.ReadInbound().(*wrapperspb.StringValue); !ok || msg.GetValue() != expected {
+			t.Errorf("ReadInbound() = %v", msg)
+		}
+	}
+
+	if err = p.WriteInbound([]byte{1, 0xff}); err == nil {
+		t.Error("malformed message decoded")
+	}
+}
+
+func TestCodecWithoutFactory(t *testing.T) {
+	p, _ := net.NewEmbeddedPipeline(NewCodec(nil))
+	p.WriteInbound(struct{}{})
+	if _, ok := p.ReadInbound().(struct{}); !ok {
+		t.Error("inbound message is not passed as it is")
+	}
+	p.WriteOutbound("raw")
+	if msg := p.ReadOutbound(); msg != "raw" {
+		t.Errorf("ReadOutbound() = %v", msg)
+	}
+}
@@ -0,0 +1,125 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	stdnet "net"
+	"strconv"
+)
+
+// Marshal returns the encoding of the header in h.Version.
+// In version 1, a header whose command is Local or whose addresses are not TCP addresses is encoded as "PROXY UNKNOWN".
+// In version 2, the CRC32C TLV is filled with the checksum if the header has one.
+func (h *Header) Marshal() ([]byte, error) {
+	switch h.Version {
+	case 1:
+		return h.marshalV1(), nil
+	case 2:
+		return h.marshalV2()
+	}
+	return nil, fmt.Errorf("proxyproto: invalid version %d", h.Version)
+}
+
+func (h *Header) marshalV1() []byte {
+	src, srcOK := h.SourceAddr.(*stdnet.TCPAddr)
+	dst, dstOK := h.DestinationAddr.(*stdnet.TCPAddr)
+	if h.Command == Local || !srcOK || !dstOK || (src.IP.To4() != nil) != (dst.IP.To4() != nil) {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	protocol := "TCP6"
+	if src.IP.To4() != nil {
+		protocol = "TCP4"
+	}
+	line := "PROXY " + protocol + " " + src.IP.String() + " " + dst.IP.String() + " " +
+		strconv.Itoa(src.Port) + " " + strconv.Itoa(dst.Port) + "\r\n"
+	return []byte(line)
+}
+
+func (h *Header) marshalV2() ([]byte, error) {
+	if h.Command != Local && h.Command != Proxy {
+		return nil, fmt.Errorf("proxyproto: invalid command %d", h.Command)
+	}
+
+	data := append([]byte{}, signatureV2...)
+	data = append(data, 0x20|byte(h.Command), 0, 0, 0)
+
+	var family, transport byte
+	switch src := h.SourceAddr.(type) {
+	case *stdnet.TCPAddr:
+		transport = 0x1
+		family, data = appendIPAddrs(data, src.IP, src.Port, h.DestinationAddr)
+	case *stdnet.UDPAddr:
+		transport = 0x2
+		family, data = appendIPAddrs(data, src.IP, src.Port, h.DestinationAddr)
+	case *stdnet.UnixAddr:
+		dst, ok := h.DestinationAddr.(*stdnet.UnixAddr)
+		if !ok || len(src.Name) > 108 || len(dst.Name) > 108 {
+			return nil, errors.New("proxyproto: invalid unix addresses")
+		}
+		family, transport = 0x3, 0x1
+		if src.Net == "unixgram" {
+			transport = 0x2
+		}
+		addrs := make([]byte, 216)
+		copy(addrs, src.Name)
+		copy(addrs[108:], dst.Name)
+		data = append(data, addrs...)
+	}
+	if family == 0 {
+		transport = 0
+	}
+	data[13] = family<<4 | transport
+
+	crcPos := -1
+	for _, tlv := range h.TLVs {
+		if len(tlv.Value) > 0xffff {
+			return nil, errors.New("proxyproto: TLV too long")
+		}
+		if tlv.Type == TypeCRC32C {
+			crcPos = len(data) + 3
+			tlv.Value = make([]byte, 4)
+		}
+		data = append(data, tlv.Type, byte(len(tlv.Value)>>8), byte(len(tlv.Value)))
+		data = append(data, tlv.Value...)
+	}
+	if len(data)-16 > 0xffff {
+		return nil, errors.New("proxyproto: header too long")
+	}
+	binary.BigEndian.PutUint16(data[14:], uint16(len(data)-16))
+
+	if crcPos >= 0 {
+		binary.BigEndian.PutUint32(data[crcPos:], crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	}
+	return data, nil
+}
+
+// appendIPAddrs appends the address block of AF_INET or AF_INET6. It returns 0 as family if the addresses are not valid.
+func appendIPAddrs(data []byte, srcIP stdnet.IP, srcPort int, dstAddr stdnet.Addr) (byte, []byte) {
+	var dstIP stdnet.IP
+	var dstPort int
+	switch dst := dstAddr.(type) {
+	case *stdnet.TCPAddr:
+		dstIP, dstPort = dst.IP, dst.Port
+	case *stdnet.UDPAddr:
+		dstIP, dstPort = dst.IP, dst.Port
+	default:
+		return 0, data
+	}
+
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		data = append(data, src4...)
+		data = append(data, dst4...)
+		data = append(data, byte(srcPort>>8), byte(srcPort), byte(dstPort>>8), byte(dstPort))
+		return 0x1, data
+	}
+	if src16, dst16 := srcIP.To16(), dstIP.To16(); src16 != nil && dst16 != nil {
+		data = append(data, src16...)
+		data = append(data, dst16...)
+		data = append(data, byte(srcPort>>8), byte(srcPort), byte(dstPort>>8), byte(dstPort))
+		return 0x2, data
+	}
+	return 0, data
+}
@@ -0,0 +1,140 @@
+package proxyproto
+
+import (
+	"fmt"
+	stdnet "net"
+
+	"github.com/shanpark/net"
+)
+
+// Decoder is a net.ConnectHandler and net.ReadHandler that parses the PROXY protocol header at the start of the stream.
+// It should be the first read handler of a TCPServer. After the header is parsed, the header is available with HeaderOf()
+// and ctx.RemoteAddr() and ctx.LocalAddr() return the original source and destination addresses.
+// Then Decoder removes itself from the pipeline of the connection, so the rest of the stream is passed to the next handlers as it is.
+//
+// Decoder doesn't work on a TLSServer because the header precedes the TLS handshake.
+type Decoder struct {
+	trusted  []*stdnet.IPNet
+	required bool
+}
+
+// NewDecoder returns a Decoder that trusts all peers and accepts a connection without a header.
+func NewDecoder() *Decoder {
+	return new(Decoder)
+}
+
+// SetTrustedCIDRs sets the networks of the proxies allowed to send a header, e.g. "10.0.0.0/8".
+// The header of a connection from other peers is not parsed but passed to the next handler as data.
+// If no network is set, all peers are trusted.
+func (d *Decoder) SetTrustedCIDRs(cidrs ...string) error {
+	trusted := make([]*stdnet.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := stdnet.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("proxyproto: invalid CIDR %q", cidr)
+		}
+		trusted = append(trusted, ipNet)
+	}
+	d.trusted = trusted
+	return nil
+}
+
+// SetRequired sets whether a trusted peer must send a header.
+// If required is true, a connection from a trusted peer that doesn't start with a header is closed with ErrNoHeader.
+func (d *Decoder) SetRequired(required bool) {
+	d.required = required
+}
+
+// OnConnect implements net.ConnectHandler interface.
+func (d *Decoder) OnConnect(ctx *net.SoContext) error {
+	if !d.isTrusted(ctx.Conn().RemoteAddr()) {
+		return ctx.RemoveHandler(d)
+	}
+	return nil
+}
+
+// OnRead implements net.ReadHandler interface.
+func (d *Decoder) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("proxyproto: unsupported inbound message type - %T", in)
+	}
+
+	header, n, err := Parse(buffer.Data())
+	switch err {
+	case nil:
+	case ErrIncomplete:
+		ctx.Rollback()
+		return nil, nil
+	case ErrNoHeader:
+		if d.required {
+			ctx.Close()
+			return nil, err
+		}
+		if err = ctx.RemoveHandler(d); err != nil {
+			return nil, err
+		}
+		return buffer, nil
+	default:
+		ctx.Close()
+		return nil, err
+	}
+
+	buffer.DataConsume(n)
+	if header.Command == Proxy {
+		ctx.SetAddr(header.DestinationAddr, header.SourceAddr)
+	}
+	ctx.SetValue(headerKey{}, header)
+	if err = ctx.RemoveHandler(d); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (d *Decoder) isTrusted(addr stdnet.Addr) bool {
+	if len(d.trusted) == 0 {
+		return true
+	}
+
+	var ip stdnet.IP
+	switch a := addr.(type) {
+	case *stdnet.TCPAddr:
+		ip = a.IP
+	case *stdnet.UDPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, ipNet := range d.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Encoder is a net.ConnectHandler that sends a PROXY protocol header when a TCPClient connects,
+// so the client can relay a connection to a server behind it with the original addresses.
+// It should be the first connect handler so that the header precedes any other data.
+type Encoder struct {
+	header *Header
+}
+
+// NewEncoder returns an Encoder that sends header.
+func NewEncoder(header *Header) *Encoder {
+	encoder := new(Encoder)
+	encoder.header = header
+	return encoder
+}
+
+// OnConnect implements net.ConnectHandler interface.
+func (e *Encoder) OnConnect(ctx *net.SoContext) error {
+	data, err := e.header.Marshal()
+	if err != nil {
+		return err
+	}
+
+	buffer := net.NewBuffer(len(data))
+	buffer.Write(data)
+	return ctx.WriteAndFlush(buffer)
+}
@@ -0,0 +1,328 @@
+// Package proxyproto implements the PROXY protocol (version 1 and 2) of HAProxy for the pipeline of package net.
+// Decoder parses the header at the start of the stream on a server behind a load balancer
+// and overrides the addresses of the session with the original ones. Encoder sends the header on a client.
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	stdnet "net"
+	"strconv"
+	"strings"
+
+	"github.com/shanpark/net"
+)
+
+// Commands of the header.
+const (
+	Local = 0 // the connection is made by the proxy itself, e.g. for a health check.
+	Proxy = 1 // the connection is relayed on behalf of another node.
+)
+
+// Types of TLV defined by the specification.
+const (
+	TypeALPN      = 0x01
+	TypeAuthority = 0x02
+	TypeCRC32C    = 0x03
+	TypeNoop      = 0x04
+	TypeUniqueID  = 0x05
+	TypeSSL       = 0x20
+	TypeNetNS     = 0x30
+)
+
+// Sub-types of the SSL TLV.
+const (
+	SubtypeSSLVersion = 0x21
+	SubtypeSSLCN      = 0x22
+	SubtypeSSLCipher  = 0x23
+	SubtypeSSLSigAlg  = 0x24
+	SubtypeSSLKeyAlg  = 0x25
+)
+
+// Client flags of the SSL TLV.
+const (
+	ClientSSL      = 0x01
+	ClientCertConn = 0x02
+	ClientCertSess = 0x04
+)
+
+const maxV1HeaderSize = 107
+
+var signatureV1 = []byte("PROXY ")
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrIncomplete is returned when data ends in the middle of a header.
+var ErrIncomplete = errors.New("proxyproto: incomplete header")
+
+// ErrNoHeader is returned when a header is required but the stream doesn't start with a header.
+var ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+
+// Header is a PROXY protocol header.
+// SourceAddr and DestinationAddr are nil if the command is Local or the address family is unknown.
+type Header struct {
+	Version         int // 1 or 2
+	Command         int
+	SourceAddr      stdnet.Addr
+	DestinationAddr stdnet.Addr
+	TLVs            []TLV // version 2 only
+}
+
+// TLV is a type-length-value vector of a version 2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// SSL is the value of the SSL TLV.
+type SSL struct {
+	Client byte   // combination of ClientSSL, ClientCertConn and ClientCertSess
+	Verify uint32 // 0 if the client certificate is verified
+	TLVs   []TLV  // sub-TLVs such as SubtypeSSLVersion
+}
+
+// TLV returns the value of the first TLV of typ. It returns false if the header doesn't have one.
+func (h *Header) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ALPN returns the application protocol negotiated by the client with the proxy.
+func (h *Header) ALPN() string {
+	value, _ := h.TLV(TypeALPN)
+	return string(value)
+}
+
+// Authority returns the host name sent by the client with SNI.
+func (h *Header) Authority() string {
+	value, _ := h.TLV(TypeAuthority)
+	return string(value)
+}
+
+// UniqueID returns the unique ID of the connection given by the proxy.
+func (h *Header) UniqueID() []byte {
+	value, _ := h.TLV(TypeUniqueID)
+	return value
+}
+
+// SSL returns the SSL TLV. It returns nil if the header doesn't have one.
+func (h *Header) SSL() (*SSL, error) {
+	value, ok := h.TLV(TypeSSL)
+	if !ok {
+		return nil, nil
+	}
+	if len(value) < 5 {
+		return nil, errors.New("proxyproto: invalid SSL TLV")
+	}
+	tlvs, err := parseTLVs(value[5:])
+	if err != nil {
+		return nil, err
+	}
+	return &SSL{value[0], binary.BigEndian.Uint32(value[1:]), tlvs}, nil
+}
+
+// Value returns the value of the first sub-TLV of subtype as a string.
+func (s *SSL) Value(subtype byte) string {
+	for _, tlv := range s.TLVs {
+		if tlv.Type == subtype {
+			return string(tlv.Value)
+		}
+	}
+	return ""
+}
+
+type headerKey struct{}
+
+// HeaderOf returns the header received on the connection of ctx. It returns nil if no header is received.
+func HeaderOf(ctx *net.SoContext) *Header {
+	header, _ := ctx.Value(headerKey{}).(*Header)
+	return header
+}
+
+// Parse parses a header at the start of data and returns the header and its size.
+// If data doesn't start with a header, it returns ErrNoHeader, and if data ends in the middle of a header, it returns ErrIncomplete.
+func Parse(data []byte) (*Header, int, error) {
+	switch {
+	case hasPrefix(data, signatureV2):
+		if len(data) < len(signatureV2) {
+			return nil, 0, ErrIncomplete
+		}
+		return parseV2(data)
+	case hasPrefix(data, signatureV1):
+		if len(data) < len(signatureV1) {
+			return nil, 0, ErrIncomplete
+		}
+		return parseV1(data)
+	}
+	return nil, 0, ErrNoHeader
+}
+
+// hasPrefix reports whether data starts with prefix or data is a prefix of prefix.
+func hasPrefix(data []byte, prefix []byte) bool {
+	if len(data) < len(prefix) {
+		return bytes.HasPrefix(prefix, data)
+	}
+	return bytes.HasPrefix(data, prefix)
+}
+
+func parseV1(data []byte) (*Header, int, error) {
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		if len(data) >= maxV1HeaderSize {
+			return nil, 0, errors.New("proxyproto: header line too long")
+		}
+		return nil, 0, ErrIncomplete
+	}
+	if end+2 > maxV1HeaderSize {
+		return nil, 0, errors.New("proxyproto: header line too long")
+	}
+
+	fields := strings.Split(string(data[:end]), " ")
+	header := &Header{Version: 1, Command: Proxy}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, fmt.Errorf("proxyproto: invalid header %q", data[:end])
+	}
+
+	src, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, 0, err
+	}
+	dst, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, 0, err
+	}
+	header.SourceAddr, header.DestinationAddr = src, dst
+	return header, end + 2, nil
+}
+
+func parseV1Addr(protocol string, ip string, port string) (*stdnet.TCPAddr, error) {
+	addr := stdnet.ParseIP(ip)
+	if addr == nil || (protocol == "TCP6") != strings.Contains(ip, ":") {
+		return nil, fmt.Errorf("proxyproto: invalid address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("proxyproto: invalid port %q", port)
+	}
+	return &stdnet.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func parseV2(data []byte) (*Header, int, error) {
+	if len(data) < 16 {
+		return nil, 0, ErrIncomplete
+	}
+	if data[12]>>4 != 2 {
+		return nil, 0, fmt.Errorf("proxyproto: invalid version %d", data[12]>>4)
+	}
+	size := 16 + int(binary.BigEndian.Uint16(data[14:]))
+	if len(data) < size {
+		return nil, 0, ErrIncomplete
+	}
+
+	header := &Header{Version: 2, Command: int(data[12] & 0x0f)}
+	if header.Command != Local && header.Command != Proxy {
+		return nil, 0, fmt.Errorf("proxyproto: invalid command %d", header.Command)
+	}
+
+	payload := data[16:size]
+	var addrSize int
+	family, transport := data[13]>>4, data[13]&0x0f
+	switch family {
+	case 0x1: // AF_INET
+		addrSize = 12
+	case 0x2: // AF_INET6
+		addrSize = 36
+	case 0x3: // AF_UNIX
+		addrSize = 216
+	}
+	if len(payload) < addrSize {
+		return nil, 0, errors.New("proxyproto: address block too short")
+	}
+
+	if header.Command == Proxy {
+		switch family {
+		case 0x1, 0x2:
+			ipSize := addrSize/2 - 2
+			srcIP := stdnet.IP(append([]byte{}, payload[:ipSize]...))
+			dstIP := stdnet.IP(append([]byte{}, payload[ipSize:2*ipSize]...))
+			srcPort := int(binary.BigEndian.Uint16(payload[2*ipSize:]))
+			dstPort := int(binary.BigEndian.Uint16(payload[2*ipSize+2:]))
+			if transport == 0x2 { // DGRAM
+				header.SourceAddr = &stdnet.UDPAddr{IP: srcIP, Port: srcPort}
+				header.DestinationAddr = &stdnet.UDPAddr{IP: dstIP, Port: dstPort}
+			} else {
+				header.SourceAddr = &stdnet.TCPAddr{IP: srcIP, Port: srcPort}
+				header.DestinationAddr = &stdnet.TCPAddr{IP: dstIP, Port: dstPort}
+			}
+		case 0x3:
+			network := "unix"
+			if transport == 0x2 {
+				network = "unixgram"
+			}
+			header.SourceAddr = &stdnet.UnixAddr{Name: cString(payload[:108]), Net: network}
+			header.DestinationAddr = &stdnet.UnixAddr{Name: cString(payload[108:216]), Net: network}
+		}
+	}
+
+	tlvs, err := parseTLVs(payload[addrSize:])
+	if err != nil {
+		return nil, 0, err
+	}
+	header.TLVs = tlvs
+	if err = verifyCRC32C(data[:size], addrSize, tlvs); err != nil {
+		return nil, 0, err
+	}
+	return header, size, nil
+}
+
+func parseTLVs(data []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, errors.New("proxyproto: invalid TLV")
+		}
+		length := int(binary.BigEndian.Uint16(data[1:]))
+		if len(data) < 3+length {
+			return nil, errors.New("proxyproto: invalid TLV length")
+		}
+		tlvs = append(tlvs, TLV{data[0], append([]byte{}, data[3:3+length]...)})
+		data = data[3+length:]
+	}
+	return tlvs, nil
+}
+
+// verifyCRC32C verifies the checksum of the header if the header has the CRC32C TLV.
+func verifyCRC32C(header []byte, addrSize int, tlvs []TLV) error {
+	pos := 16 + addrSize
+	for _, tlv := range tlvs {
+		if tlv.Type == TypeCRC32C {
+			if len(tlv.Value) != 4 {
+				return errors.New("proxyproto: invalid CRC32C TLV")
+			}
+			zeroed := append([]byte{}, header...)
+			copy(zeroed[pos+3:pos+7], []byte{0, 0, 0, 0})
+			if crc32.Checksum(zeroed, crc32.MakeTable(crc32.Castagnoli)) != binary.BigEndian.Uint32(tlv.Value) {
+				return errors.New("proxyproto: CRC32C mismatch")
+			}
+			return nil
+		}
+		pos += 3 + len(tlv.Value)
+	}
+	return nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
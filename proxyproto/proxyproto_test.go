@@ -0,0 +1,83 @@
+package proxyproto
+
+import (
+	stdnet "net"
+	"reflect"
+	"testing"
+)
+
+func TestParseV1(t *testing.T) {
+	data := []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET /")
+	header, n, err := Parse(data)
+	if err != nil || n != len(data)-5 {
+		t.Fatalf("Parse() = %d, %v", n, err)
+	}
+	if src := header.SourceAddr.String(); src != "192.168.0.1:56324" {
+		t.Errorf("SourceAddr = %s", src)
+	}
+	if dst := header.DestinationAddr.String(); dst != "192.168.0.11:443" {
+		t.Errorf("DestinationAddr = %s", dst)
+	}
+
+	for i := 0; i < n; i++ {
+		if _, _, err = Parse(data[:i]); err != ErrIncomplete {
+			t.Errorf("Parse() of %d bytes error = %v", i, err)
+		}
+	}
+	if _, _, err = Parse([]byte("GET / HTTP/1.1\r\n")); err != ErrNoHeader {
+		t.Errorf("Parse() of no header error = %v", err)
+	}
+	if _, _, err = Parse([]byte("PROXY TCP4 ::1 ::1 1 2\r\n")); err == nil {
+		t.Error("Parse() accepted IPv6 address for TCP4")
+	}
+}
+
+func TestRoundTripV2(t *testing.T) {
+	headers := []*Header{
+		{Version: 2, Command: Proxy,
+			SourceAddr:      &stdnet.TCPAddr{IP: stdnet.IP{10, 0, 0, 1}, Port: 1234},
+			DestinationAddr: &stdnet.TCPAddr{IP: stdnet.IP{10, 0, 0, 2}, Port: 80},
+			TLVs:            []TLV{{TypeALPN, []byte("h2")}, {TypeSSL, []byte{ClientSSL, 0, 0, 0, 0, SubtypeSSLVersion, 0, 7, 'T', 'L', 'S', 'v', '1', '.', '3'}}}},
+		{Version: 2, Command: Proxy,
+			SourceAddr:      &stdnet.UDPAddr{IP: stdnet.ParseIP("2001:db8::1"), Port: 53},
+			DestinationAddr: &stdnet.UDPAddr{IP: stdnet.ParseIP("2001:db8::2"), Port: 5353},
+			TLVs:            []TLV{{TypeCRC32C, []byte{0, 0, 0, 0}}}},
+		{Version: 2, Command: Proxy,
+			SourceAddr:      &stdnet.UnixAddr{Name: "/tmp/src.sock", Net: "unix"},
+			DestinationAddr: &stdnet.UnixAddr{Name: "/tmp/dst.sock", Net: "unix"}},
+		{Version: 2, Command: Local},
+	}
+
+	for _, header := range headers {
+		data, err := header.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, n, err := Parse(append(data, 'x'))
+		if err != nil || n != len(data) {
+			t.Fatalf("Parse() = %d, %v", n, err)
+		}
+		if _, ok := header.TLV(TypeCRC32C); ok {
+			header.TLVs = decoded.TLVs // the checksum is filled by Marshal().
+		}
+		if !reflect.DeepEqual(decoded, header) {
+			t.Errorf("Parse() = %+v, want %+v", decoded, header)
+		}
+		for i := 0; i < n; i++ {
+			if _, _, err = Parse(data[:i]); err != ErrIncomplete {
+				t.Errorf("Parse() of %d bytes error = %v", i, err)
+			}
+		}
+	}
+
+	ssl, err := headers[0].SSL()
+	if err != nil || ssl.Client != ClientSSL || ssl.Value(SubtypeSSLVersion) != "TLSv1.3" || headers[0].ALPN() != "h2" {
+		t.Errorf("SSL() = %+v, %v", ssl, err)
+	}
+
+	data, _ := headers[1].Marshal()
+	data[len(data)-1] ^= 0xff
+	if _, _, err = Parse(data); err == nil {
+		t.Error("Parse() accepted a header with wrong checksum")
+	}
+}
@@ -0,0 +1,128 @@
+package resp
+
+import (
+	"fmt"
+
+	"github.com/shanpark/net"
+)
+
+// DefaultMaxSize is the default maximum size of an inbound value.
+// Redis allows bulk strings up to 512MB, so a client that receives larger values should raise it with SetMaxSize().
+const DefaultMaxSize = 1024 * 1024
+
+// Codec is a net.ReadHandler and net.WriteHandler that decodes inbound RESP values and encodes outbound values.
+// Codec should be placed on the stream directly. If a whole value is not received yet, it requests rollback and waits for more data.
+//
+// Decoded values are passed to the next handler, and outbound values are encoded into a *net.Buffer.
+// Outbound *net.Buffer is regarded as already encoded and passed as it is.
+// A server that accepts HELLO can switch the protocol of a connection with SetContextProtocol().
+type Codec struct {
+	protocol int
+	maxSize  int
+	inline   bool
+}
+
+type protocolKey struct {
+	codec *Codec
+}
+
+// NewCodec returns a Codec that encodes values in RESP2.
+func NewCodec() *Codec {
+	codec := new(Codec)
+	codec.protocol = RESP2
+	codec.maxSize = DefaultMaxSize
+	return codec
+}
+
+// SetProtocol sets the protocol used to encode outbound values. It should be RESP2 or RESP3.
+func (c *Codec) SetProtocol(protocol int) {
+	c.protocol = protocol
+}
+
+// SetContextProtocol sets the protocol used to encode outbound values of the connection of ctx.
+// It overrides the protocol set by SetProtocol() for the connection.
+func (c *Codec) SetContextProtocol(ctx *net.SoContext, protocol int) {
+	ctx.SetValue(protocolKey{c}, protocol)
+}
+
+// SetMaxSize sets the maximum size of an inbound value. If size is 0 or less, the size is not limited.
+func (c *Codec) SetMaxSize(size int) {
+	c.maxSize = size
+}
+
+// SetInlineCommands sets whether inline commands such as "PING\r\n" are accepted.
+// It is useful for a server that accepts commands typed with telnet.
+// An inline command is passed to the next handler as an array of bulk strings.
+func (c *Codec) SetInlineCommands(enable bool) {
+	c.inline = enable
+}
+
+// OnRead implements net.ReadHandler interface.
+func (c *Codec) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("resp: unsupported inbound message type - %T", in)
+	}
+
+	data := buffer.Data()
+	if len(data) == 0 {
+		ctx.Rollback()
+		return nil, nil
+	}
+
+	var v interface{}
+	var n int
+	var err error
+	if c.inline && !isTypeByte(data[0]) {
+		v, n, err = ParseInline(data)
+	} else {
+		n, err = Size(data) // doesn't allocate until the whole value is received.
+		if err == nil && (c.maxSize <= 0 || n <= c.maxSize) {
+			v, _, err = Parse(data[:n])
+		}
+	}
+	if err == ErrIncomplete {
+		if c.maxSize > 0 && len(data) > c.maxSize {
+			return nil, net.ErrFrameTooLong
+		}
+		ctx.Rollback()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.maxSize > 0 && n > c.maxSize {
+		return nil, net.ErrFrameTooLong
+	}
+
+	buffer.DataConsume(n)
+	return v, nil
+}
+
+// OnWrite implements net.WriteHandler interface.
+func (c *Codec) OnWrite(ctx *net.SoContext, out interface{}) (interface{}, error) {
+	if buffer, ok := out.(*net.Buffer); ok {
+		return buffer, nil
+	}
+
+	protocol, ok := ctx.Value(protocolKey{c}).(int)
+	if !ok {
+		protocol = c.protocol
+	}
+	data, err := Marshal(out, protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := net.NewBuffer(len(data))
+	buffer.Write(data)
+	return buffer, nil
+}
+
+func isTypeByte(b byte) bool {
+	switch b {
+	case '+', '-', ':', '$', '*', '_', '#', ',', '(', '!', '=', '%', '~', '>', '|':
+		return true
+	}
+	return false
+}
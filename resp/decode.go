@@ -0,0 +1,284 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+const maxNestingDepth = 512
+
+var crlf = []byte("\r\n")
+
+// Parse decodes a value at the start of data. It returns the value and the size of data consumed.
+// If data doesn't contain a whole value, it returns ErrIncomplete.
+func Parse(data []byte) (interface{}, int, error) {
+	p := parser{data: data}
+	v, err := p.value(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, p.pos, nil
+}
+
+// Size returns the size of the value at the start of data.
+// If data ends in the middle of the value, it returns ErrIncomplete.
+// It doesn't decode the value, so it can be used to detect whether a whole value has been received.
+func Size(data []byte) (int, error) {
+	p := parser{data: data}
+	for remain := 1; remain > 0; remain-- { // number of values to skip
+		if p.pos >= len(p.data) {
+			return 0, ErrIncomplete
+		}
+		typ := p.data[p.pos]
+		p.pos++
+		switch typ {
+		case '+', '-', ':', '_', '#', ',', '(':
+			if _, err := p.line(); err != nil {
+				return 0, err
+			}
+		case '$', '!', '=':
+			n, err := p.length()
+			if err != nil {
+				return 0, err
+			}
+			if n >= 0 {
+				if len(p.data)-p.pos-2 < n {
+					return 0, ErrIncomplete
+				}
+				p.pos += n + 2
+			}
+		case '*', '~', '>', '%', '|':
+			n, err := p.length()
+			if err != nil {
+				return 0, err
+			}
+			if (len(p.data)-p.pos)/3 < n { // every element takes 3 bytes at least.
+				return 0, ErrIncomplete
+			}
+			switch typ {
+			case '%':
+				n *= 2
+			case '|':
+				n = 2*n + 1 // the attributes and the value
+			}
+			if n > 0 {
+				remain += n
+			}
+		default:
+			return 0, fmt.Errorf("resp: invalid type byte - %q", typ)
+		}
+	}
+	return p.pos, nil
+}
+
+// ParseInline decodes an inline command such as "PING\r\n" at the start of data.
+// The command is returned as an array of bulk strings like a command sent in RESP.
+func ParseInline(data []byte) ([]interface{}, int, error) {
+	p := parser{data: data}
+	line, err := p.line()
+	if err != nil {
+		return nil, 0, err
+	}
+	fields := bytes.Fields(line)
+	command := make([]interface{}, len(fields))
+	for i, field := range fields {
+		command[i] = field
+	}
+	return command, p.pos, nil
+}
+
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func (p *parser) value(depth int) (interface{}, error) {
+	if depth > maxNestingDepth {
+		return nil, errors.New("resp: nesting too deep")
+	}
+	if p.pos >= len(p.data) {
+		return nil, ErrIncomplete
+	}
+
+	typ := p.data[p.pos]
+	p.pos++
+	switch typ {
+	case '+':
+		line, err := p.line()
+		return SimpleString(line), err
+	case '-':
+		line, err := p.line()
+		return Error(line), err
+	case ':':
+		return p.integer()
+	case '$':
+		blob, err := p.blob()
+		if blob == nil && err == nil {
+			return Null{}, nil
+		}
+		return blob, err
+	case '!':
+		blob, err := p.blob()
+		return Error(blob), err
+	case '=':
+		blob, err := p.blob()
+		if err != nil {
+			return nil, err
+		}
+		if len(blob) < 4 || blob[3] != ':' {
+			return nil, fmt.Errorf("resp: invalid verbatim string - %q", blob)
+		}
+		return Verbatim{string(blob[:3]), string(blob[4:])}, nil
+	case '*', '~', '>':
+		elements, err := p.aggregate(depth, 1)
+		if elements == nil && err == nil {
+			return Null{}, nil
+		}
+		switch typ {
+		case '~':
+			return Set(elements), err
+		case '>':
+			return Push(elements), err
+		}
+		return elements, err
+	case '%':
+		return p.pairs(depth)
+	case '|':
+		attributes, err := p.pairs(depth)
+		if err != nil {
+			return nil, err
+		}
+		v, err := p.value(depth + 1)
+		return Attributed{attributes, v}, err
+	case '_':
+		line, err := p.line()
+		if err == nil && len(line) > 0 {
+			err = fmt.Errorf("resp: invalid null - %q", line)
+		}
+		return Null{}, err
+	case '#':
+		line, err := p.line()
+		if err != nil {
+			return nil, err
+		}
+		switch string(line) {
+		case "t":
+			return true, nil
+		case "f":
+			return false, nil
+		}
+		return nil, fmt.Errorf("resp: invalid boolean - %q", line)
+	case ',':
+		line, err := p.line()
+		if err != nil {
+			return nil, err
+		}
+		f, err := strconv.ParseFloat(string(line), 64)
+		if err != nil {
+			return nil, fmt.Errorf("resp: invalid double - %q", line)
+		}
+		return f, nil
+	case '(':
+		line, err := p.line()
+		if err != nil {
+			return nil, err
+		}
+		n, ok := new(big.Int).SetString(string(line), 10)
+		if !ok {
+			return nil, fmt.Errorf("resp: invalid big number - %q", line)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("resp: invalid type byte - %q", typ)
+}
+
+// line returns the line at the current position without CRLF.
+func (p *parser) line() ([]byte, error) {
+	end := bytes.Index(p.data[p.pos:], crlf)
+	if end < 0 {
+		return nil, ErrIncomplete
+	}
+	line := p.data[p.pos : p.pos+end]
+	p.pos += end + 2
+	return line, nil
+}
+
+func (p *parser) integer() (int64, error) {
+	line, err := p.line()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("resp: invalid integer - %q", line)
+	}
+	return n, nil
+}
+
+// length returns the length of a blob or an aggregate. -1 means null.
+func (p *parser) length() (int, error) {
+	n, err := p.integer()
+	if err != nil {
+		return 0, err
+	}
+	if n < -1 || int64(int(n)) != n {
+		return 0, fmt.Errorf("resp: invalid length %d", n)
+	}
+	return int(n), nil
+}
+
+// blob returns a copy of the blob at the current position. It returns nil for the null bulk string.
+func (p *parser) blob() ([]byte, error) {
+	n, err := p.length()
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	if len(p.data)-p.pos-2 < n {
+		return nil, ErrIncomplete
+	}
+	if !bytes.Equal(p.data[p.pos+n:p.pos+n+2], crlf) {
+		return nil, errors.New("resp: missing CRLF after blob")
+	}
+	blob := make([]byte, n)
+	copy(blob, p.data[p.pos:])
+	p.pos += n + 2
+	return blob, nil
+}
+
+// aggregate decodes n*width elements where n is the length at the current position. It returns nil for the null array.
+func (p *parser) aggregate(depth int, width int) ([]interface{}, error) {
+	n, err := p.length()
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	if (len(p.data)-p.pos)/(3*width) < n { // every element takes 3 bytes at least.
+		return nil, ErrIncomplete
+	}
+	n *= width
+
+	elements := make([]interface{}, n)
+	for i := range elements {
+		if elements[i], err = p.value(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+func (p *parser) pairs(depth int) (Map, error) {
+	elements, err := p.aggregate(depth, 2)
+	if err != nil {
+		return nil, err
+	}
+	if elements == nil {
+		return nil, errors.New("resp: invalid map length")
+	}
+	m := make(Map, len(elements)/2)
+	for i := range m {
+		m[i] = Pair{elements[2*i], elements[2*i+1]}
+	}
+	return m, nil
+}
@@ -0,0 +1,216 @@
+package resp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Marshal returns the encoding of v in protocol, which should be RESP2 or RESP3.
+func Marshal(v interface{}, protocol int) ([]byte, error) {
+	return Append(nil, v, protocol)
+}
+
+// Append appends the encoding of v in protocol to dst and returns the extended buffer.
+func Append(dst []byte, v interface{}, protocol int) ([]byte, error) {
+	resp3 := protocol == RESP3
+
+	switch v := v.(type) {
+	case nil, Null:
+		if resp3 {
+			return append(dst, "_\r\n"...), nil
+		}
+		return append(dst, "$-1\r\n"...), nil
+	case SimpleString:
+		if strings.ContainsAny(string(v), "\r\n") {
+			return nil, fmt.Errorf("resp: simple string contains CR or LF - %q", v)
+		}
+		return appendLine(dst, '+', string(v)), nil
+	case Error:
+		return appendError(dst, string(v), resp3), nil
+	case error:
+		return appendError(dst, v.Error(), resp3), nil
+	case string:
+		return appendBlob(dst, '$', v), nil
+	case []byte:
+		return appendBlob(dst, '$', string(v)), nil
+	case Verbatim:
+		if len(v.Format) != 3 {
+			return nil, fmt.Errorf("resp: invalid verbatim format - %q", v.Format)
+		}
+		if resp3 {
+			return appendBlob(dst, '=', v.Format+":"+v.Text), nil
+		}
+		return appendBlob(dst, '$', v.Text), nil
+	case int:
+		return appendLine(dst, ':', strconv.FormatInt(int64(v), 10)), nil
+	case int8:
+		return appendLine(dst, ':', strconv.FormatInt(int64(v), 10)), nil
+	case int16:
+		return appendLine(dst, ':', strconv.FormatInt(int64(v), 10)), nil
+	case int32:
+		return appendLine(dst, ':', strconv.FormatInt(int64(v), 10)), nil
+	case int64:
+		return appendLine(dst, ':', strconv.FormatInt(v, 10)), nil
+	case uint:
+		return appendUint(dst, uint64(v), resp3), nil
+	case uint8:
+		return appendUint(dst, uint64(v), resp3), nil
+	case uint16:
+		return appendUint(dst, uint64(v), resp3), nil
+	case uint32:
+		return appendUint(dst, uint64(v), resp3), nil
+	case uint64:
+		return appendUint(dst, v, resp3), nil
+	case *big.Int:
+		if resp3 {
+			return appendLine(dst, '(', v.String()), nil
+		}
+		return appendBlob(dst, '$', v.String()), nil
+	case float32:
+		return appendDouble(dst, float64(v), resp3), nil
+	case float64:
+		return appendDouble(dst, v, resp3), nil
+	case bool:
+		if resp3 {
+			if v {
+				return append(dst, "#t\r\n"...), nil
+			}
+			return append(dst, "#f\r\n"...), nil
+		}
+		if v {
+			return append(dst, ":1\r\n"...), nil
+		}
+		return append(dst, ":0\r\n"...), nil
+	case []interface{}:
+		return appendAggregate(dst, '*', v, protocol)
+	case Set:
+		if resp3 {
+			return appendAggregate(dst, '~', v, protocol)
+		}
+		return appendAggregate(dst, '*', v, protocol)
+	case Push:
+		if resp3 {
+			return appendAggregate(dst, '>', v, protocol)
+		}
+		return appendAggregate(dst, '*', v, protocol)
+	case []string:
+		dst = appendLine(dst, '*', strconv.Itoa(len(v)))
+		for _, s := range v {
+			dst = appendBlob(dst, '$', s)
+		}
+		return dst, nil
+	case [][]byte:
+		dst = appendLine(dst, '*', strconv.Itoa(len(v)))
+		for _, b := range v {
+			dst = appendBlob(dst, '$', string(b))
+		}
+		return dst, nil
+	case Map:
+		return appendMap(dst, '%', v, protocol)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		m := make(Map, len(keys))
+		for i, key := range keys {
+			m[i] = Pair{key, v[key]}
+		}
+		return appendMap(dst, '%', m, protocol)
+	case Attributed:
+		var err error
+		if resp3 { // attributes can't be represented in RESP2, so they are dropped.
+			if dst, err = appendMap(dst, '|', v.Attributes, protocol); err != nil {
+				return nil, err
+			}
+		}
+		return Append(dst, v.Value, protocol)
+	}
+	return nil, fmt.Errorf("resp: unsupported type - %T", v)
+}
+
+func appendLine(dst []byte, typ byte, line string) []byte {
+	dst = append(dst, typ)
+	dst = append(dst, line...)
+	return append(dst, '\r', '\n')
+}
+
+func appendBlob(dst []byte, typ byte, blob string) []byte {
+	dst = appendLine(dst, typ, strconv.Itoa(len(blob)))
+	dst = append(dst, blob...)
+	return append(dst, '\r', '\n')
+}
+
+// appendError appends an error. An error that contains CR or LF is sent as a blob error in RESP3 and with spaces in RESP2.
+func appendError(dst []byte, text string, resp3 bool) []byte {
+	if !strings.ContainsAny(text, "\r\n") {
+		return appendLine(dst, '-', text)
+	}
+	if resp3 {
+		return appendBlob(dst, '!', text)
+	}
+	return appendLine(dst, '-', strings.NewReplacer("\r", " ", "\n", " ").Replace(text))
+}
+
+func appendUint(dst []byte, v uint64, resp3 bool) []byte {
+	if v <= math.MaxInt64 {
+		return appendLine(dst, ':', strconv.FormatInt(int64(v), 10))
+	}
+	if resp3 {
+		return appendLine(dst, '(', strconv.FormatUint(v, 10))
+	}
+	return appendBlob(dst, '$', strconv.FormatUint(v, 10))
+}
+
+func appendDouble(dst []byte, v float64, resp3 bool) []byte {
+	var s string
+	switch {
+	case math.IsInf(v, 1):
+		s = "inf"
+	case math.IsInf(v, -1):
+		s = "-inf"
+	case math.IsNaN(v):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	if resp3 {
+		return appendLine(dst, ',', s)
+	}
+	return appendBlob(dst, '$', s)
+}
+
+func appendAggregate(dst []byte, typ byte, elements []interface{}, protocol int) ([]byte, error) {
+	dst = appendLine(dst, typ, strconv.Itoa(len(elements)))
+	var err error
+	for _, element := range elements {
+		if dst, err = Append(dst, element, protocol); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// appendMap appends a map. In RESP2, a map is sent as an array of keys and values.
+func appendMap(dst []byte, typ byte, m Map, protocol int) ([]byte, error) {
+	if protocol == RESP3 {
+		dst = appendLine(dst, typ, strconv.Itoa(len(m)))
+	} else {
+		dst = appendLine(dst, '*', strconv.Itoa(2*len(m)))
+	}
+	var err error
+	for _, pair := range m {
+		if dst, err = Append(dst, pair.Key, protocol); err != nil {
+			return nil, err
+		}
+		if dst, err = Append(dst, pair.Value, protocol); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,64 @@
+// Package resp implements encoding and decoding of the Redis serialization protocol (RESP2 and RESP3)
+// without external dependencies.
+//
+// Replies are decoded into the following Go values. Simple strings are decoded into SimpleString, errors and blob errors
+// into Error, integers into int64, bulk strings into []byte, arrays into []interface{}, and nulls into Null.
+// The RESP3 types are decoded into Map, Set, Push, float64, bool, *big.Int and Verbatim.
+// A reply with attributes is decoded into Attributed. Streamed strings and aggregates of RESP3 are not supported.
+//
+// When Go values are encoded, string and []byte are encoded as bulk strings, integers as integers,
+// and []interface{}, []string and [][]byte as arrays. With RESP2, the RESP3 types are encoded as their RESP2 equivalents.
+package resp
+
+import (
+	"errors"
+)
+
+// Protocol versions.
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// ErrIncomplete is returned when data ends in the middle of a value.
+var ErrIncomplete = errors.New("resp: incomplete value")
+
+// SimpleString is a simple string such as "OK".
+type SimpleString string
+
+// Error is an error reply such as "ERR unknown command".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Null is the null value. It is also used for the null bulk string and the null array of RESP2.
+type Null struct{}
+
+// Map is a RESP3 map. The order of the pairs is kept.
+type Map []Pair
+
+// Pair is a key and value pair of Map.
+type Pair struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// Set is a RESP3 set.
+type Set []interface{}
+
+// Push is a RESP3 push message such as a pub/sub message.
+type Push []interface{}
+
+// Verbatim is a RESP3 verbatim string. Format is a three-character format such as "txt" or "mkd".
+type Verbatim struct {
+	Format string
+	Text   string
+}
+
+// Attributed is a RESP3 reply with attributes.
+type Attributed struct {
+	Attributes Map
+	Value      interface{}
+}
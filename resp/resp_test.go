@@ -0,0 +1,111 @@
+package resp
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shanpark/net"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		data string
+		want interface{}
+	}{
+		{"+OK\r\n", SimpleString("OK")},
+		{"-ERR unknown\r\n", Error("ERR unknown")},
+		{":-42\r\n", int64(-42)},
+		{"$5\r\nhello\r\n", []byte("hello")},
+		{"$-1\r\n", Null{}},
+		{"*-1\r\n", Null{}},
+		{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []interface{}{[]byte("GET"), []byte("k")}},
+		{"_\r\n", Null{}},
+		{"#t\r\n", true},
+		{",1.5\r\n", 1.5},
+		{"(12345678901234567890\r\n", func() *big.Int { n, _ := new(big.Int).SetString("12345678901234567890", 10); return n }()},
+		{"!3\r\nERR\r\n", Error("ERR")},
+		{"=7\r\ntxt:abc\r\n", Verbatim{"txt", "abc"}},
+		{"%1\r\n+key\r\n:1\r\n", Map{{SimpleString("key"), int64(1)}}},
+		{"~1\r\n:1\r\n", Set{int64(1)}},
+		{">2\r\n+message\r\n$1\r\nx\r\n", Push{SimpleString("message"), []byte("x")}},
+		{"|1\r\n+ttl\r\n:3\r\n+v\r\n", Attributed{Map{{SimpleString("ttl"), int64(3)}}, SimpleString("v")}},
+	}
+
+	for _, test := range tests {
+		v, n, err := Parse([]byte(test.data + "+next\r\n"))
+		if err != nil || n != len(test.data) || !reflect.DeepEqual(v, test.want) {
+			t.Errorf("Parse(%q) = %#v, %d, %v", test.data, v, n, err)
+		}
+		if size, err := Size([]byte(test.data + "+next\r\n")); err != nil || size != len(test.data) {
+			t.Errorf("Size(%q) = %d, %v", test.data, size, err)
+		}
+		for i := 0; i < len(test.data); i++ {
+			if _, _, err = Parse([]byte(test.data[:i])); err != ErrIncomplete {
+				t.Errorf("Parse(%q) error = %v", test.data[:i], err)
+			}
+			if _, err = Size([]byte(test.data[:i])); err != ErrIncomplete {
+				t.Errorf("Size(%q) error = %v", test.data[:i], err)
+			}
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		v     interface{}
+		resp2 string
+		resp3 string
+	}{
+		{nil, "$-1\r\n", "_\r\n"},
+		{SimpleString("OK"), "+OK\r\n", "+OK\r\n"},
+		{Error("ERR x\ny"), "-ERR x y\r\n", "!7\r\nERR x\ny\r\n"},
+		{"hi", "$2\r\nhi\r\n", "$2\r\nhi\r\n"},
+		{7, ":7\r\n", ":7\r\n"},
+		{true, ":1\r\n", "#t\r\n"},
+		{2.5, "$3\r\n2.5\r\n", ",2.5\r\n"},
+		{[]string{"a"}, "*1\r\n$1\r\na\r\n", "*1\r\n$1\r\na\r\n"},
+		{Map{{"k", 1}}, "*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{Push{"m"}, "*1\r\n$1\r\nm\r\n", ">1\r\n$1\r\nm\r\n"},
+	}
+
+	for _, test := range tests {
+		if data, err := Marshal(test.v, RESP2); err != nil || string(data) != test.resp2 {
+			t.Errorf("Marshal(%#v, RESP2) = %q, %v", test.v, data, err)
+		}
+		if data, err := Marshal(test.v, RESP3); err != nil || string(data) != test.resp3 {
+			t.Errorf("Marshal(%#v, RESP3) = %q, %v", test.v, data, err)
+		}
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	command, n, err := ParseInline([]byte("SET  k v\r\n"))
+	if err != nil || n != 10 || !reflect.DeepEqual(command, []interface{}{[]byte("SET"), []byte("k"), []byte("v")}) {
+		t.Errorf("ParseInline() = %q, %d, %v", command, n, err)
+	}
+}
+
+func TestCodecMaxSize(t *testing.T) {
+	codec := NewCodec()
+	if codec.maxSize != DefaultMaxSize {
+		t.Errorf("default max size = %d", codec.maxSize)
+	}
+	codec.SetMaxSize(16)
+	p, _ := net.NewEmbeddedPipeline(codec)
+
+	if err := p.WriteInbound("$3\r\nabc\r\n$2\r\nd"); err != nil || string(p.ReadInbound().([]byte)) != "abc" || !p.IsRollback() {
+		t.Errorf("WriteInbound() = %v, rollback %v", err, p.IsRollback())
+	}
+	if err := p.WriteInbound("e\r\n"); err != nil || string(p.ReadInbound().([]byte)) != "de" {
+		t.Errorf("WriteInbound() of rest = %v", err)
+	}
+	if err := p.WriteInbound("$1000000000\r\n" + strings.Repeat("x", 16)); err != net.ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long incomplete value = %v", err)
+	}
+	p, _ = net.NewEmbeddedPipeline(codec)
+	if err := p.WriteInbound("$20\r\n" + strings.Repeat("x", 20) + "\r\n"); err != net.ErrFrameTooLong {
+		t.Errorf("WriteInbound() of long value = %v", err)
+	}
+}
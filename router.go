@@ -0,0 +1,30 @@
+package net
+
+import "reflect"
+
+// TypeRouter is a ReadHandler that routes inbound messages to ReadHandlers registered for the type of the message.
+// It is useful after a codec that decodes multiple message types from one connection.
+// The result of the routed handler is passed to the next handler. Messages of unregistered types are passed as they are.
+type TypeRouter struct {
+	routes map[reflect.Type]ReadHandler
+}
+
+// NewTypeRouter returns a TypeRouter.
+func NewTypeRouter() *TypeRouter {
+	router := new(TypeRouter)
+	router.routes = make(map[reflect.Type]ReadHandler)
+	return router
+}
+
+// Route registers the handler for messages of the type of prototype.
+func (r *TypeRouter) Route(prototype interface{}, handler ReadHandler) {
+	r.routes[reflect.TypeOf(prototype)] = handler
+}
+
+// OnRead implements ReadHandler interface.
+func (r *TypeRouter) OnRead(ctx *SoContext, in interface{}) (interface{}, error) {
+	if handler, ok := r.routes[reflect.TypeOf(in)]; ok {
+		return handler.OnRead(ctx, in)
+	}
+	return in, nil
+}
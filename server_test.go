@@ -1,11 +1,26 @@
 package net
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
+	stdnet "net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/shanpark/net/memnet"
 )
 
 const serverKey = `-----BEGIN EC PARAMETERS-----
@@ -233,3 +248,338 @@ func ExampleNewTCPClient_timeout() {
 	// Client OnTimeout:
 	// stopped.
 }
+
+func writeCertificate(t *testing.T, certFile string, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+}
+
+func servedSerial(t *testing.T, server *TLSServer) int64 {
+	conn, err := tls.Dial("tcp", server.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLSServerCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, 1)
+
+	errCh := make(chan error, 10)
+	server := NewTLSServer(nil)
+	server.SetAddress("127.0.0.1:0")
+	if err := server.SetCertificateFiles(certFile, keyFile, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	server.SetCertificateErrorHandler(func(err error) { errCh <- err })
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	if serial := servedSerial(t, server); serial != 1 {
+		t.Fatalf("served serial %d", serial)
+	}
+
+	time.Sleep(20 * time.Millisecond) // make the modification time differ on coarse file systems
+	writeCertificate(t, certFile, keyFile, 2)
+	for deadline := time.Now().Add(5 * time.Second); servedSerial(t, server) != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded")
+		}
+	}
+
+	for len(errCh) > 0 { // errors of a pair written partially
+		<-errCh
+	}
+	os.WriteFile(certFile, []byte("broken"), 0600)
+	select {
+	case <-errCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload error not reported")
+	}
+	if serial := servedSerial(t, server); serial != 2 {
+		t.Errorf("served serial %d after failed reload", serial)
+	}
+	if err := server.ReloadCertificate(); err == nil {
+		t.Error("ReloadCertificate() of broken file succeeded")
+	}
+}
+
+type contextCatcher chan *SoContext
+
+func (c contextCatcher) OnConnect(ctx *SoContext) error {
+	c <- ctx
+	return nil
+}
+
+type errorCatcher chan error
+
+func (c errorCatcher) OnError(ctx *SoContext, err error) {
+	c <- err
+}
+
+// reusedBuffer returns the same buffer for every outbound message.
+type reusedBuffer struct {
+	buffer *Buffer
+}
+
+func (r *reusedBuffer) OnWrite(ctx *SoContext, out interface{}) (interface{}, error) {
+	r.buffer.Clear()
+	r.buffer.Write([]byte(out.(string)))
+	return r.buffer, nil
+}
+
+// readWithin reads n bytes from conn within timeout and returns the bytes read.
+func readWithin(conn stdnet.Conn, n int, timeout time.Duration) string {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	data := make([]byte, n)
+	read, _ := io.ReadFull(conn, data)
+	return string(data[:read])
+}
+
+func TestWriteBatch(t *testing.T) {
+	contexts := make(contextCatcher, 1)
+	listener := memnet.NewListener("batch")
+	server := NewTCPServer()
+	server.SetListener(listener)
+	server.SetAutoFlush(false)
+	server.SetMaxWriteBatch(8)
+	server.AddHandler(contexts, &reusedBuffer{NewBuffer(16)})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := <-contexts
+
+	ctx.Write("ab")
+	ctx.Write("cd")
+	if data := readWithin(conn, 4, 50*time.Millisecond); data != "" {
+		t.Errorf("%q sent without flush", data)
+	}
+	ctx.Flush()
+	if data := readWithin(conn, 4, 5*time.Second); data != "abcd" {
+		t.Errorf("Flush() sent %q", data)
+	}
+	ctx.WriteAndFlush("ef")
+	if data := readWithin(conn, 2, 5*time.Second); data != "ef" {
+		t.Errorf("WriteAndFlush() sent %q", data)
+	}
+	ctx.Write("0123")
+	ctx.Write("4567") // fills the write batch
+	if data := readWithin(conn, 8, 5*time.Second); data != "01234567" {
+		t.Errorf("full write batch sent %q", data)
+	}
+}
+
+func TestAutoFlush(t *testing.T) {
+	contexts := make(contextCatcher, 1)
+	listener := memnet.NewListener("autoflush")
+	server := NewTCPServer()
+	server.SetListener(listener)
+	server.AddHandler(contexts)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := <-contexts
+
+	buffer := NewBuffer(16)
+	buffer.Write([]byte("hello"))
+	ctx.Write(buffer)
+	ctx.Write([]byte(" world"))
+	if data := readWithin(conn, 11, 5*time.Second); data != "hello world" {
+		t.Errorf("auto flush sent %q", data)
+	}
+}
+
+func TestSetListener(t *testing.T) {
+	contexts := make(contextCatcher, 1)
+	listener := memnet.NewListener("custom")
+	server := NewTCPServer()
+	server.SetListener(listener)
+	server.AddHandler(contexts)
+	if addr := server.Addr(); addr != nil {
+		t.Errorf("Addr() before Start() = %v", addr)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if addr := server.Addr(); addr != listener.Addr() {
+		t.Errorf("Addr() = %v", addr)
+	}
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case ctx := <-contexts:
+		if ctx.Conn().LocalAddr() != listener.Addr() {
+			t.Errorf("connection accepted on %v", ctx.Conn().LocalAddr())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not accepted")
+	}
+
+	// the listener is closed when the server stops.
+	server.Stop()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := listener.Dial()
+		if err == memnet.ErrRefused {
+			break
+		}
+		if err == nil {
+			conn.Close()
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("listener not closed")
+		}
+	}
+}
+
+// recordingFactory is a ListenerFactory that records the address to listen on.
+type recordingFactory struct {
+	stdnet.ListenConfig
+	address string
+}
+
+func (f *recordingFactory) Listen(ctx context.Context, network string, address string) (stdnet.Listener, error) {
+	f.address = address
+	return f.ListenConfig.Listen(ctx, network, address)
+}
+
+func TestSetListenerFactory(t *testing.T) {
+	contexts := make(contextCatcher, 1)
+	factory := new(recordingFactory)
+	server := NewTCPServer()
+	server.SetAddress("127.0.0.1:0")
+	server.SetListenerFactory(factory)
+	server.AddHandler(contexts)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	if factory.address != "127.0.0.1:0" {
+		t.Errorf("factory called with %q", factory.address)
+	}
+
+	// Addr() reports the port chosen by the system.
+	addr, ok := server.Addr().(*stdnet.TCPAddr)
+	if !ok || addr.Port == 0 || !addr.IP.IsLoopback() {
+		t.Fatalf("Addr() = %v", server.Addr())
+	}
+	conn, err := stdnet.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case <-contexts:
+	case <-time.After(5 * time.Second):
+		t.Error("connection not accepted")
+	}
+}
+
+// TestOutboundTypes verifies that every supported type at the end of the WriteHandler chain reaches the peer intact.
+func TestOutboundTypes(t *testing.T) {
+	contexts := make(contextCatcher, 1)
+	listener := memnet.NewListener("outbound")
+	server := NewTCPServer()
+	server.SetListener(listener)
+	server.AddHandler(contexts)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := <-contexts
+
+	large := strings.Repeat("0123456789", 20000)
+	ctx.Write([]byte("bytes:"))
+	ctx.Write("string:")
+	ctx.Write(stdnet.Buffers{[]byte("buf"), []byte("fers:")})
+	ctx.Write(strings.NewReader(large))                          // io.WriterTo
+	ctx.Write(struct{ io.Reader }{strings.NewReader(":reader")}) // io.Reader only
+
+	want := "bytes:string:buffers:" + large + ":reader"
+	if data := readWithin(conn, len(want), 5*time.Second); data != want {
+		t.Errorf("received %d bytes, want %d bytes", len(data), len(want))
+	}
+}
+
+func TestUnsupportedOutbound(t *testing.T) {
+	contexts := make(contextCatcher, 1)
+	errs := make(errorCatcher, 1)
+	listener := memnet.NewListener("unsupported")
+	server := NewTCPServer()
+	server.SetListener(listener)
+	server.AddHandler(contexts, errs)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := <-contexts
+
+	ctx.Write(42)
+	select {
+	case err := <-errs:
+		unsupported, ok := err.(*UnsupportedMessageError)
+		if !ok || unsupported.Message != 42 || !strings.Contains(err.Error(), "int") {
+			t.Errorf("error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error for an unsupported message")
+	}
+
+	// the connection is still usable.
+	ctx.Write("ok")
+	if data := readWithin(conn, 2, 5*time.Second); data != "ok" {
+		t.Errorf("received %q after the error", data)
+	}
+}
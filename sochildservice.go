@@ -23,6 +23,14 @@ func (cs *soChildService) writeTimeout() time.Duration {
 	return cs.s.writeTimeout()
 }
 
+func (cs *soChildService) maxWriteBatch() int {
+	return cs.s.maxWriteBatch()
+}
+
+func (cs *soChildService) autoFlush() bool {
+	return cs.s.autoFlush()
+}
+
 func (cs *soChildService) cancel() {
 	cs.cancelFunc()
 }
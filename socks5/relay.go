@@ -0,0 +1,164 @@
+package socks5
+
+import (
+	"fmt"
+	stdnet "net"
+	"sync"
+
+	"github.com/shanpark/net"
+)
+
+// maxPending is the size of relayed data not yet written to the destination above which reading from the source is paused.
+const maxPending = 256 * 1024
+
+// peer is the other side of a relay.
+type peer interface {
+	Write(out interface{}) error
+	Stop() error
+}
+
+// relay is a net.ReadHandler and net.DisconnectHandler that replaces Server after a successful request.
+// It sends the data of the client to the peer.
+type relay struct {
+	peer peer
+	pump *pump
+}
+
+// OnRead implements net.ReadHandler interface.
+func (r *relay) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("socks5: unsupported inbound message type - %T", in)
+	}
+
+	if r.pump == nil {
+		r.pump = newPump(ctx, r.peer)
+	}
+	data := append([]byte{}, buffer.Data()...)
+	buffer.DataConsume(len(data))
+	r.pump.push(data)
+	return nil, nil
+}
+
+// OnDisconnect implements net.DisconnectHandler interface.
+func (r *relay) OnDisconnect(ctx *net.SoContext) {
+	if r.pump == nil {
+		r.peer.Stop()
+		return
+	}
+	r.pump.close()
+}
+
+// pump writes the data read from src to dst on its own goroutine, so the event loop of src never waits for dst.
+// If the event loop wrote to dst directly, it could block forever while the data from dst waits in its event queue.
+// While more than maxPending bytes are pending, reading from src is paused.
+type pump struct {
+	src  *net.SoContext
+	dst  peer
+	wake chan struct{}
+
+	lock    sync.Mutex
+	pending [][]byte
+	size    int
+	paused  bool
+	closed  bool
+}
+
+func newPump(src *net.SoContext, dst peer) *pump {
+	p := new(pump)
+	p.src = src
+	p.dst = dst
+	p.wake = make(chan struct{}, 1)
+	go p.run()
+	return p
+}
+
+// push queues data to be written to dst. It doesn't block.
+func (p *pump) push(data []byte) {
+	p.lock.Lock()
+	p.pending = append(p.pending, data)
+	p.size += len(data)
+	if !p.paused && p.size > maxPending {
+		p.paused = true
+		p.src.PauseRead()
+	}
+	p.lock.Unlock()
+	p.signal()
+}
+
+// close stops the pump and dst after all pending data is written.
+func (p *pump) close() {
+	p.lock.Lock()
+	p.closed = true
+	p.lock.Unlock()
+	p.signal()
+}
+
+func (p *pump) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pump) run() {
+	defer p.dst.Stop()
+
+	for range p.wake {
+		p.lock.Lock()
+		pending, closed := p.pending, p.closed
+		p.pending = nil
+		p.lock.Unlock()
+
+		for _, data := range pending {
+			if err := p.dst.Write(data); err != nil {
+				p.src.FlushAndClose()
+				return
+			}
+
+			p.lock.Lock()
+			p.size -= len(data)
+			if p.paused && p.size <= maxPending/2 {
+				p.paused = false
+				p.src.ResumeRead()
+			}
+			p.lock.Unlock()
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// connPeer is the outbound connection of the CONNECT command or the incoming connection of the BIND command.
+type connPeer struct {
+	conn stdnet.Conn
+}
+
+func (p *connPeer) Write(out interface{}) error {
+	_, err := p.conn.Write(out.([]byte))
+	return err
+}
+
+func (p *connPeer) Stop() error {
+	return p.conn.Close()
+}
+
+// copyToContext sends the data of conn to the client until conn is closed.
+func copyToContext(ctx *net.SoContext, conn stdnet.Conn) {
+	defer ctx.FlushAndClose()
+
+	for {
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if ctx.Write(buf[:n]) != nil {
+				conn.Close()
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
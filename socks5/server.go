@@ -0,0 +1,322 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	"syscall"
+	"time"
+
+	"github.com/shanpark/net"
+)
+
+const (
+	phaseGreeting = iota
+	phaseAuth
+	phaseRequest
+	phaseDone
+)
+
+// Server is a net.ReadHandler and net.DisconnectHandler that serves SOCKS5 on a TCPServer or TLSServer.
+// Server should be placed on the stream directly.
+//
+// After a successful request, Server replaces itself in the pipeline of the connection with a handler that relays data,
+// so the handlers after Server are not called for the relayed data.
+// The outbound connection of the CONNECT command blocks the connection of the client while it is being made.
+// The BIND and UDP ASSOCIATE commands are not supported unless they are enabled by SetBind() and SetUDPAssociate().
+type Server struct {
+	authenticate func(ctx *net.SoContext, username string, password string) bool
+	allow        func(ctx *net.SoContext, command byte, address string) bool
+	bind         bool
+	udpAssociate bool
+	bindTimeout  time.Duration
+}
+
+type serverState struct {
+	phase    int
+	listener io.Closer // listener of the BIND command waiting for the incoming connection
+}
+
+// NewServer returns a Server that requires no authentication and allows all CONNECT requests.
+func NewServer() *Server {
+	return new(Server)
+}
+
+// SetAuthenticator sets the function that authenticates a client with username and password.
+// If it is set, clients must use the username/password method. Otherwise clients must use no authentication.
+func (s *Server) SetAuthenticator(authenticate func(ctx *net.SoContext, username string, password string) bool) {
+	s.authenticate = authenticate
+}
+
+// SetRule sets the function that decides whether a request is allowed.
+// The address is the destination address of the request in the form "host:port".
+// For UDP ASSOCIATE, it is also called for the destination of each datagram.
+func (s *Server) SetRule(allow func(ctx *net.SoContext, command byte, address string) bool) {
+	s.allow = allow
+}
+
+// SetBind sets whether the BIND command is supported.
+func (s *Server) SetBind(enable bool) {
+	s.bind = enable
+}
+
+// SetBindTimeout sets the time to wait for the incoming connection of the BIND command. Zero means no timeout.
+func (s *Server) SetBindTimeout(timeout time.Duration) {
+	s.bindTimeout = timeout
+}
+
+// SetUDPAssociate sets whether the UDP ASSOCIATE command is supported.
+func (s *Server) SetUDPAssociate(enable bool) {
+	s.udpAssociate = enable
+}
+
+// OnRead implements net.ReadHandler interface.
+func (s *Server) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("socks5: unsupported inbound message type - %T", in)
+	}
+
+	state := s.state(ctx)
+	data := buffer.Data()
+	switch state.phase {
+	case phaseGreeting:
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			break
+		}
+		if data[0] != Version {
+			ctx.Close()
+			return nil, fmt.Errorf("socks5: unsupported version %d", data[0])
+		}
+		method := s.selectMethod(data[2 : 2+int(data[1])])
+		buffer.DataConsume(2 + int(data[1]))
+
+		ctx.Write([]byte{Version, method})
+		switch method {
+		case MethodNoAcceptable:
+			ctx.FlushAndClose()
+		case MethodUserPass:
+			state.phase = phaseAuth
+		default:
+			state.phase = phaseRequest
+		}
+		return nil, nil
+
+	case phaseAuth:
+		if len(data) < 2 || len(data) < 3+int(data[1]) || len(data) < 3+int(data[1])+int(data[2+int(data[1])]) {
+			break
+		}
+		if data[0] != 1 {
+			ctx.Close()
+			return nil, fmt.Errorf("socks5: unsupported authentication version %d", data[0])
+		}
+		ulen := int(data[1])
+		plen := int(data[2+ulen])
+		username, password := string(data[2:2+ulen]), string(data[3+ulen:3+ulen+plen])
+		buffer.DataConsume(3 + ulen + plen)
+
+		if !s.authenticate(ctx, username, password) {
+			ctx.Write([]byte{1, 1})
+			ctx.FlushAndClose()
+			state.phase = phaseDone
+			return nil, nil
+		}
+		ctx.Write([]byte{1, 0})
+		state.phase = phaseRequest
+		return nil, nil
+
+	case phaseRequest:
+		if len(data) < 4 {
+			break
+		}
+		if data[0] != Version {
+			ctx.Close()
+			return nil, fmt.Errorf("socks5: unsupported version %d", data[0])
+		}
+		address, n, err := ParseAddr(data[3:])
+		if err == ErrIncomplete {
+			break
+		}
+		state.phase = phaseDone
+		if err != nil {
+			writeReply(ctx, ReplyAddrTypeNotSupported, nil)
+			ctx.FlushAndClose()
+			return nil, nil
+		}
+		command := data[1]
+		buffer.DataConsume(3 + n)
+		s.execute(ctx, command, address, state)
+		return nil, nil
+	}
+
+	// Wait for more data. In phaseDone, data sent before the result of the request is kept for the relay.
+	ctx.Rollback()
+	return nil, nil
+}
+
+// OnDisconnect implements net.DisconnectHandler interface.
+func (s *Server) OnDisconnect(ctx *net.SoContext) {
+	if state, ok := ctx.Value(s).(*serverState); ok && state.listener != nil {
+		state.listener.Close()
+	}
+}
+
+func (s *Server) state(ctx *net.SoContext) *serverState {
+	state, ok := ctx.Value(s).(*serverState)
+	if !ok {
+		state = new(serverState)
+		ctx.SetValue(s, state)
+	}
+	return state
+}
+
+func (s *Server) selectMethod(methods []byte) byte {
+	want := byte(MethodNoAuth)
+	if s.authenticate != nil {
+		want = MethodUserPass
+	}
+	for _, method := range methods {
+		if method == want {
+			return want
+		}
+	}
+	return MethodNoAcceptable
+}
+
+func (s *Server) execute(ctx *net.SoContext, command byte, address string, state *serverState) {
+	var code byte = ReplySucceeded
+	switch {
+	case command != CmdConnect && command != CmdBind && command != CmdUDPAssociate,
+		command == CmdBind && !s.bind,
+		command == CmdUDPAssociate && !s.udpAssociate:
+		code = ReplyCommandNotSupported
+	case s.allow != nil && !s.allow(ctx, command, address):
+		code = ReplyNotAllowed
+	}
+
+	if code == ReplySucceeded {
+		var err error
+		switch command {
+		case CmdConnect:
+			err = s.connect(ctx, address)
+		case CmdBind:
+			err = s.listen(ctx, state)
+		case CmdUDPAssociate:
+			err = s.associate(ctx)
+		}
+		if err == nil {
+			return
+		}
+		code = replyCode(err)
+	}
+	writeReply(ctx, code, nil)
+	ctx.FlushAndClose()
+}
+
+// connect makes the outbound connection of the CONNECT command and sends the reply.
+// The reply is written before the data of the destination is copied, so that it precedes the relayed data.
+func (s *Server) connect(ctx *net.SoContext, address string) error {
+	conn, err := stdnet.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	if err = ctx.ReplaceHandler(s, &relay{peer: &connPeer{conn}}); err != nil {
+		conn.Close()
+		return err
+	}
+	writeReply(ctx, ReplySucceeded, conn.LocalAddr())
+	go copyToContext(ctx, conn)
+	return nil
+}
+
+// listen starts the BIND command. The first reply is sent with the address of the listener,
+// and the second reply is sent when the incoming connection is accepted.
+func (s *Server) listen(ctx *net.SoContext, state *serverState) error {
+	host, _, err := stdnet.SplitHostPort(ctx.Conn().LocalAddr().String())
+	if err != nil {
+		return err
+	}
+	ln, err := stdnet.Listen("tcp", stdnet.JoinHostPort(host, "0"))
+	if err != nil {
+		return err
+	}
+	state.listener = ln
+	writeReply(ctx, ReplySucceeded, ln.Addr())
+
+	go func() {
+		defer ln.Close()
+		if s.bindTimeout > 0 {
+			ln.(*stdnet.TCPListener).SetDeadline(time.Now().Add(s.bindTimeout))
+		}
+		conn, err := ln.Accept()
+		if err != nil {
+			writeReply(ctx, replyCode(err), nil)
+			ctx.FlushAndClose()
+			return
+		}
+
+		writeReply(ctx, ReplySucceeded, conn.RemoteAddr())
+		if err = ctx.ReplaceHandler(s, &relay{peer: &connPeer{conn}}); err != nil {
+			conn.Close()
+			ctx.Close()
+			return
+		}
+		go copyToContext(ctx, conn)
+	}()
+	return nil
+}
+
+// associate starts the UDP ASSOCIATE command. The association lasts until the connection of ctx is closed.
+func (s *Server) associate(ctx *net.SoContext) error {
+	host, _, err := stdnet.SplitHostPort(ctx.Conn().LocalAddr().String())
+	if err != nil {
+		return err
+	}
+	conn, err := stdnet.ListenPacket("udp", stdnet.JoinHostPort(host, "0"))
+	if err != nil {
+		return err
+	}
+
+	assoc := &association{server: s, conn: conn}
+	if addr, ok := ctx.Conn().RemoteAddr().(*stdnet.TCPAddr); ok {
+		assoc.clientIP = addr.IP
+	}
+	if err = ctx.ReplaceHandler(s, assoc); err != nil {
+		conn.Close()
+		return err
+	}
+	writeReply(ctx, ReplySucceeded, conn.LocalAddr())
+	go assoc.serve(ctx)
+	return nil
+}
+
+// writeReply writes a reply with the bound address addr. If addr is nil, 0.0.0.0:0 is used.
+func writeReply(ctx *net.SoContext, code byte, addr stdnet.Addr) error {
+	reply := []byte{Version, code, 0}
+	bound := "0.0.0.0:0"
+	if addr != nil {
+		bound = addr.String()
+	}
+	reply, err := AppendAddr(reply, bound)
+	if err != nil {
+		reply, _ = AppendAddr([]byte{Version, code, 0}, "0.0.0.0:0")
+	}
+	return ctx.Write(reply)
+}
+
+func replyCode(err error) byte {
+	var dnsErr *stdnet.DNSError
+	var netErr stdnet.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ReplyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return ReplyHostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ReplyTTLExpired
+	}
+	return ReplyGeneralFailure
+}
@@ -0,0 +1,122 @@
+// Package socks5 implements a SOCKS version 5 server (RFC 1928) with username/password authentication (RFC 1929)
+// for the pipeline of package net.
+// Server negotiates the method, authenticates the client and executes the CONNECT command.
+// BIND and UDP ASSOCIATE commands can be enabled optionally.
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	stdnet "net"
+	"strconv"
+)
+
+// Version is the protocol version of SOCKS5.
+const Version = 5
+
+// Authentication methods.
+const (
+	MethodNoAuth       = 0x00
+	MethodGSSAPI       = 0x01
+	MethodUserPass     = 0x02
+	MethodNoAcceptable = 0xff
+)
+
+// Commands of a request.
+const (
+	CmdConnect      = 0x01
+	CmdBind         = 0x02
+	CmdUDPAssociate = 0x03
+)
+
+// Address types.
+const (
+	AddrIPv4   = 0x01
+	AddrDomain = 0x03
+	AddrIPv6   = 0x04
+)
+
+// Reply codes.
+const (
+	ReplySucceeded            = 0x00
+	ReplyGeneralFailure       = 0x01
+	ReplyNotAllowed           = 0x02
+	ReplyNetworkUnreachable   = 0x03
+	ReplyHostUnreachable      = 0x04
+	ReplyConnectionRefused    = 0x05
+	ReplyTTLExpired           = 0x06
+	ReplyCommandNotSupported  = 0x07
+	ReplyAddrTypeNotSupported = 0x08
+)
+
+// ErrIncomplete is returned when data ends in the middle of a message.
+var ErrIncomplete = errors.New("socks5: incomplete message")
+
+// ErrAddrType is returned when the address type of a message is not supported.
+var ErrAddrType = errors.New("socks5: address type not supported")
+
+// AppendAddr appends the encoding of address to dst. The address has the form "host:port".
+// The host is encoded as an IPv4 or IPv6 address if it is an IP address, otherwise as a domain name.
+func AppendAddr(dst []byte, address string) ([]byte, error) {
+	host, port, err := stdnet.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: invalid port %q", port)
+	}
+
+	if ip := stdnet.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			dst = append(dst, AddrIPv4)
+			dst = append(dst, ip4...)
+		} else {
+			dst = append(dst, AddrIPv6)
+			dst = append(dst, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("socks5: invalid host %q", host)
+		}
+		dst = append(dst, AddrDomain, byte(len(host)))
+		dst = append(dst, host...)
+	}
+	return append(dst, byte(p>>8), byte(p)), nil
+}
+
+// ParseAddr parses an address at the start of data and returns the address in the form "host:port" and its size.
+func ParseAddr(data []byte) (string, int, error) {
+	if len(data) < 1 {
+		return "", 0, ErrIncomplete
+	}
+
+	var host string
+	var size int
+	switch data[0] {
+	case AddrIPv4, AddrIPv6:
+		ipSize := stdnet.IPv4len
+		if data[0] == AddrIPv6 {
+			ipSize = stdnet.IPv6len
+		}
+		size = 1 + ipSize + 2
+		if len(data) < size {
+			return "", 0, ErrIncomplete
+		}
+		host = stdnet.IP(data[1 : 1+ipSize]).String()
+	case AddrDomain:
+		if len(data) < 2 {
+			return "", 0, ErrIncomplete
+		}
+		size = 2 + int(data[1]) + 2
+		if len(data) < size {
+			return "", 0, ErrIncomplete
+		}
+		host = string(data[2 : 2+int(data[1])])
+	default:
+		return "", 0, ErrAddrType
+	}
+
+	port := int(data[size-2])<<8 | int(data[size-1])
+	return stdnet.JoinHostPort(host, strconv.Itoa(port)), size, nil
+}
@@ -0,0 +1,222 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	stdnet "net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shanpark/net"
+	"github.com/shanpark/net/memnet"
+)
+
+func TestAddr(t *testing.T) {
+	tests := []struct {
+		address string
+		want    []byte
+	}{
+		{"10.0.0.1:80", []byte{AddrIPv4, 10, 0, 0, 1, 0, 80}},
+		{"[::1]:443", []byte{AddrIPv6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 187}},
+		{"example.com:8080", append(append([]byte{AddrDomain, 11}, "example.com"...), 0x1f, 0x90)},
+	}
+	for _, test := range tests {
+		data, err := AppendAddr(nil, test.address)
+		if err != nil || !bytes.Equal(data, test.want) {
+			t.Errorf("AppendAddr(%q) = % x, %v", test.address, data, err)
+		}
+		address, n, err := ParseAddr(append(data, 0xff))
+		if err != nil || address != test.address || n != len(data) {
+			t.Errorf("ParseAddr(% x) = %q, %d, %v", data, address, n, err)
+		}
+		if _, _, err = ParseAddr(data[:len(data)-1]); err != ErrIncomplete {
+			t.Errorf("ParseAddr() of incomplete address error = %v", err)
+		}
+	}
+	if _, _, err := ParseAddr([]byte{0x02, 0, 0}); err != ErrAddrType {
+		t.Errorf("ParseAddr() of unknown type error = %v", err)
+	}
+}
+
+// startServer starts a TCPServer with socks on a free port of the loopback interface.
+func startServer(t *testing.T, socks *Server) *net.TCPServer {
+	server := net.NewTCPServer()
+	server.SetAddress("127.0.0.1:0")
+	server.AddHandler(socks)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server
+}
+
+// startEcho starts a TCP echo server on a free port of the loopback interface.
+func startEcho(t *testing.T) stdnet.Listener {
+	echo, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return echo
+}
+
+// connect sends a CONNECT request without authentication to the SOCKS5 server and reads the reply.
+func connect(t *testing.T, server *net.TCPServer, address string) stdnet.Conn {
+	conn, err := stdnet.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	request, _ := AppendAddr([]byte{Version, 1, MethodNoAuth, Version, CmdConnect, 0}, address)
+	conn.Write(request)
+	reply := make([]byte, 2+10)
+	if _, err = io.ReadFull(conn, reply); err != nil || reply[3] != ReplySucceeded {
+		t.Fatalf("reply = % x, %v", reply, err)
+	}
+	return conn
+}
+
+func TestConnect(t *testing.T) {
+	echo := startEcho(t)
+	socks := NewServer()
+	socks.SetAuthenticator(func(ctx *net.SoContext, username string, password string) bool {
+		return username == "user" && password == "pass"
+	})
+	server := startServer(t, socks)
+
+	conn, err := stdnet.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request, _ := AppendAddr([]byte{Version, CmdConnect, 0}, echo.Addr().String())
+	conn.Write([]byte{Version, 1, MethodUserPass})
+	conn.Write([]byte{1, 4, 'u', 's', 'e', 'r', 4, 'p', 'a', 's', 's'})
+	conn.Write(append(request, "hello"...))
+
+	want := []byte{Version, MethodUserPass, 1, 0, Version, ReplySucceeded, 0, AddrIPv4, 127, 0, 0, 1}
+	got := make([]byte, len(want)+2+5)
+	if _, err = io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:len(want)], want) || string(got[len(want)+2:]) != "hello" {
+		t.Errorf("response = % x", got)
+	}
+}
+
+// TestRelayFullDuplex verifies that the relay doesn't stall when both directions are busy at the same time.
+func TestRelayFullDuplex(t *testing.T) {
+	const size = 64 * 1024 * 1024
+	conn := connect(t, startServer(t, NewServer()), startEcho(t).Addr().String())
+	conn.SetDeadline(time.Now().Add(60 * time.Second))
+
+	sent := make([]byte, size)
+	for i := range sent {
+		sent[i] = byte(i * 7 / 5)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(sent)
+		errc <- err
+	}()
+
+	received := make([]byte, size)
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, sent) {
+		t.Error("relayed data is corrupted")
+	}
+}
+
+func TestDialer(t *testing.T) {
+	target, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err == nil {
+			conn.Write([]byte("welcome"))
+			conn.Close()
+		}
+	}()
+
+	socks := NewServer()
+	socks.SetAuthenticator(func(ctx *net.SoContext, username string, password string) bool {
+		return username == "user" && password == "pass"
+	})
+	proxy := memnet.NewListener("proxy")
+	server := net.NewTCPServer()
+	server.SetListener(proxy)
+	server.AddHandler(socks)
+	if err = server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	dialer := net.NewSOCKS5Dialer("proxy:1080")
+	dialer.SetForward(proxy)
+	dialer.SetAuth("user", "pass")
+	conn, err := dialer.DialContext(context.Background(), "tcp", target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if data, err := io.ReadAll(conn); err != nil || string(data) != "welcome" {
+		t.Errorf("ReadAll() = %q, %v", data, err)
+	}
+
+	dialer.SetAuth("user", "wrong")
+	if _, err = dialer.DialContext(context.Background(), "tcp", target.Addr().String()); err == nil {
+		t.Error("DialContext() succeeded with wrong password")
+	}
+}
+
+// TestDialerAuthReply verifies that the reply of the username/password authentication is validated.
+func TestDialerAuthReply(t *testing.T) {
+	proxy := memnet.NewListener("proxy")
+	defer proxy.Close()
+	go func() {
+		conn, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.ReadFull(conn, make([]byte, 3))
+		conn.Write([]byte{Version, MethodUserPass})
+		io.ReadFull(conn, make([]byte, 3+len("user")+len("pass")))
+		conn.Write([]byte{Version, 0}) // the version of RFC 1929 is 1.
+	}()
+
+	dialer := net.NewSOCKS5Dialer("proxy:1080")
+	dialer.SetForward(proxy)
+	dialer.SetAuth("user", "pass")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := dialer.DialContext(ctx, "tcp", "127.0.0.1:80"); err == nil || !strings.Contains(err.Error(), "authentication version") {
+		t.Errorf("DialContext() = %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package socks5
+
+import (
+	"fmt"
+	stdnet "net"
+
+	"github.com/shanpark/net"
+)
+
+// association is a net.ReadHandler and net.DisconnectHandler that replaces Server after a UDP ASSOCIATE request.
+// It relays datagrams between the client and destinations while the connection of the request is open.
+type association struct {
+	server   *Server
+	conn     stdnet.PacketConn
+	clientIP stdnet.IP
+}
+
+// OnRead implements net.ReadHandler interface. Data on the connection of the request is discarded.
+func (a *association) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("socks5: unsupported inbound message type - %T", in)
+	}
+	buffer.DataConsume(buffer.Readable())
+	return nil, nil
+}
+
+// OnDisconnect implements net.DisconnectHandler interface.
+func (a *association) OnDisconnect(ctx *net.SoContext) {
+	a.conn.Close()
+}
+
+// serve relays datagrams until the association is closed.
+// The first datagram from the IP address of the client decides the address of the client.
+// Fragmented datagrams are not supported and dropped.
+func (a *association) serve(ctx *net.SoContext) {
+	var client stdnet.Addr
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if client == nil && a.isClient(from) {
+			client = from
+		}
+		if client != nil && from.String() == client.String() {
+			a.forward(ctx, buf[:n])
+		} else if client != nil {
+			reply := []byte{0, 0, 0}
+			if reply, err = AppendAddr(reply, from.String()); err == nil {
+				a.conn.WriteTo(append(reply, buf[:n]...), client)
+			}
+		}
+	}
+}
+
+// forward sends a datagram of the client to its destination.
+func (a *association) forward(ctx *net.SoContext, datagram []byte) {
+	if len(datagram) < 4 || datagram[2] != 0 {
+		return
+	}
+	address, n, err := ParseAddr(datagram[3:])
+	if err != nil {
+		return
+	}
+	if a.server.allow != nil && !a.server.allow(ctx, CmdUDPAssociate, address) {
+		return
+	}
+	dst, err := stdnet.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return
+	}
+	a.conn.WriteTo(datagram[3+n:], dst)
+}
+
+func (a *association) isClient(addr stdnet.Addr) bool {
+	udpAddr, ok := addr.(*stdnet.UDPAddr)
+	return ok && (a.clientIP == nil || udpAddr.IP.Equal(a.clientIP))
+}
@@ -3,6 +3,8 @@ package net
 import (
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,9 +12,13 @@ const (
 	eventNone = iota
 	eventRead
 	eventWrite
+	eventFlush
+	eventClose
+	eventEOF
 )
 
 const defaultQueueSize = 32
+const defaultMaxWriteBatch = 64 * 1024
 
 type event struct {
 	id    int
@@ -27,6 +33,33 @@ type SoContext struct {
 	eventQueue chan event
 	buffer     *Buffer
 	rollback   bool
+	eof        bool
+
+	outbound     net.Buffers // written but not yet flushed data
+	outboundSize int
+
+	values     map[interface{}]interface{} // per connection values of handlers
+	valuesLock sync.Mutex
+
+	pl atomic.Value // *pipeline of this connection. It is the pipeline of the service until modified.
+
+	localAddr  net.Addr // overrides the local address of conn if not nil
+	remoteAddr net.Addr // overrides the remote address of conn if not nil
+
+	resumeLock sync.Mutex
+	resume     chan struct{} // closed when reading is resumed. nil if reading is not paused.
+
+	driver eventDriver // *loopDriver for a connection
+}
+
+// eventDriver handles the events requested by handlers and the results of the handler chains of a context.
+// loopDriver drives a connection with the event loop of process(), and EmbeddedPipeline drives a context synchronously.
+type eventDriver interface {
+	queueEvent(evt event) error // queues evt to be handled in order. It returns ErrClosed if the context is closed.
+	inbound(msg interface{})    // receives a message that passed through the end of the ReadHandler chain.
+	outbound(msg interface{})   // receives a message that passed through the end of the WriteHandler chain.
+	rolledBack()                // is called when a ReadHandler requests rollback.
+	failed(err error)           // is called with an error before the ErrorHandler chain.
 }
 
 // Conn returns an underlying net.Conn
@@ -34,6 +67,44 @@ func (nctx *SoContext) Conn() net.Conn {
 	return nctx.conn
 }
 
+// LocalAddr returns the local address of the session.
+// It is the local address of the underlying connection unless it is overridden by SetAddr().
+func (nctx *SoContext) LocalAddr() net.Addr {
+	nctx.valuesLock.Lock()
+	defer nctx.valuesLock.Unlock()
+
+	if nctx.localAddr != nil {
+		return nctx.localAddr
+	}
+	return nctx.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the session.
+// It is the remote address of the underlying connection unless it is overridden by SetAddr().
+func (nctx *SoContext) RemoteAddr() net.Addr {
+	nctx.valuesLock.Lock()
+	defer nctx.valuesLock.Unlock()
+
+	if nctx.remoteAddr != nil {
+		return nctx.remoteAddr
+	}
+	return nctx.conn.RemoteAddr()
+}
+
+// SetAddr overrides the local and remote addresses of the session. A nil address is not overridden.
+// It is used by handlers that learn the original addresses of a proxied connection, such as a PROXY protocol decoder.
+func (nctx *SoContext) SetAddr(local net.Addr, remote net.Addr) {
+	nctx.valuesLock.Lock()
+	defer nctx.valuesLock.Unlock()
+
+	if local != nil {
+		nctx.localAddr = local
+	}
+	if remote != nil {
+		nctx.remoteAddr = remote
+	}
+}
+
 // Rollback requests that the status of the read operation be rolled back to its last commit state.
 func (nctx *SoContext) Rollback() {
 	nctx.rollback = true
@@ -44,6 +115,33 @@ func (nctx *SoContext) IsRollback() bool {
 	return nctx.rollback
 }
 
+// IsEOF returns whether the peer has closed the connection. It is true while EOFHandlers are called.
+func (nctx *SoContext) IsEOF() bool {
+	return nctx.eof
+}
+
+// PauseRead stops reading from the connection until ResumeRead() is called, so the peer is throttled by flow control.
+// Data that is already being read is still passed to the ReadHandler chain. It can be called from any goroutine.
+func (nctx *SoContext) PauseRead() {
+	nctx.resumeLock.Lock()
+	defer nctx.resumeLock.Unlock()
+
+	if nctx.resume == nil {
+		nctx.resume = make(chan struct{})
+	}
+}
+
+// ResumeRead resumes reading from the connection paused by PauseRead().
+func (nctx *SoContext) ResumeRead() {
+	nctx.resumeLock.Lock()
+	defer nctx.resumeLock.Unlock()
+
+	if nctx.resume != nil {
+		close(nctx.resume)
+		nctx.resume = nil
+	}
+}
+
 // Commit commits the current state of the read operation.
 func (nctx *SoContext) Commit() {
 	nctx.rollback = false
@@ -51,25 +149,97 @@ func (nctx *SoContext) Commit() {
 }
 
 // Write writes parameter out to the peer. This causes the WriteHandler chain to be called.
+// The last WriteHandler should produce one of *Buffer, []byte, string, net.Buffers, *FileRegion, io.WriterTo or io.Reader.
+// out is handled later by the event loop of the context, so it should not be modified after this call.
+// The bytes of the result of the chain are copied and queued, so the result can be a buffer that is reused later,
+// such as the read buffer of the context. Queued data is sent to the peer when the context is flushed.
+// If auto flush is enabled on the service (the default), queued data is flushed as soon as no more events are pending.
+// If the connection is already closed, it returns ErrClosed.
 func (nctx *SoContext) Write(out interface{}) error {
-	nctx.eventQueue <- event{eventWrite, out}
+	return nctx.queueEvent(event{eventWrite, out})
+}
+
+// Flush requests that all queued outbound data be sent to the peer.
+// Consecutive writes queued before a flush are sent together with a single vectored write.
+func (nctx *SoContext) Flush() error {
+	return nctx.queueEvent(event{eventFlush, nil})
+}
+
+// WriteAndFlush writes parameter out to the peer and flushes the context immediately.
+func (nctx *SoContext) WriteAndFlush(out interface{}) error {
+	if err := nctx.Write(out); err != nil {
+		return err
+	}
+	return nctx.Flush()
+}
+
+// SetValue associates value with key in the context. Handlers can keep per connection states with it.
+// To avoid collisions, key should be a value of unexported type or the handler itself.
+func (nctx *SoContext) SetValue(key interface{}, value interface{}) {
+	nctx.valuesLock.Lock()
+	defer nctx.valuesLock.Unlock()
+
+	if nctx.values == nil {
+		nctx.values = make(map[interface{}]interface{})
+	}
+	nctx.values[key] = value
+}
+
+// Value returns the value associated with key in the context, or nil if no value is associated with key.
+func (nctx *SoContext) Value(key interface{}) interface{} {
+	nctx.valuesLock.Lock()
+	defer nctx.valuesLock.Unlock()
+
+	return nctx.values[key]
+}
+
+// ReplaceHandler replaces handler old with handlers in the pipeline of this connection only.
+// handlers take the position of old, and the pipelines of the other connections are not affected.
+// It is usually called by a handler to replace itself, for example, after a protocol upgrade.
+// The change takes effect from the next message, so the rest of the current chain is still called.
+func (nctx *SoContext) ReplaceHandler(old interface{}, handlers ...interface{}) error {
+	pl, err := nctx.pipeline().replaceHandler(old, handlers...)
+	if err != nil {
+		return err
+	}
+	nctx.pl.Store(pl)
 	return nil
 }
 
+// RemoveHandler removes handler from the pipeline of this connection only.
+func (nctx *SoContext) RemoveHandler(handler interface{}) error {
+	return nctx.ReplaceHandler(handler)
+}
+
 // Close requests context to close the connection of the context.
 func (nctx *SoContext) Close() {
 	nctx.svc.cancel()
 }
 
+// FlushAndClose requests context to close the connection after all data written before this call is sent to the peer.
+func (nctx *SoContext) FlushAndClose() {
+	nctx.queueEvent(event{eventClose, nil})
+}
+
 func newContext(svc soObject, conn net.Conn, queueSize int) *SoContext {
 	nctx := new(SoContext)
 	nctx.svc = svc
 	nctx.conn = conn
 	nctx.eventQueue = make(chan event, queueSize)
 	nctx.buffer = NewBuffer(4096)
+	nctx.pl.Store(svc.pipeline())
+	nctx.driver = &loopDriver{nctx}
 	return nctx
 }
 
+func (nctx *SoContext) queueEvent(evt event) error {
+	return nctx.driver.queueEvent(evt)
+}
+
+func (nctx *SoContext) pipeline() *pipeline {
+	return nctx.pl.Load().(*pipeline)
+}
+
 func (nctx *SoContext) process() {
 	defer nctx.conn.Close()
 	defer nctx.handleDisconnect()
@@ -85,19 +255,47 @@ func (nctx *SoContext) process() {
 		case <-nctx.svc.done():
 			return
 		case evt := <-nctx.eventQueue:
-			switch evt.id {
-			case eventRead:
-				nctx.handleRead()
-			case eventWrite:
-				nctx.handleWrite(evt.param)
+			if !nctx.handleEvent(evt) {
+				return
+			}
+			if nctx.svc.autoFlush() && (len(nctx.eventQueue) == 0) {
+				nctx.handleFlush()
 			}
 		}
 	}
 }
 
+// handleEvent handles an event of the event queue. It returns false if the connection is closed by the event.
+func (nctx *SoContext) handleEvent(evt event) bool {
+	switch evt.id {
+	case eventRead:
+		nctx.handleRead()
+	case eventWrite:
+		nctx.handleWrite(evt.param)
+	case eventFlush:
+		nctx.handleFlush()
+	case eventClose:
+		nctx.handleFlush()
+		nctx.Close()
+		return false
+	case eventEOF:
+		nctx.eof = true
+		if !nctx.handleEOF() {
+			nctx.Close()
+			return false
+		}
+		nctx.FlushAndClose()
+	}
+	return true
+}
+
 func (nctx *SoContext) readLoop() {
 	readBuf := make([]byte, 4096)
 	for {
+		if !nctx.waitResume() {
+			return
+		}
+
 		select {
 		case <-nctx.svc.done():
 			return
@@ -112,7 +310,11 @@ func (nctx *SoContext) readLoop() {
 				case <-nctx.svc.done():
 				default:
 					if err == io.EOF {
-						nctx.Close()
+						select {
+						case nctx.eventQueue <- event{eventEOF, nil}:
+						case <-nctx.svc.done():
+						}
+						return
 					} else {
 						nerr, ok := err.(net.Error)
 						if ok {
@@ -128,6 +330,7 @@ func (nctx *SoContext) readLoop() {
 				}
 				continue
 			}
+			nctx.handleTraffic(true, readBuf[:n])
 			nctx.buffer.Write(readBuf[:n])
 
 			nctx.eventQueue <- event{eventRead, nil}
@@ -135,13 +338,30 @@ func (nctx *SoContext) readLoop() {
 	}
 }
 
+// waitResume blocks while reading is paused. It returns false if the connection is closed.
+func (nctx *SoContext) waitResume() bool {
+	nctx.resumeLock.Lock()
+	resume := nctx.resume
+	nctx.resumeLock.Unlock()
+
+	if resume == nil {
+		return true
+	}
+	select {
+	case <-resume:
+		return true
+	case <-nctx.svc.done():
+		return false
+	}
+}
+
 func (nctx *SoContext) prepareRead() {
 	nctx.rollback = false
 	nctx.buffer.Reserve(4096)
 }
 
 func (nctx *SoContext) handleConnect() bool {
-	for _, handler := range nctx.svc.pipeline().connectHandlers {
+	for _, handler := range nctx.pipeline().connectHandlers {
 		if nctx.svc.isRunning() {
 			if err := handler.OnConnect(nctx); err != nil {
 				nctx.handleError(err)
@@ -155,31 +375,16 @@ func (nctx *SoContext) handleConnect() bool {
 }
 
 func (nctx *SoContext) handleDisconnect() {
-	for _, handler := range nctx.svc.pipeline().disconnectHandlers {
+	for _, handler := range nctx.pipeline().disconnectHandlers {
 		handler.OnDisconnect(nctx)
 	}
 }
 
 func (nctx *SoContext) handleRead() {
-ReadLoop:
 	for {
-		var err error
-		var out interface{} = nctx.buffer
 		var remain = nctx.buffer.Readable()
-		for _, handler := range nctx.svc.pipeline().readHandlers {
-			if nctx.svc.isRunning() {
-				out, err = handler.OnRead(nctx, out)
-				if nctx.IsRollback() || (err != nil) {
-					if nctx.IsRollback() {
-						nctx.buffer.Rollback()
-					}
-					if err != nil {
-						nctx.handleError(err)
-					}
-					nctx.Commit() // Commit() initialize rollback flag.
-					break ReadLoop
-				}
-			}
+		if !nctx.fireRead(nctx.buffer) {
+			break
 		}
 		nctx.Commit()
 		if (nctx.buffer.Readable() == 0) || (nctx.buffer.Readable() == remain) {
@@ -188,40 +393,249 @@ ReadLoop:
 	}
 }
 
+// handleEOF calls the ReadHandlers that implement EOFHandler. It returns false if there is no such handler.
+func (nctx *SoContext) handleEOF() bool {
+	handled := false
+	handlers := nctx.pipeline().readHandlers
+	for i, handler := range handlers {
+		eofHandler, ok := handler.(EOFHandler)
+		if !ok || !nctx.svc.isRunning() {
+			continue
+		}
+		handled = true
+		out, err := eofHandler.OnEOF(nctx)
+		if err != nil {
+			nctx.handleError(err)
+			continue
+		}
+		if out != nil {
+			nctx.fireReadFrom(handlers[i+1:], out)
+		}
+	}
+	return handled
+}
+
+// fireRead calls the ReadHandler chain with in. It returns false if the chain is stopped by rollback or an error.
+func (nctx *SoContext) fireRead(in interface{}) bool {
+	return nctx.fireReadFrom(nctx.pipeline().readHandlers, in)
+}
+
+// fireReadFrom calls handlers with in. If a handler produces messages, each of them is passed to the rest of handlers.
+func (nctx *SoContext) fireReadFrom(handlers []ReadHandler, in interface{}) bool {
+	var err error
+	var out = in
+	for i, handler := range handlers {
+		if nctx.svc.isRunning() {
+			out, err = handler.OnRead(nctx, out)
+			if nctx.IsRollback() || (err != nil) {
+				if nctx.IsRollback() {
+					nctx.buffer.Rollback()
+					nctx.driver.rolledBack()
+				}
+				if err != nil {
+					nctx.handleError(err)
+				}
+				nctx.Commit() // Commit() initialize rollback flag.
+				return false
+			}
+			if out == nil { // the message is consumed.
+				return true
+			}
+			if msgs, ok := out.(messages); ok {
+				for _, msg := range msgs {
+					if !nctx.fireReadFrom(handlers[i+1:], msg) {
+						return false
+					}
+				}
+				return true
+			}
+		}
+	}
+	if out != nil {
+		nctx.driver.inbound(out)
+	}
+	return true
+}
+
 func (nctx *SoContext) handleWrite(out interface{}) {
 	var err error
-	for _, handler := range nctx.svc.pipeline().writeHandlers {
+	for _, handler := range nctx.pipeline().writeHandlers {
 		if nctx.svc.isRunning() {
 			if out, err = handler.OnWrite(nctx, out); err != nil {
 				nctx.handleError(err)
 				return
 			}
+			if out == nil { // the message is consumed.
+				return
+			}
 		}
 	}
+	nctx.driver.outbound(out)
+}
+
+// loopDriver drives a connection. Events are handled by the event loop of process().
+type loopDriver struct {
+	nctx *SoContext
+}
+
+// queueEvent queues evt to the event loop. It doesn't block on a closed connection
+// so that a handler of another connection, e.g. a relay, can write to the context safely.
+func (l *loopDriver) queueEvent(evt event) error {
+	select {
+	case l.nctx.eventQueue <- evt:
+		return nil
+	case <-l.nctx.svc.done():
+		return ErrClosed
+	}
+}
+
+// inbound drops msg because there is no handler to receive it.
+func (l *loopDriver) inbound(msg interface{}) {
+}
+
+// outbound queues the bytes of out to be sent or writes out to the connection directly.
+func (l *loopDriver) outbound(out interface{}) {
+	nctx := l.nctx
+	switch msg := out.(type) {
+	case nil: // nothing to write
+	case *Buffer:
+		nctx.queueOutbound(copyBytes(msg.Data()))
+	case []byte:
+		nctx.queueOutbound(copyBytes(msg))
+	case string:
+		nctx.queueOutbound([]byte(msg))
+	case net.Buffers:
+		for _, bytes := range msg {
+			nctx.queueOutbound(copyBytes(bytes))
+		}
+	case *FileRegion:
+		nctx.writeDirect(func() error {
+			return msg.transferTo(nctx)
+		})
+	case io.WriterTo:
+		nctx.writeDirect(func() error {
+			nctx.setWriteDeadline()
+			_, err := msg.WriteTo(nctx.directWriter())
+			return err
+		})
+	case io.Reader:
+		nctx.writeDirect(func() error {
+			nctx.setWriteDeadline()
+			_, err := io.Copy(nctx.directWriter(), msg)
+			return err
+		})
+	default:
+		nctx.handleError(&UnsupportedMessageError{out})
+	}
+}
+
+func (l *loopDriver) rolledBack() {
+}
+
+func (l *loopDriver) failed(err error) {
+}
 
-	buffer, ok := out.(*Buffer)
-	if !ok {
+// writeDirect writes data to the connection directly with write function.
+// Queued data is flushed first to keep the order of writes.
+func (nctx *SoContext) writeDirect(write func() error) {
+	err := nctx.flush()
+	if err == nil {
+		err = write()
+	}
+	if err != nil {
 		nctx.handleError(err)
+	}
+}
+
+// queueOutbound queues bytes to be written by flush(). bytes should not be modified after it is queued.
+func (nctx *SoContext) queueOutbound(bytes []byte) {
+	if len(bytes) == 0 {
 		return
 	}
-	bytes := buffer.Data()
-	written := 0
-	for written < len(bytes) {
-		if nctx.svc.writeTimeout() > 0 {
-			nctx.conn.SetWriteDeadline(time.Now().Add(nctx.svc.writeTimeout())) // set timeout
-		}
 
-		n, err := nctx.conn.Write(bytes[written:])
-		if err != nil {
-			nctx.handleError(err)
-			return
+	nctx.outbound = append(nctx.outbound, bytes)
+	nctx.outboundSize += len(bytes)
+	if nctx.outboundSize >= nctx.svc.maxWriteBatch() {
+		nctx.handleFlush()
+	}
+}
+
+// copyBytes returns a copy of bytes, so queued data is not affected by buffers reused after they are written.
+func copyBytes(bytes []byte) []byte {
+	return append([]byte(nil), bytes...)
+}
+
+func (nctx *SoContext) handleFlush() {
+	if err := nctx.flush(); err != nil {
+		nctx.handleError(err)
+	}
+}
+
+func (nctx *SoContext) flush() error {
+	if nctx.outboundSize == 0 {
+		return nil
+	}
+
+	nctx.setWriteDeadline()
+	bufs := nctx.outbound // WriteTo() consumes bufs, so keep nctx.outbound to reuse its backing array.
+	var sent net.Buffers
+	if len(nctx.pipeline().trafficHandlers) > 0 {
+		sent = append(sent, nctx.outbound...) // WriteTo() also shortens the slices of bufs.
+	}
+	n, err := bufs.WriteTo(nctx.conn)
+	if sent != nil {
+		for _, bytes := range sent {
+			if n <= 0 {
+				break
+			}
+			if int64(len(bytes)) > n {
+				bytes = bytes[:n]
+			}
+			nctx.handleTraffic(false, bytes)
+			n -= int64(len(bytes))
 		}
-		written += n
+	}
+	nctx.outbound = nctx.outbound[:0]
+	nctx.outboundSize = 0
+	return err
+}
+
+// directWriter returns the writer of the data written directly to the connection.
+// If the pipeline has TrafficHandlers, the connection is wrapped to notify them, so zero-copy transfer is not used.
+func (nctx *SoContext) directWriter() io.Writer {
+	if len(nctx.pipeline().trafficHandlers) == 0 {
+		return nctx.conn
+	}
+	return trafficWriter{nctx}
+}
+
+// trafficWriter writes data to the connection of nctx and notifies the TrafficHandlers of it.
+type trafficWriter struct {
+	nctx *SoContext
+}
+
+func (w trafficWriter) Write(p []byte) (int, error) {
+	n, err := w.nctx.conn.Write(p)
+	if n > 0 {
+		w.nctx.handleTraffic(false, p[:n])
+	}
+	return n, err
+}
+
+func (nctx *SoContext) handleTraffic(inbound bool, data []byte) {
+	for _, handler := range nctx.pipeline().trafficHandlers {
+		handler.OnTraffic(nctx, inbound, data)
+	}
+}
+
+func (nctx *SoContext) setWriteDeadline() {
+	if nctx.svc.writeTimeout() > 0 {
+		nctx.conn.SetWriteDeadline(time.Now().Add(nctx.svc.writeTimeout())) // set timeout
 	}
 }
 
 func (nctx *SoContext) handleTimeout() {
-	for _, handler := range nctx.svc.pipeline().timeoutHandlers {
+	for _, handler := range nctx.pipeline().timeoutHandlers {
 		if nctx.svc.isRunning() {
 			if err := handler.OnTimeout(nctx); err != nil {
 				nctx.handleError(err)
@@ -232,7 +646,8 @@ func (nctx *SoContext) handleTimeout() {
 }
 
 func (nctx *SoContext) handleError(err error) {
-	for _, handler := range nctx.svc.pipeline().errorHandlers {
+	nctx.driver.failed(err)
+	for _, handler := range nctx.pipeline().errorHandlers {
 		if nctx.svc.isRunning() {
 			handler.OnError(nctx, err)
 		}
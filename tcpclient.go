@@ -11,6 +11,7 @@ import (
 // A TCPClient represents a client object using tcp network.
 type TCPClient struct {
 	address    string
+	dialer     Dialer
 	cancelFunc context.CancelFunc
 	doneCh     <-chan struct{}
 
@@ -19,6 +20,8 @@ type TCPClient struct {
 	optHandler      tcpConnOptHandler
 	readTimeoutDur  time.Duration
 	writeTimeoutDur time.Duration
+	maxWriteBatchSz int
+	manualFlush     bool
 }
 
 // NewTCPClient create a new TCPClient.
@@ -35,6 +38,13 @@ func (c *TCPClient) SetAddress(address string) error {
 	return nil
 }
 
+// SetDialer sets the dialer that makes the connection, e.g. a SOCKS5Dialer to connect through a proxy.
+// If dialer is nil, the client connects to the remote address directly.
+func (c *TCPClient) SetDialer(dialer Dialer) error {
+	c.dialer = dialer
+	return nil
+}
+
 // SetTimeout sets the read and write timeout associated with the connection.
 func (c *TCPClient) SetTimeout(readTimeout time.Duration, writeTimeout time.Duration) error {
 	c.readTimeoutDur = readTimeout
@@ -42,6 +52,20 @@ func (c *TCPClient) SetTimeout(readTimeout time.Duration, writeTimeout time.Dura
 	return nil
 }
 
+// SetMaxWriteBatch sets the maximum number of bytes coalesced into a single vectored write.
+// If size is zero or negative, default value (64KB) will be used.
+func (c *TCPClient) SetMaxWriteBatch(size int) error {
+	c.maxWriteBatchSz = size
+	return nil
+}
+
+// SetAutoFlush sets whether written data is flushed automatically when no more events are pending.
+// The default is true. If autoFlush is false, data is sent only by Flush(), WriteAndFlush() or when the write batch is full.
+func (c *TCPClient) SetAutoFlush(autoFlush bool) error {
+	c.manualFlush = !autoFlush
+	return nil
+}
+
 // SetNoDelay controls whether the operating system should delay packet transmission in hopes of sending fewer packets (Nagle's algorithm).
 // The default is true (no delay), meaning that data is sent as soon as possible after a Write.
 func (c *TCPClient) SetNoDelay(noDelay bool) error {
@@ -99,9 +123,9 @@ func (c *TCPClient) Start() error {
 		return errors.New("net: client object is already started")
 	}
 
-	conn, err := net.Dial("tcp", c.address)
+	conn, err := c.dial()
 	if err != nil {
-		return fmt.Errorf("net: Dial() failed - %v", err)
+		return fmt.Errorf("net: Dial() failed - %w", err)
 	}
 
 	var cctx context.Context
@@ -137,6 +161,31 @@ func (c *TCPClient) Write(out interface{}) error {
 	return c.nctx.Write(out)
 }
 
+// Flush requests that all queued outbound data be sent to the peer.
+func (c *TCPClient) Flush() error {
+	if c.nctx == nil {
+		return errors.New("net: connection is not made")
+	}
+
+	return c.nctx.Flush()
+}
+
+// WriteAndFlush writes parameter out to the peer and flushes the connection immediately.
+func (c *TCPClient) WriteAndFlush(out interface{}) error {
+	if c.nctx == nil {
+		return errors.New("net: connection is not made")
+	}
+
+	return c.nctx.WriteAndFlush(out)
+}
+
+func (c *TCPClient) dial() (net.Conn, error) {
+	if c.dialer == nil {
+		return net.Dial("tcp", c.address)
+	}
+	return c.dialer.DialContext(context.Background(), "tcp", c.address)
+}
+
 func (c *TCPClient) pipeline() *pipeline {
 	return c.pl
 }
@@ -149,6 +198,17 @@ func (c *TCPClient) writeTimeout() time.Duration {
 	return c.writeTimeoutDur
 }
 
+func (c *TCPClient) maxWriteBatch() int {
+	if c.maxWriteBatchSz <= 0 {
+		return defaultMaxWriteBatch
+	}
+	return c.maxWriteBatchSz
+}
+
+func (c *TCPClient) autoFlush() bool {
+	return !c.manualFlush
+}
+
 func (c *TCPClient) cancel() {
 	c.cancelFunc()
 }
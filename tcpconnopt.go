@@ -15,33 +15,53 @@ type tcpConnOptHandler struct {
 }
 
 func (h tcpConnOptHandler) OnConnect(ctx *SoContext) error {
+	conn, ok := tcpConn(ctx.conn)
+	if !ok {
+		return nil // options are applied to a TCP connection only.
+	}
+
 	// set no delay option
 	if h.noDelay != nil {
-		ctx.conn.(*net.TCPConn).SetNoDelay(*h.noDelay)
+		conn.SetNoDelay(*h.noDelay)
 	}
 
 	// set keep alive option
 	if h.keepAlive != nil {
-		ctx.conn.(*net.TCPConn).SetKeepAlive(*h.keepAlive)
+		conn.SetKeepAlive(*h.keepAlive)
 		if h.keepAlivePeriod != 0 {
-			ctx.conn.(*net.TCPConn).SetKeepAlivePeriod(h.keepAlivePeriod)
+			conn.SetKeepAlivePeriod(h.keepAlivePeriod)
 		}
 	}
 
 	// set linger option
 	if h.linger != nil {
-		ctx.conn.(*net.TCPConn).SetLinger(*h.linger)
+		conn.SetLinger(*h.linger)
 	}
 
 	// set read buffer size option
 	if h.readBuffersize != nil {
-		ctx.conn.(*net.TCPConn).SetReadBuffer(*h.readBuffersize)
+		conn.SetReadBuffer(*h.readBuffersize)
 	}
 
 	// set write buffer size option
 	if h.writeBufferSize != nil {
-		ctx.conn.(*net.TCPConn).SetWriteBuffer(*h.writeBufferSize)
+		conn.SetWriteBuffer(*h.writeBufferSize)
 	}
 
 	return nil
 }
+
+// tcpConn returns the underlying *net.TCPConn of conn. A wrapping connection such as *tls.Conn
+// exposes the connection it wraps with NetConn() method.
+func tcpConn(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil, false
+		}
+	}
+}
@@ -14,13 +14,17 @@ type TCPServer struct {
 	cancelFunc context.CancelFunc
 	doneCh     <-chan struct{}
 
-	listener net.Listener // for Server
-	err      error        //
+	listener        net.Listener    // for Server
+	userListener    net.Listener    // listener set by SetListener()
+	listenerFactory ListenerFactory // factory set by SetListenerFactory()
+	err             error           //
 
 	pl              *pipeline         // for childService
 	optHandler      tcpConnOptHandler //
 	readTimeoutDur  time.Duration     //
 	writeTimeoutDur time.Duration     //
+	maxWriteBatchSz int               //
+	manualFlush     bool              //
 }
 
 // NewTCPServer create a new TCPServer.
@@ -37,6 +41,21 @@ func (s *TCPServer) SetAddress(address string) error {
 	return nil
 }
 
+// SetListener sets the listener that accepts connections instead of listening on the address,
+// e.g. a listener inherited by socket activation or wrapped by a third-party library.
+// The listener is closed when the server stops, so it should be set again before the server restarts.
+func (s *TCPServer) SetListener(listener net.Listener) error {
+	s.userListener = listener
+	return nil
+}
+
+// SetListenerFactory sets the factory that creates the listener on the address.
+// *net.ListenConfig satisfies ListenerFactory, so socket options can be set with its Control function.
+func (s *TCPServer) SetListenerFactory(factory ListenerFactory) error {
+	s.listenerFactory = factory
+	return nil
+}
+
 // SetTimeout sets the read and write timeout associated with the connection.
 func (s *TCPServer) SetTimeout(readTimeout time.Duration, writeTimeout time.Duration) error {
 	s.readTimeoutDur = readTimeout
@@ -44,6 +63,20 @@ func (s *TCPServer) SetTimeout(readTimeout time.Duration, writeTimeout time.Dura
 	return nil
 }
 
+// SetMaxWriteBatch sets the maximum number of bytes coalesced into a single vectored write.
+// If size is zero or negative, default value (64KB) will be used.
+func (s *TCPServer) SetMaxWriteBatch(size int) error {
+	s.maxWriteBatchSz = size
+	return nil
+}
+
+// SetAutoFlush sets whether written data is flushed automatically when no more events are pending.
+// The default is true. If autoFlush is false, data is sent only by Flush(), WriteAndFlush() or when the write batch is full.
+func (s *TCPServer) SetAutoFlush(autoFlush bool) error {
+	s.manualFlush = !autoFlush
+	return nil
+}
+
 // SetNoDelay controls whether the operating system should delay packet transmission in hopes of sending fewer packets (Nagle's algorithm).
 // The default is true (no delay), meaning that data is sent as soon as possible after a Write.
 func (s *TCPServer) SetNoDelay(noDelay bool) error {
@@ -105,7 +138,7 @@ func (s *TCPServer) Start() error {
 
 	s.cctx, s.cancelFunc = context.WithCancel(context.Background())
 	s.doneCh = s.cctx.Done()
-	s.listener, err = net.Listen("tcp", s.address)
+	s.listener, err = s.listen()
 	if err != nil {
 		return err
 	}
@@ -117,7 +150,6 @@ func (s *TCPServer) Start() error {
 // Stop stops the service. TCPServer closes all connections
 func (s *TCPServer) Stop() error {
 	if s.isRunning() {
-		s.cctx = nil
 		s.cancelFunc()
 	}
 
@@ -129,12 +161,32 @@ func (s *TCPServer) WaitForDone() {
 	<-s.doneCh
 }
 
+// Addr returns the address of the listener. It returns nil if the server is not started.
+func (s *TCPServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
 // Error returns an error that makes service stop.
 // For normal stop, returns nil.
 func (s *TCPServer) Error() error {
 	return s.err
 }
 
+func (s *TCPServer) listen() (net.Listener, error) {
+	if s.userListener != nil {
+		listener := s.userListener
+		s.userListener = nil
+		return listener, nil
+	}
+	if s.listenerFactory != nil {
+		return s.listenerFactory.Listen(context.Background(), "tcp", s.address)
+	}
+	return net.Listen("tcp", s.address)
+}
+
 func (s *TCPServer) pipeline() *pipeline {
 	return s.pl
 }
@@ -147,6 +199,17 @@ func (s *TCPServer) writeTimeout() time.Duration {
 	return s.writeTimeoutDur
 }
 
+func (s *TCPServer) maxWriteBatch() int {
+	if s.maxWriteBatchSz <= 0 {
+		return defaultMaxWriteBatch
+	}
+	return s.maxWriteBatchSz
+}
+
+func (s *TCPServer) autoFlush() bool {
+	return !s.manualFlush
+}
+
 func (s *TCPServer) process(acceptor soAcceptor) {
 	defer s.listener.Close()
 
@@ -168,7 +231,7 @@ func (s *TCPServer) acceptLoop() {
 				case <-s.doneCh:
 				default:
 					switch {
-					case err.(net.Error).Temporary() || err.(net.Error).Timeout():
+					case isTemporary(err):
 						continue
 					default:
 						s.err = err
@@ -205,3 +268,9 @@ func (s *TCPServer) isRunning() bool {
 		return true
 	}
 }
+
+// isTemporary reports whether err of Accept() is temporary, so the server can continue accepting.
+func isTemporary(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && (nerr.Temporary() || nerr.Timeout())
+}
@@ -29,12 +29,23 @@ func (c *TLSClient) Start() error {
 		return errors.New("net: tls client object is already started")
 	}
 
-	conn, err := net.Dial("tcp", c.address)
+	conn, err := c.dial()
 	if err != nil {
-		return fmt.Errorf("net: Dial() failed - %v", err)
+		return fmt.Errorf("net: Dial() failed - %w", err)
 	}
 
-	conn = tls.Client(conn, c.config) // only difference from TCP
+	config := c.config
+	if config == nil || config.ServerName == "" { // verify the certificate with the host of the remote address, not the proxy.
+		if host, _, err := net.SplitHostPort(c.address); err == nil {
+			if config == nil {
+				config = new(tls.Config)
+			} else {
+				config = config.Clone()
+			}
+			config.ServerName = host
+		}
+	}
+	conn = tls.Client(conn, config) // the handshake runs over the connection made by the dialer.
 
 	var cctx context.Context
 	cctx, c.cancelFunc = context.WithCancel(context.Background())
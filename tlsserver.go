@@ -4,13 +4,16 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
-	"net"
+	"time"
 )
 
 // A TLSServer represents a server object using tls network.
 type TLSServer struct {
 	TCPServer
-	config *tls.Config
+	config       *tls.Config
+	serverConfig *tls.Config   // config used by connections
+	certs        *certReloader // set by SetCertificateFiles()
+	certErrorFn  func(err error)
 }
 
 // NewTLSServer create a new TCPServer.
@@ -22,6 +25,40 @@ func NewTLSServer(config *tls.Config) *TLSServer {
 	return server
 }
 
+// SetCertificateFiles sets the files of the certificate/key pair in PEM format. The pair is loaded immediately
+// and served through GetCertificate of the config instead of the certificates of the config.
+// If interval is positive, the files are checked every interval while the server is running and reloaded when their
+// modification time or size is changed. The new certificate is used for new connections, and the connections already
+// established are not affected. If a reload fails, the error is reported to the handler set by SetCertificateErrorHandler()
+// and the old certificate continues to be served.
+func (s *TLSServer) SetCertificateFiles(certFile string, keyFile string, interval time.Duration) error {
+	if s.isRunning() {
+		return errors.New("net: server object is already started")
+	}
+
+	certs, err := newCertReloader(certFile, keyFile, interval)
+	if err != nil {
+		return err
+	}
+	s.certs = certs
+	return nil
+}
+
+// SetCertificateErrorHandler sets the handler called with the error of a failed reload of the certificate files.
+func (s *TLSServer) SetCertificateErrorHandler(handler func(err error)) error {
+	s.certErrorFn = handler
+	return nil
+}
+
+// ReloadCertificate reloads the certificate files set by SetCertificateFiles() immediately.
+// If it fails, the error is returned and the old certificate continues to be served.
+func (s *TLSServer) ReloadCertificate() error {
+	if s.certs == nil {
+		return errors.New("net: certificate files are not set")
+	}
+	return s.certs.reload()
+}
+
 // Start starts the service. TCPServer binds to the address and can receive connection request.
 func (s *TLSServer) Start() error {
 	if s.isRunning() {
@@ -32,11 +69,24 @@ func (s *TLSServer) Start() error {
 
 	s.cctx, s.cancelFunc = context.WithCancel(context.Background())
 	s.doneCh = s.cctx.Done()
-	s.listener, err = net.Listen("tcp", s.address)
+	s.listener, err = s.listen()
 	if err != nil {
 		return err
 	}
 
+	s.serverConfig = s.config
+	if s.certs != nil {
+		if s.config != nil {
+			s.serverConfig = s.config.Clone()
+		} else {
+			s.serverConfig = new(tls.Config)
+		}
+		s.serverConfig.Certificates = nil
+		s.serverConfig.GetCertificate = s.certs.getCertificate
+		s.certs.errorHandler = s.certErrorFn
+		go s.certs.watch(s.doneCh)
+	}
+
 	go s.process(s)
 	return nil
 }
@@ -54,7 +104,7 @@ func (s *TLSServer) acceptLoop() {
 				case <-s.doneCh:
 				default:
 					switch {
-					case err.(net.Error).Temporary() || err.(net.Error).Timeout():
+					case isTemporary(err):
 						continue
 					default:
 						s.err = err
@@ -64,7 +114,7 @@ func (s *TLSServer) acceptLoop() {
 				return
 			}
 
-			conn = tls.Server(conn, s.config) // only difference from TCP
+			conn = tls.Server(conn, s.serverConfig) // only difference from TCP
 
 			child := s.newChildService(context.WithCancel(s.cctx))
 			nctx := newContext(child, conn, defaultQueueSize)
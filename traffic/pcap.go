@@ -0,0 +1,337 @@
+package traffic
+
+import (
+	"encoding/binary"
+	"io"
+	stdnet "net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shanpark/net"
+)
+
+// pcapng block types and options.
+const (
+	blockSection   = 0x0a0d0d0a
+	blockInterface = 0x00000001
+	blockPacket    = 0x00000006
+	byteOrderMagic = 0x1a2b3c4d
+	linkTypeRaw    = 101 // raw IPv4 or IPv6 packets
+	optTSResol     = 9
+)
+
+// TCP flags.
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+// maxSegment is the maximum payload of a synthesized segment, which keeps IP packets under 64KB.
+const maxSegment = 65000
+
+// PcapWriter writes connections as a pcapng capture that can be opened by Wireshark.
+// Like Recorder, it is a net.TrafficHandler, net.ConnectHandler and net.DisconnectHandler shared by all connections
+// of a service, and it also converts recordings with WriteRecord().
+//
+// Packets are raw IP packets with synthesized TCP headers carrying the addresses and ports of the connections
+// and consistent sequence numbers. Each connection starts with a handshake initiated by the peer and ends with
+// FIN segments. On TLS connections, the payload is the plaintext, so the dissectors of the application protocols work.
+// If an address of a connection is not an IP address, 127.0.0.1 is used for the local side and 127.0.0.2 for the peer.
+// If its port is unknown, 1 is used for the local side and an ephemeral port for the peer.
+type PcapWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	started bool
+	conns   int64
+	streams map[int64]*stream
+	err     error
+}
+
+type pcapKey struct {
+	writer *PcapWriter
+}
+
+// stream is the state of a synthesized TCP connection.
+type stream struct {
+	localIP    stdnet.IP
+	remoteIP   stdnet.IP
+	localPort  uint16
+	remotePort uint16
+	localSeq   uint32 // next sequence number of the local side
+	remoteSeq  uint32 // next sequence number of the peer
+}
+
+// NewPcapWriter creates a PcapWriter that writes a capture to w.
+func NewPcapWriter(w io.Writer) *PcapWriter {
+	writer := new(PcapWriter)
+	writer.w = w
+	writer.streams = make(map[int64]*stream)
+	return writer
+}
+
+// OnConnect implements net.ConnectHandler interface.
+func (p *PcapWriter) OnConnect(ctx *net.SoContext) error {
+	p.conn(ctx)
+	return nil
+}
+
+// OnTraffic implements net.TrafficHandler interface.
+func (p *PcapWriter) OnTraffic(ctx *net.SoContext, inbound bool, data []byte) {
+	typ := TypeOut
+	if inbound {
+		typ = TypeIn
+	}
+	p.WriteRecord(Record{Conn: p.conn(ctx), Time: time.Now(), Type: typ, Data: data})
+}
+
+// OnDisconnect implements net.DisconnectHandler interface.
+func (p *PcapWriter) OnDisconnect(ctx *net.SoContext) {
+	p.WriteRecord(Record{Conn: p.conn(ctx), Time: time.Now(), Type: TypeClose})
+}
+
+// WriteRecord writes the packets of record. Records of a recording loaded by Load() can be written in order
+// to convert it to a capture. It returns the first error that occurred while the capture is written.
+func (p *PcapWriter) WriteRecord(record Record) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeRecord(record)
+	return p.err
+}
+
+// Err returns the first error that occurred while the capture is written. Packets after an error are discarded.
+func (p *PcapWriter) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Close closes the writer if it implements io.Closer.
+func (p *PcapWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if closer, ok := p.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// conn returns the ID of the connection of ctx. The handshake is written when the ID is assigned.
+func (p *PcapWriter) conn(ctx *net.SoContext) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id, ok := ctx.Value(pcapKey{p}).(int64); ok {
+		return id
+	}
+	p.conns++
+	id := p.conns
+	ctx.SetValue(pcapKey{p}, id)
+	p.writeRecord(Record{Conn: id, Time: time.Now(), Type: TypeOpen, Local: ctx.LocalAddr().String(), Remote: ctx.RemoteAddr().String()})
+	return id
+}
+
+// writeRecord writes the packets of record. p.mu should be held.
+func (p *PcapWriter) writeRecord(record Record) {
+	s, ok := p.streams[record.Conn]
+	if !ok {
+		s = newStream(record)
+		p.streams[record.Conn] = s
+		p.writeHandshake(s, record.Time)
+	}
+
+	switch record.Type {
+	case TypeIn:
+		p.writeData(s, record.Time, false, record.Data)
+	case TypeOut:
+		p.writeData(s, record.Time, true, record.Data)
+	case TypeClose:
+		p.writeSegment(s, record.Time, true, flagFIN|flagACK, nil)
+		s.localSeq++
+		p.writeSegment(s, record.Time, false, flagFIN|flagACK, nil)
+		s.remoteSeq++
+		p.writeSegment(s, record.Time, true, flagACK, nil)
+		delete(p.streams, record.Conn)
+	}
+}
+
+func newStream(record Record) *stream {
+	s := new(stream)
+	s.localIP, s.localPort = parseAddr(record.Local, stdnet.IPv4(127, 0, 0, 1), 1)
+	s.remoteIP, s.remotePort = parseAddr(record.Remote, stdnet.IPv4(127, 0, 0, 2), uint16(49152+record.Conn%16384))
+	if s.localIP.To4() == nil || s.remoteIP.To4() == nil {
+		s.localIP = s.localIP.To16()
+		s.remoteIP = s.remoteIP.To16()
+	} else {
+		s.localIP = s.localIP.To4()
+		s.remoteIP = s.remoteIP.To4()
+	}
+	return s
+}
+
+func parseAddr(address string, defaultIP stdnet.IP, defaultPort uint16) (stdnet.IP, uint16) {
+	host, portStr, err := stdnet.SplitHostPort(address)
+	if err != nil {
+		return defaultIP, defaultPort
+	}
+	ip := stdnet.ParseIP(host)
+	if ip == nil {
+		ip = defaultIP
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return ip, defaultPort
+	}
+	return ip, uint16(port)
+}
+
+// writeData writes data in segments of maxSegment bytes. p.mu should be held.
+func (p *PcapWriter) writeData(s *stream, t time.Time, outbound bool, data []byte) {
+	for len(data) > 0 {
+		segment := data
+		if len(segment) > maxSegment {
+			segment = segment[:maxSegment]
+		}
+		p.writeSegment(s, t, outbound, flagPSH|flagACK, segment)
+		if outbound {
+			s.localSeq += uint32(len(segment))
+		} else {
+			s.remoteSeq += uint32(len(segment))
+		}
+		data = data[len(segment):]
+	}
+}
+
+// writeHandshake writes the handshake initiated by the peer. p.mu should be held.
+func (p *PcapWriter) writeHandshake(s *stream, t time.Time) {
+	p.writeSegment(s, t, false, flagSYN, nil)
+	s.remoteSeq++
+	p.writeSegment(s, t, true, flagSYN|flagACK, nil)
+	s.localSeq++
+	p.writeSegment(s, t, false, flagACK, nil)
+}
+
+// writeSegment writes a TCP segment sent by the local side if outbound is true or by the peer otherwise.
+// p.mu should be held.
+func (p *PcapWriter) writeSegment(s *stream, t time.Time, outbound bool, flags byte, payload []byte) {
+	srcIP, dstIP, srcPort, dstPort, seq, ack := s.remoteIP, s.localIP, s.remotePort, s.localPort, s.remoteSeq, s.localSeq
+	if outbound {
+		srcIP, dstIP, srcPort, dstPort, seq, ack = dstIP, srcIP, dstPort, srcPort, ack, seq
+	}
+	if flags&flagACK == 0 {
+		ack = 0
+	}
+
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // data offset
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	tcp = append(tcp, payload...)
+
+	var packet []byte
+	pseudo := make([]byte, 0, 40)
+	if len(srcIP) == stdnet.IPv4len {
+		packet = make([]byte, 20, 20+len(tcp))
+		packet[0] = 0x45 // version 4, header length 20
+		binary.BigEndian.PutUint16(packet[2:], uint16(20+len(tcp)))
+		packet[6] = 0x40 // don't fragment
+		packet[8] = 64   // TTL
+		packet[9] = 6    // TCP
+		copy(packet[12:], srcIP)
+		copy(packet[16:], dstIP)
+		binary.BigEndian.PutUint16(packet[10:], checksum(0, packet))
+		pseudo = append(pseudo, srcIP...)
+		pseudo = append(pseudo, dstIP...)
+		pseudo = append(pseudo, 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+	} else {
+		packet = make([]byte, 40, 40+len(tcp))
+		packet[0] = 0x60 // version 6
+		binary.BigEndian.PutUint16(packet[4:], uint16(len(tcp)))
+		packet[6] = 6  // TCP
+		packet[7] = 64 // hop limit
+		copy(packet[8:], srcIP)
+		copy(packet[24:], dstIP)
+		pseudo = append(pseudo, srcIP...)
+		pseudo = append(pseudo, dstIP...)
+		pseudo = append(pseudo, 0, 0, byte(len(tcp)>>8), byte(len(tcp)), 0, 0, 0, 6)
+	}
+	binary.BigEndian.PutUint16(tcp[16:], checksum(checksumSum(0, pseudo), tcp))
+	packet = append(packet, tcp...)
+
+	p.writePacket(t, packet)
+}
+
+// writePacket writes an enhanced packet block. p.mu should be held.
+func (p *PcapWriter) writePacket(t time.Time, packet []byte) {
+	if !p.started {
+		p.started = true
+		p.writeHeader()
+	}
+	ts := uint64(t.UnixNano())
+	body := make([]byte, 20, 20+len(packet))
+	binary.LittleEndian.PutUint32(body[0:], 0) // interface ID
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(packet)))
+	body = append(body, packet...)
+	p.writeBlock(blockPacket, body)
+}
+
+// writeHeader writes the section header block and the interface description block with nanosecond timestamps.
+// p.mu should be held.
+func (p *PcapWriter) writeHeader() {
+	section := make([]byte, 16)
+	binary.LittleEndian.PutUint32(section[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(section[4:], 1) // major version
+	binary.LittleEndian.PutUint16(section[6:], 0) // minor version
+	binary.LittleEndian.PutUint64(section[8:], ^uint64(0))
+	p.writeBlock(blockSection, section)
+
+	iface := make([]byte, 20)
+	binary.LittleEndian.PutUint16(iface[0:], linkTypeRaw)
+	binary.LittleEndian.PutUint32(iface[4:], 0) // no snap length limit
+	binary.LittleEndian.PutUint16(iface[8:], optTSResol)
+	binary.LittleEndian.PutUint16(iface[10:], 1)
+	iface[12] = 9 // 10^-9 seconds, iface[16:] is the end of options.
+	p.writeBlock(blockInterface, iface)
+}
+
+// writeBlock writes a block with body padded to 32 bits. p.mu should be held.
+func (p *PcapWriter) writeBlock(typ uint32, body []byte) {
+	if p.err != nil {
+		return
+	}
+	padded := (len(body) + 3) &^ 3
+	block := make([]byte, 12+padded)
+	binary.LittleEndian.PutUint32(block[0:], typ)
+	binary.LittleEndian.PutUint32(block[4:], uint32(len(block)))
+	copy(block[8:], body)
+	binary.LittleEndian.PutUint32(block[8+padded:], uint32(len(block)))
+	_, p.err = p.w.Write(block)
+}
+
+// checksumSum adds data to the one's complement sum.
+func checksumSum(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// checksum returns the internet checksum of data added to sum.
+func checksum(sum uint32, data []byte) uint16 {
+	sum = checksumSum(sum, data)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
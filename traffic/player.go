@@ -0,0 +1,117 @@
+package traffic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	"time"
+
+	"github.com/shanpark/net"
+)
+
+// Player replays a connection of a recording as the peer of the recorded side.
+// The "in" records are sent to the connection in order. Before each "in" record, Player reads the bytes of the
+// preceding "out" records from the connection, so the replayed peer waits for the responses as the original peer did.
+type Player struct {
+	records []Record
+	scale   float64
+	verify  bool
+}
+
+// NewPlayer creates a Player that replays the connection conn of records.
+// If conn is zero, the first connection of the recording is replayed.
+func NewPlayer(records []Record, conn int64) (*Player, error) {
+	player := new(Player)
+	for _, record := range records {
+		if conn == 0 && record.Conn != 0 {
+			conn = record.Conn
+		}
+		if record.Conn == conn {
+			player.records = append(player.records, record)
+		}
+	}
+	if len(player.records) == 0 {
+		return nil, fmt.Errorf("traffic: no records of connection %d", conn)
+	}
+	return player, nil
+}
+
+// SetTiming sets the scale of the recorded intervals between records. For example, 1 keeps the original timing
+// and 0.5 replays twice as fast. Zero, the default, sends records without waiting.
+func (p *Player) SetTiming(scale float64) {
+	p.scale = scale
+}
+
+// SetVerify sets whether the bytes read from the connection are compared with the "out" records.
+// If verify is true, Play() returns an error at the first difference.
+func (p *Player) SetVerify(verify bool) {
+	p.verify = verify
+}
+
+// Play replays the connection to conn. After the last "in" record is sent, it reads the rest of "out" records
+// and then closes the write side of conn if possible. conn is not closed.
+func (p *Player) Play(conn stdnet.Conn) error {
+	var expected []byte // bytes of "out" records not read yet
+	var last time.Time
+	for _, record := range p.records {
+		switch record.Type {
+		case TypeOut:
+			expected = append(expected, record.Data...)
+		case TypeIn:
+			if err := p.read(conn, expected); err != nil {
+				return err
+			}
+			expected = nil
+			p.wait(last, record.Time)
+			if _, err := conn.Write(record.Data); err != nil {
+				return err
+			}
+		}
+		last = record.Time
+	}
+
+	if err := p.read(conn, expected); err != nil {
+		return err
+	}
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	}
+	return nil
+}
+
+// PlayEmbedded feeds the "in" records to pipeline in order. The "out" records are ignored.
+// It returns the first error raised by the handlers of pipeline.
+func (p *Player) PlayEmbedded(pipeline *net.EmbeddedPipeline) error {
+	var last time.Time
+	for _, record := range p.records {
+		if record.Type == TypeIn {
+			p.wait(last, record.Time)
+			if err := pipeline.WriteInbound(record.Data); err != nil {
+				return err
+			}
+		}
+		last = record.Time
+	}
+	return nil
+}
+
+// read reads the bytes of expected from conn.
+func (p *Player) read(conn stdnet.Conn, expected []byte) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	data := make([]byte, len(expected))
+	n, err := io.ReadFull(conn, data)
+	if p.verify && !bytes.Equal(data[:n], expected[:n]) {
+		return errors.New("traffic: received bytes differ from the recording")
+	}
+	return err
+}
+
+func (p *Player) wait(last time.Time, next time.Time) {
+	if p.scale > 0 && !last.IsZero() && next.After(last) {
+		time.Sleep(time.Duration(float64(next.Sub(last)) * p.scale))
+	}
+}
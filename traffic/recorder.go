@@ -0,0 +1,147 @@
+// Package traffic records the bytes of connections and replays them.
+//
+// Recorder is a net.TrafficHandler that writes the bytes read from and written to each connection with timestamps.
+// On TLS connections, the plaintext is recorded. A recording is a JSON Lines stream of Record values:
+//
+//	{"conn":1,"time":"2024-05-01T10:00:00.000000001Z","type":"open","local":"10.0.0.1:80","remote":"10.0.0.2:51000"}
+//	{"conn":1,"time":"2024-05-01T10:00:00.000100000Z","type":"in","data":"R0VUIC8gSFRUUC8xLjENCg0K"}
+//	{"conn":1,"time":"2024-05-01T10:00:00.000200000Z","type":"out","data":"SFRUUC8xLjEgMjAwIE9LDQoNCg=="}
+//	{"conn":1,"time":"2024-05-01T10:00:00.000300000Z","type":"close"}
+//
+// conn identifies a connection in the recording, "in" records are the bytes received from the peer
+// and "out" records are the bytes sent to the peer. data is encoded in base64.
+//
+// Player replays the "in" records of a connection to a connection, acting as the peer of the recorded side.
+// It can feed a recording of a server to a TCPServer or act as a fake server for a TCPClient.
+//
+// PcapWriter writes connections or recordings as pcapng captures with synthesized TCP/IP headers for Wireshark.
+package traffic
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/shanpark/net"
+)
+
+// Types of records.
+const (
+	TypeOpen  = "open"
+	TypeIn    = "in"
+	TypeOut   = "out"
+	TypeClose = "close"
+)
+
+// Record is an event of a connection in a recording.
+type Record struct {
+	Conn   int64     `json:"conn"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Local  string    `json:"local,omitempty"`
+	Remote string    `json:"remote,omitempty"`
+	Data   []byte    `json:"data,omitempty"`
+}
+
+// Recorder is a net.TrafficHandler, net.ConnectHandler and net.DisconnectHandler that records connections.
+// It can be shared by all connections of a service. Records are written to the writer one line at a time.
+type Recorder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	w       io.Writer
+	conns   int64
+	err     error
+}
+
+type connKey struct {
+	recorder *Recorder
+}
+
+// NewRecorder creates a Recorder that writes records to w.
+func NewRecorder(w io.Writer) *Recorder {
+	recorder := new(Recorder)
+	recorder.w = w
+	recorder.encoder = json.NewEncoder(w)
+	return recorder
+}
+
+// OnConnect implements net.ConnectHandler interface.
+func (r *Recorder) OnConnect(ctx *net.SoContext) error {
+	r.conn(ctx)
+	return nil
+}
+
+// OnTraffic implements net.TrafficHandler interface.
+func (r *Recorder) OnTraffic(ctx *net.SoContext, inbound bool, data []byte) {
+	typ := TypeOut
+	if inbound {
+		typ = TypeIn
+	}
+	r.write(Record{Conn: r.conn(ctx), Time: time.Now(), Type: typ, Data: data})
+}
+
+// OnDisconnect implements net.DisconnectHandler interface.
+func (r *Recorder) OnDisconnect(ctx *net.SoContext) {
+	r.write(Record{Conn: r.conn(ctx), Time: time.Now(), Type: TypeClose})
+}
+
+// Err returns the first error that occurred while records are written. Records after an error are discarded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close closes the writer if it implements io.Closer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if closer, ok := r.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// conn returns the ID of the connection of ctx. The open record is written when the ID is assigned.
+func (r *Recorder) conn(ctx *net.SoContext) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := ctx.Value(connKey{r}).(int64); ok {
+		return id
+	}
+	r.conns++
+	id := r.conns
+	ctx.SetValue(connKey{r}, id)
+	r.encode(Record{Conn: id, Time: time.Now(), Type: TypeOpen, Local: ctx.LocalAddr().String(), Remote: ctx.RemoteAddr().String()})
+	return id
+}
+
+func (r *Recorder) write(record Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.encode(record)
+}
+
+// encode writes record. r.mu should be held.
+func (r *Recorder) encode(record Record) {
+	if r.err == nil {
+		r.err = r.encoder.Encode(record)
+	}
+}
+
+// Load reads all records of a recording.
+func Load(rd io.Reader) ([]Record, error) {
+	var records []Record
+	decoder := json.NewDecoder(bufio.NewReader(rd))
+	for {
+		var record Record
+		if err := decoder.Decode(&record); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,176 @@
+package traffic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shanpark/net"
+	"github.com/shanpark/net/memnet"
+)
+
+type echo struct {
+	upper bool
+}
+
+func (e echo) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	line := string(in.(*net.Buffer).Data())
+	if e.upper {
+		line = strings.ToUpper(line)
+	}
+	ctx.Write(line)
+	return nil, nil
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func startEcho(t *testing.T, listener *memnet.Listener, handlers ...interface{}) *net.TCPServer {
+	server := net.NewTCPServer()
+	server.SetListener(listener)
+	server.AddHandler(net.NewLineFrameDecoder(64), net.NewLineFrameEncoder(false))
+	server.AddHandler(handlers...)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func record(t *testing.T) []Record {
+	output := new(lockedBuffer)
+	recorder := NewRecorder(output)
+	listener := memnet.NewListener("recorded")
+	server := startEcho(t, listener, recorder, echo{})
+	defer server.Stop()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello\n"))
+	buf := make([]byte, 6)
+	io.ReadFull(conn, buf)
+	conn.Write([]byte("world\n"))
+	io.ReadFull(conn, buf)
+	conn.Close()
+
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(output.String(), `"close"`); {
+		if time.Now().After(deadline) {
+			t.Fatal("close record not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := Load(strings.NewReader(output.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestRecordAndPlay(t *testing.T) {
+	records := record(t)
+	var types []string
+	for _, record := range records {
+		types = append(types, record.Type)
+	}
+	if strings.Join(types, " ") != "open in out in out close" {
+		t.Fatalf("record types = %v", types)
+	}
+	if records[0].Remote != "recorded-client-1" || string(records[3].Data) != "world\n" {
+		t.Errorf("records = %+v", records)
+	}
+
+	player, err := NewPlayer(records, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	player.SetVerify(true)
+	for _, upper := range []bool{false, true} {
+		listener := memnet.NewListener("replayed")
+		server := startEcho(t, listener, echo{upper})
+		conn, _ := listener.Dial()
+		if err = player.Play(conn); (err != nil) != upper {
+			t.Errorf("Play() to server with upper %v error = %v", upper, err)
+		}
+		conn.Close()
+		server.Stop()
+	}
+
+	pipeline, _ := net.NewEmbeddedPipeline(net.NewLineFrameDecoder(64))
+	if err = player.PlayEmbedded(pipeline); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"hello", "world"} {
+		if msg, ok := pipeline.ReadInbound().(*net.Buffer); !ok || string(msg.Data()) != expected {
+			t.Errorf("ReadInbound() = %v", msg)
+		}
+	}
+
+	if _, err = NewPlayer(records, 2); err == nil {
+		t.Error("NewPlayer() of unknown connection succeeded")
+	}
+}
+
+func TestPcapWriter(t *testing.T) {
+	var output bytes.Buffer
+	writer := NewPcapWriter(&output)
+	for _, record := range record(t) {
+		if err := writer.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var packets [][]byte
+	data := output.Bytes()
+	for len(data) > 0 {
+		size := binary.LittleEndian.Uint32(data[4:])
+		if size%4 != 0 || binary.LittleEndian.Uint32(data[size-4:]) != size {
+			t.Fatalf("invalid block length %d", size)
+		}
+		if binary.LittleEndian.Uint32(data) == blockPacket {
+			packets = append(packets, data[28:28+binary.LittleEndian.Uint32(data[20:])])
+		}
+		data = data[size:]
+	}
+	// handshake, 4 data segments and close
+	if len(packets) != 10 {
+		t.Fatalf("%d packets written", len(packets))
+	}
+
+	packet := packets[3] // "hello\n" from the peer
+	if checksum(0, packet[:20]) != 0 || !bytes.Equal(packet[12:20], []byte{127, 0, 0, 2, 127, 0, 0, 1}) {
+		t.Errorf("invalid IP header % x", packet[:20])
+	}
+	tcp := packet[20:]
+	pseudo := append(append([]byte{}, packet[12:20]...), 0, 6, 0, byte(len(tcp)))
+	if checksum(checksumSum(0, pseudo), tcp) != 0 || string(tcp[20:]) != "hello\n" {
+		t.Errorf("invalid TCP segment % x", tcp)
+	}
+	if seq, ack := binary.BigEndian.Uint32(tcp[4:]), binary.BigEndian.Uint32(tcp[8:]); seq != 1 || ack != 1 {
+		t.Errorf("seq %d, ack %d", seq, ack)
+	}
+	if seq := binary.BigEndian.Uint32(packets[6][24:]); seq != 7 { // "world\n" from the peer
+		t.Errorf("seq of second segment %d", seq)
+	}
+}
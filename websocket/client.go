@@ -0,0 +1,255 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/shanpark/net"
+)
+
+// ClientHandshaker is a net.ConnectHandler, net.ReadHandler and net.WriteHandler that performs
+// the client side opening handshake of WebSocket on a TCPClient or TLSClient.
+// ClientHandshaker should be placed on the stream directly.
+//
+// The handshake request is sent when the connection is established. When a valid response is received,
+// ClientHandshaker replaces itself in the pipeline of the connection with a Codec that masks outbound frames.
+// The result of the handshake can be obtained with HandshakeOf().
+// Messages written before the handshake completes are held and sent as WebSocket messages after it.
+// If the response is not a valid handshake response, ClientHandshaker closes the connection and returns the error.
+type ClientHandshaker struct {
+	url            *url.URL
+	header         http.Header
+	subprotocols   []string
+	compression    bool
+	maxMessageSize int64
+	maxHeaderSize  int
+	defaultType    int
+}
+
+type clientState struct {
+	request *http.Request
+	key     string
+	sent    *net.Buffer   // encoded handshake request
+	pending []interface{} // messages written before the handshake completes
+}
+
+// NewClientHandshaker returns a ClientHandshaker for the WebSocket URL such as "ws://example.com/chat".
+// The scheme of the URL should be one of ws, wss, http and https. The address of the client should be set separately.
+func NewClientHandshaker(rawURL string) (*ClientHandshaker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws", "wss", "http", "https":
+	default:
+		return nil, fmt.Errorf("websocket: unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("websocket: no host in URL %q", rawURL)
+	}
+
+	handshaker := new(ClientHandshaker)
+	handshaker.url = u
+	handshaker.maxMessageSize = DefaultMaxMessageSize
+	handshaker.maxHeaderSize = DefaultMaxHeaderSize
+	handshaker.defaultType = BinaryMessage
+	return handshaker, nil
+}
+
+// SetHeader sets additional header fields of the handshake request such as Origin, Cookie or Authorization.
+func (h *ClientHandshaker) SetHeader(header http.Header) {
+	h.header = header
+}
+
+// SetSubprotocols sets the subprotocols requested to the server in order of preference.
+func (h *ClientHandshaker) SetSubprotocols(protocols ...string) {
+	h.subprotocols = protocols
+}
+
+// SetCompression sets whether permessage-deflate is requested to the server.
+func (h *ClientHandshaker) SetCompression(enable bool) {
+	h.compression = enable
+}
+
+// SetMaxMessageSize sets the maximum size of a received message. See Codec.SetMaxMessageSize().
+func (h *ClientHandshaker) SetMaxMessageSize(size int64) {
+	h.maxMessageSize = size
+}
+
+// SetMaxHeaderSize sets the maximum size of the handshake response.
+func (h *ClientHandshaker) SetMaxHeaderSize(size int) {
+	h.maxHeaderSize = size
+}
+
+// SetDefaultType sets the message type used to send []byte and *net.Buffer. See Codec.SetDefaultType().
+func (h *ClientHandshaker) SetDefaultType(messageType int) {
+	h.defaultType = messageType
+}
+
+// OnConnect implements net.ConnectHandler interface.
+func (h *ClientHandshaker) OnConnect(ctx *net.SoContext) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	u := *h.url
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for name, values := range h.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(h.subprotocols) > 0 {
+		req.Header["Sec-WebSocket-Protocol"] = h.subprotocols
+	}
+	if h.compression {
+		req.Header.Set("Sec-WebSocket-Extensions", deflateExtension+"; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	var encoded bytes.Buffer
+	if err := req.Write(&encoded); err != nil {
+		return err
+	}
+
+	state := &clientState{request: req, key: key, sent: newBufferOf(encoded.Bytes())}
+	ctx.SetValue(h, state)
+	return ctx.Write(state.sent)
+}
+
+// OnRead implements net.ReadHandler interface.
+func (h *ClientHandshaker) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("websocket: unsupported inbound message type - %T", in)
+	}
+	state, ok := ctx.Value(h).(*clientState)
+	if !ok {
+		return nil, errors.New("websocket: handshake response before request")
+	}
+
+	data := buffer.Data()
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 || end+4 > h.maxHeaderSize {
+		if len(data) > h.maxHeaderSize || end >= 0 {
+			return nil, h.fail(ctx, errors.New("websocket: handshake response too large"))
+		}
+		ctx.Rollback()
+		return nil, nil
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data[:end+4])), state.request)
+	if err != nil {
+		return nil, h.fail(ctx, fmt.Errorf("websocket: invalid handshake response - %v", err))
+	}
+	buffer.DataConsume(end + 4)
+
+	handshake, err := h.verify(resp, state)
+	if err != nil {
+		return nil, h.fail(ctx, err)
+	}
+
+	codec := NewCodec(true)
+	codec.SetCompression(handshake.Compression)
+	codec.SetMaxMessageSize(h.maxMessageSize)
+	codec.SetDefaultType(h.defaultType)
+	if err = ctx.ReplaceHandler(h, codec); err != nil {
+		return nil, err
+	}
+	ctx.SetValue(h, nil)
+	ctx.SetValue(handshakeKey{}, handshake)
+
+	for _, out := range state.pending { // held messages have passed the handlers before ClientHandshaker already.
+		frame, err := codec.OnWrite(ctx, out)
+		if err != nil {
+			return nil, err
+		}
+		if buffer, ok := frame.(*net.Buffer); ok {
+			codec.writeRaw(ctx, ContinuationMessage, buffer.Data())
+		}
+	}
+	return nil, nil
+}
+
+// OnWrite implements net.WriteHandler interface.
+func (h *ClientHandshaker) OnWrite(ctx *net.SoContext, out interface{}) (interface{}, error) {
+	state, ok := ctx.Value(h).(*clientState)
+	if !ok {
+		return nil, errors.New("websocket: write before connection")
+	}
+	if out == state.sent {
+		return out, nil
+	}
+	state.pending = append(state.pending, out)
+	return nil, nil
+}
+
+// verify validates the handshake response and returns the negotiated options.
+func (h *ClientHandshaker) verify(resp *http.Response, state *clientState) (*Handshake, error) {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket: handshake failed - %s", resp.Status)
+	}
+	if !hasToken(resp.Header, "Connection", "upgrade") || !hasToken(resp.Header, "Upgrade", "websocket") {
+		return nil, errors.New("websocket: not a websocket upgrade response")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(state.key) {
+		return nil, errors.New("websocket: invalid Sec-WebSocket-Accept")
+	}
+
+	handshake := &Handshake{Request: state.request, Response: resp}
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		for _, p := range h.subprotocols {
+			if p == protocol {
+				handshake.Subprotocol = protocol
+			}
+		}
+		if handshake.Subprotocol == "" {
+			return nil, fmt.Errorf("websocket: unrequested subprotocol %q", protocol)
+		}
+	}
+
+	for _, ext := range parseExtensions(resp.Header) {
+		if ext.name != deflateExtension || !h.compression || handshake.Compression {
+			return nil, fmt.Errorf("websocket: unrequested extension %q", ext.name)
+		}
+		// Codec compresses with the full window and without context takeover, and decompresses without context takeover.
+		if _, ok := ext.params["server_no_context_takeover"]; !ok {
+			return nil, errors.New("websocket: server_no_context_takeover not accepted")
+		}
+		if bits, ok := ext.params["client_max_window_bits"]; ok && bits != "15" {
+			return nil, fmt.Errorf("websocket: unsupported client_max_window_bits %q", bits)
+		}
+		handshake.Compression = true
+	}
+	return handshake, nil
+}
+
+// fail closes the connection after a failed handshake. It returns err.
+func (h *ClientHandshaker) fail(ctx *net.SoContext, err error) error {
+	ctx.Close()
+	return err
+}
@@ -0,0 +1,390 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/shanpark/net"
+)
+
+// deflateTail is appended to a compressed message before decompression.
+// It is the tail of the sync flush removed by the sender followed by an empty final block.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var flateWriterPool sync.Pool
+
+var errIncomplete = errors.New("websocket: incomplete frame")
+
+// Codec is a net.ReadHandler and net.WriteHandler that decodes and encodes the frames of a WebSocket connection.
+// Codec keeps the state of one connection, so a Codec should not be shared by connections.
+// Upgrader and ClientHandshaker create a Codec for each connection after the handshake.
+//
+// Fragmented messages are reassembled, pings are answered with pongs, and a received close message is echoed
+// before the connection is closed. Text messages are passed to the next handler as strings and binary messages as []byte.
+// If the peer violates the protocol, Codec sends a close message with the proper status code,
+// closes the connection and returns a *CloseError.
+type Codec struct {
+	client         bool
+	compression    bool
+	maxMessageSize int64
+	defaultType    int
+
+	messageType int
+	compressed  bool
+	fragments   []byte
+
+	closeSent     bool                // whether the close message has passed OnWrite
+	closeReceived bool                // whether the close message has been received or the connection has failed
+	raw           map[*net.Buffer]int // already encoded frames written by Codec itself and their types
+}
+
+// NewCodec returns a Codec. If client is true, outbound frames are masked and inbound frames must not be masked.
+// Otherwise, inbound frames must be masked and outbound frames are not masked.
+func NewCodec(client bool) *Codec {
+	codec := new(Codec)
+	codec.client = client
+	codec.maxMessageSize = DefaultMaxMessageSize
+	codec.defaultType = BinaryMessage
+	codec.raw = make(map[*net.Buffer]int)
+	return codec
+}
+
+// SetCompression sets whether permessage-deflate is used. It should be enabled only if it is negotiated by the handshake.
+// Messages are compressed without context takeover in both directions.
+func (c *Codec) SetCompression(enable bool) {
+	c.compression = enable
+}
+
+// SetMaxMessageSize sets the maximum size of a received message. If size is 0 or less, the size is not limited.
+// A message that exceeds the limit closes the connection with CloseMessageTooBig.
+func (c *Codec) SetMaxMessageSize(size int64) {
+	c.maxMessageSize = size
+}
+
+// SetDefaultType sets the message type used to send []byte and *net.Buffer. It should be TextMessage or BinaryMessage.
+func (c *Codec) SetDefaultType(messageType int) {
+	c.defaultType = messageType
+}
+
+// OnRead implements net.ReadHandler interface.
+func (c *Codec) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("websocket: unsupported inbound message type - %T", in)
+	}
+
+	if c.closeReceived { // nothing is expected after the close message.
+		buffer.DataConsume(buffer.Readable())
+		return nil, nil
+	}
+
+	fin, rsv1, opcode, payload, size, err := c.readFrame(buffer.Data())
+	if err == errIncomplete {
+		ctx.Rollback()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, c.fail(ctx, err)
+	}
+	buffer.DataConsume(size)
+
+	if opcode >= CloseMessage {
+		return nil, c.handleControl(ctx, opcode, payload)
+	}
+
+	if opcode != ContinuationMessage {
+		c.messageType = opcode
+		c.compressed = rsv1
+	}
+	c.fragments = append(c.fragments, payload...)
+	if !fin {
+		return nil, nil
+	}
+
+	data := c.fragments
+	c.fragments = nil
+	messageType := c.messageType
+	c.messageType = ContinuationMessage
+	if c.compressed {
+		if data, err = c.decompress(data); err != nil {
+			return nil, c.fail(ctx, err)
+		}
+	}
+
+	if messageType == TextMessage {
+		if !utf8.Valid(data) {
+			return nil, c.fail(ctx, &CloseError{CloseInvalidFramePayloadData, "invalid UTF-8 in text message"})
+		}
+		return string(data), nil
+	}
+	return data, nil
+}
+
+// OnWrite implements net.WriteHandler interface.
+func (c *Codec) OnWrite(ctx *net.SoContext, out interface{}) (interface{}, error) {
+	switch msg := out.(type) {
+	case *Message:
+		return c.encode(ctx, msg.Type, msg.Data)
+	case string:
+		return c.encode(ctx, TextMessage, []byte(msg))
+	case []byte:
+		return c.encode(ctx, c.defaultType, msg)
+	case *net.Buffer:
+		if opcode, ok := c.raw[msg]; ok {
+			delete(c.raw, msg)
+			if c.closeSent {
+				return nil, nil
+			}
+			c.closeSent = opcode == CloseMessage
+			return msg, nil
+		}
+		return c.encode(ctx, c.defaultType, msg.Data())
+	}
+	return nil, fmt.Errorf("websocket: unsupported outbound message type - %T", out)
+}
+
+// writeRaw writes data that is already encoded. It passes through the write handlers and Codec as it is.
+// If the close message has been sent before it reaches Codec, it is dropped.
+func (c *Codec) writeRaw(ctx *net.SoContext, opcode int, data []byte) {
+	buffer := newBufferOf(data)
+	c.raw[buffer] = opcode
+	ctx.Write(buffer)
+}
+
+// readFrame parses a frame at the start of data. It returns errIncomplete if the frame is not complete.
+func (c *Codec) readFrame(data []byte) (fin bool, rsv1 bool, opcode int, payload []byte, size int, err error) {
+	if len(data) < 2 {
+		return false, false, 0, nil, 0, errIncomplete
+	}
+
+	fin = data[0]&0x80 != 0
+	rsv1 = data[0]&0x40 != 0
+	opcode = int(data[0] & 0x0f)
+	masked := data[1]&0x80 != 0
+	length := uint64(data[1] & 0x7f)
+
+	if data[0]&0x30 != 0 {
+		return false, false, 0, nil, 0, &CloseError{CloseProtocolError, "reserved bits set"}
+	}
+	switch opcode {
+	case ContinuationMessage:
+		if c.messageType == ContinuationMessage {
+			return false, false, 0, nil, 0, &CloseError{CloseProtocolError, "continuation frame without a message"}
+		}
+	case TextMessage, BinaryMessage:
+		if c.messageType != ContinuationMessage {
+			return false, false, 0, nil, 0, &CloseError{CloseProtocolError, "new message before the last fragment"}
+		}
+	case CloseMessage, PingMessage, PongMessage:
+		if !fin || length > maxControlPayloadSize {
+			return false, false, 0, nil, 0, &CloseError{CloseProtocolError, "invalid control frame"}
+		}
+	default:
+		return false, false, 0, nil, 0, &CloseError{CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode)}
+	}
+	if rsv1 && (!c.compression || opcode != TextMessage && opcode != BinaryMessage) {
+		return false, false, 0, nil, 0, &CloseError{CloseProtocolError, "unexpected compressed frame"}
+	}
+	if masked == c.client {
+		return false, false, 0, nil, 0, &CloseError{CloseProtocolError, "invalid masking"}
+	}
+
+	size = 2
+	switch length {
+	case 126:
+		if len(data) < 4 {
+			return false, false, 0, nil, 0, errIncomplete
+		}
+		length = uint64(binary.BigEndian.Uint16(data[2:]))
+		size = 4
+	case 127:
+		if len(data) < 10 {
+			return false, false, 0, nil, 0, errIncomplete
+		}
+		length = binary.BigEndian.Uint64(data[2:])
+		size = 10
+		if length>>63 != 0 {
+			return false, false, 0, nil, 0, &CloseError{CloseProtocolError, "invalid payload length"}
+		}
+	}
+	if opcode < CloseMessage && c.maxMessageSize > 0 && uint64(len(c.fragments))+length > uint64(c.maxMessageSize) {
+		return false, false, 0, nil, 0, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+
+	var key []byte
+	if masked {
+		if len(data) < size+4 {
+			return false, false, 0, nil, 0, errIncomplete
+		}
+		key = data[size : size+4]
+		size += 4
+	}
+	if uint64(len(data)-size) < length {
+		return false, false, 0, nil, 0, errIncomplete
+	}
+
+	payload = make([]byte, length)
+	copy(payload, data[size:])
+	if masked {
+		maskBytes(key, payload)
+	}
+	return fin, rsv1, opcode, payload, size + int(length), nil
+}
+
+func (c *Codec) handleControl(ctx *net.SoContext, opcode int, payload []byte) error {
+	switch opcode {
+	case PingMessage:
+		c.writeRaw(ctx, PongMessage, c.frame(PongMessage, false, payload))
+	case CloseMessage:
+		if err := validateClosePayload(payload); err != nil {
+			return c.fail(ctx, err)
+		}
+		c.closeReceived = true
+		if len(payload) > 0 {
+			code, _ := ParseCloseMessage(payload)
+			payload = FormatCloseMessage(code, "")
+		}
+		c.writeRaw(ctx, CloseMessage, c.frame(CloseMessage, false, payload)) // echo the close message.
+		ctx.FlushAndClose()
+	}
+	return nil
+}
+
+// fail sends a close message for err and closes the connection. It returns err.
+func (c *Codec) fail(ctx *net.SoContext, err error) error {
+	c.closeReceived = true
+	code := CloseProtocolError
+	if closeErr, ok := err.(*CloseError); ok {
+		code = closeErr.Code
+	}
+	c.writeRaw(ctx, CloseMessage, c.frame(CloseMessage, false, FormatCloseMessage(code, "")))
+	ctx.FlushAndClose()
+	return err
+}
+
+func (c *Codec) encode(ctx *net.SoContext, messageType int, data []byte) (interface{}, error) {
+	if c.closeSent {
+		return nil, ErrCloseSent
+	}
+
+	compressed := false
+	switch messageType {
+	case TextMessage, BinaryMessage:
+		if c.compression {
+			var err error
+			if data, err = compress(data); err != nil {
+				return nil, err
+			}
+			compressed = true
+		}
+	case CloseMessage, PingMessage, PongMessage:
+		if len(data) > maxControlPayloadSize {
+			return nil, errors.New("websocket: control message too long")
+		}
+		if messageType == CloseMessage {
+			c.closeSent = true // the connection is closed when the peer echoes the close message.
+		}
+	default:
+		return nil, fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+
+	return newBufferOf(c.frame(messageType, compressed, data)), nil
+}
+
+// frame returns an encoded frame that contains a whole message.
+func (c *Codec) frame(opcode int, compressed bool, payload []byte) []byte {
+	frame := make([]byte, 0, 14+len(payload))
+
+	b0 := byte(0x80 | opcode)
+	if compressed {
+		b0 |= 0x40
+	}
+	frame = append(frame, b0)
+
+	var b1 byte
+	if c.client {
+		b1 = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, b1|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, b1|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, b1|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	if !c.client {
+		return append(frame, payload...)
+	}
+
+	var key [4]byte
+	rand.Read(key[:])
+	frame = append(frame, key[:]...)
+	start := len(frame)
+	frame = append(frame, payload...)
+	maskBytes(key[:], frame[start:])
+	return frame
+}
+
+func (c *Codec) decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail)))
+	defer r.Close()
+
+	var limited io.Reader = r
+	if c.maxMessageSize > 0 {
+		limited = io.LimitReader(r, c.maxMessageSize+1)
+	}
+	message, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, &CloseError{CloseInvalidFramePayloadData, "invalid compressed data"}
+	}
+	if c.maxMessageSize > 0 && int64(len(message)) > c.maxMessageSize {
+		return nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+	return message, nil
+}
+
+// compress compresses data without context takeover and removes the tail of the sync flush.
+func compress(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w, ok := flateWriterPool.Get().(*flate.Writer)
+	if ok {
+		w.Reset(&out)
+	} else {
+		var err error
+		if w, err = flate.NewWriter(&out, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	}
+	defer flateWriterPool.Put(w)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), deflateTail[:4]), nil
+}
+
+func maskBytes(key []byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i&3]
+	}
+}
+
+func newBufferOf(data []byte) *net.Buffer {
+	buffer := net.NewBuffer(len(data))
+	buffer.Write(data)
+	return buffer
+}
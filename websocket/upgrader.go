@@ -0,0 +1,208 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/shanpark/net"
+)
+
+// DefaultMaxHeaderSize is the default maximum size of the handshake request.
+const DefaultMaxHeaderSize = 64 * 1024
+
+const deflateExtension = "permessage-deflate"
+
+// Upgrader is a net.ReadHandler that performs the server side opening handshake of WebSocket.
+// Upgrader should be placed on the stream directly. An Upgrader can be shared by the connections of a server.
+//
+// When a valid handshake request is received, Upgrader sends the response
+// and replaces itself in the pipeline of the connection with a Codec configured with the negotiated options.
+// The result of the handshake can be obtained with HandshakeOf(). If the request is not a valid handshake request,
+// Upgrader sends an error response, closes the connection and returns the error.
+type Upgrader struct {
+	subprotocols   []string
+	compression    bool
+	maxMessageSize int64
+	maxHeaderSize  int
+	defaultType    int
+	checkOrigin    func(r *http.Request) bool
+}
+
+// NewUpgrader returns an Upgrader with the default options.
+func NewUpgrader() *Upgrader {
+	upgrader := new(Upgrader)
+	upgrader.maxMessageSize = DefaultMaxMessageSize
+	upgrader.maxHeaderSize = DefaultMaxHeaderSize
+	upgrader.defaultType = BinaryMessage
+	return upgrader
+}
+
+// SetSubprotocols sets the subprotocols supported by the server in order of preference.
+// The first one that is also requested by the client is selected.
+func (u *Upgrader) SetSubprotocols(protocols ...string) {
+	u.subprotocols = protocols
+}
+
+// SetCompression sets whether permessage-deflate is accepted when the client requests it.
+func (u *Upgrader) SetCompression(enable bool) {
+	u.compression = enable
+}
+
+// SetMaxMessageSize sets the maximum size of a received message. See Codec.SetMaxMessageSize().
+func (u *Upgrader) SetMaxMessageSize(size int64) {
+	u.maxMessageSize = size
+}
+
+// SetMaxHeaderSize sets the maximum size of the handshake request.
+func (u *Upgrader) SetMaxHeaderSize(size int) {
+	u.maxHeaderSize = size
+}
+
+// SetDefaultType sets the message type used to send []byte and *net.Buffer. See Codec.SetDefaultType().
+func (u *Upgrader) SetDefaultType(messageType int) {
+	u.defaultType = messageType
+}
+
+// SetCheckOrigin sets the function that checks the request. If it returns false, the handshake fails with 403 Forbidden.
+// By default, all requests are accepted.
+func (u *Upgrader) SetCheckOrigin(checkOrigin func(r *http.Request) bool) {
+	u.checkOrigin = checkOrigin
+}
+
+// OnRead implements net.ReadHandler interface.
+func (u *Upgrader) OnRead(ctx *net.SoContext, in interface{}) (interface{}, error) {
+	buffer, ok := in.(*net.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("websocket: unsupported inbound message type - %T", in)
+	}
+
+	data := buffer.Data()
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 || end+4 > u.maxHeaderSize {
+		if len(data) > u.maxHeaderSize || end >= 0 {
+			return nil, u.reject(ctx, http.StatusRequestHeaderFieldsTooLarge, nil, errors.New("websocket: handshake request too large"))
+		}
+		ctx.Rollback()
+		return nil, nil
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data[:end+4])))
+	if err != nil {
+		return nil, u.reject(ctx, http.StatusBadRequest, nil, fmt.Errorf("websocket: invalid handshake request - %v", err))
+	}
+	buffer.DataConsume(end + 4)
+
+	handshake, status, header, err := u.negotiate(req)
+	if err != nil {
+		return nil, u.reject(ctx, status, header, err)
+	}
+
+	codec := NewCodec(false)
+	codec.SetCompression(handshake.Compression)
+	codec.SetMaxMessageSize(u.maxMessageSize)
+	codec.SetDefaultType(u.defaultType)
+
+	var resp bytes.Buffer
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	resp.WriteString("Sec-WebSocket-Accept: " + acceptKey(req.Header.Get("Sec-WebSocket-Key")) + "\r\n")
+	if handshake.Subprotocol != "" {
+		resp.WriteString("Sec-WebSocket-Protocol: " + handshake.Subprotocol + "\r\n")
+	}
+	if handshake.Compression {
+		resp.WriteString("Sec-WebSocket-Extensions: " + deflateExtension + "; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	resp.WriteString("\r\n")
+
+	if err = ctx.ReplaceHandler(u, codec); err != nil {
+		return nil, err
+	}
+	ctx.SetValue(handshakeKey{}, handshake)
+	codec.writeRaw(ctx, ContinuationMessage, resp.Bytes()) // Codec is already in the pipeline when the response is written.
+	return nil, nil
+}
+
+// negotiate validates the handshake request and selects the options of the connection.
+// If the request is not acceptable, it returns the status code and the header fields of the error response.
+func (u *Upgrader) negotiate(req *http.Request) (*Handshake, int, http.Header, error) {
+	if req.Method != http.MethodGet || !req.ProtoAtLeast(1, 1) {
+		return nil, http.StatusBadRequest, nil, fmt.Errorf("websocket: invalid handshake request - %s %s", req.Method, req.Proto)
+	}
+	if !hasToken(req.Header, "Connection", "upgrade") || !hasToken(req.Header, "Upgrade", "websocket") {
+		return nil, http.StatusBadRequest, nil, errors.New("websocket: not a websocket upgrade request")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, http.StatusUpgradeRequired, http.Header{"Sec-WebSocket-Version": {"13"}}, fmt.Errorf("websocket: unsupported version %q", req.Header.Get("Sec-WebSocket-Version"))
+	}
+	if key, err := base64.StdEncoding.DecodeString(req.Header.Get("Sec-WebSocket-Key")); err != nil || len(key) != 16 {
+		return nil, http.StatusBadRequest, nil, fmt.Errorf("websocket: invalid Sec-WebSocket-Key %q", req.Header.Get("Sec-WebSocket-Key"))
+	}
+	if u.checkOrigin != nil && !u.checkOrigin(req) {
+		return nil, http.StatusForbidden, nil, fmt.Errorf("websocket: origin %q not allowed", req.Header.Get("Origin"))
+	}
+
+	handshake := &Handshake{Request: req}
+	requested := headerTokens(req.Header, "Sec-WebSocket-Protocol")
+SelectLoop:
+	for _, protocol := range u.subprotocols {
+		for _, r := range requested {
+			if r == protocol {
+				handshake.Subprotocol = protocol
+				break SelectLoop
+			}
+		}
+	}
+
+	if u.compression {
+		for _, ext := range parseExtensions(req.Header) {
+			if ext.name == deflateExtension && acceptableDeflate(ext.params) {
+				handshake.Compression = true
+				break
+			}
+		}
+	}
+	return handshake, 0, nil, nil
+}
+
+// acceptableDeflate reports whether the parameters of a permessage-deflate offer can be accepted.
+// The compressor always uses the full window, so an offer that limits the window of the server is declined.
+func acceptableDeflate(params map[string]string) bool {
+	for name, value := range params {
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+		case "client_max_window_bits":
+			if value != "" {
+				if bits, err := strconv.Atoi(value); err != nil || bits < 8 || bits > 15 {
+					return false
+				}
+			}
+		case "server_max_window_bits":
+			if value != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// reject sends an error response for a failed handshake and closes the connection. It returns err.
+func (u *Upgrader) reject(ctx *net.SoContext, status int, header http.Header, err error) error {
+	text := err.Error()
+	var resp bytes.Buffer
+	fmt.Fprintf(&resp, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	for name, values := range header {
+		resp.WriteString(name + ": " + strings.Join(values, ", ") + "\r\n")
+	}
+	fmt.Fprintf(&resp, "Connection: close\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\n\r\n%s", len(text), text)
+
+	ctx.Write(newBufferOf(resp.Bytes()))
+	ctx.FlushAndClose()
+	return err
+}
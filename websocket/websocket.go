@@ -0,0 +1,196 @@
+// Package websocket provides WebSocket (RFC 6455) handlers that work on the pipeline of package net.
+// Upgrader performs the server side opening handshake on a TCPServer or TLSServer,
+// and then replaces itself with a Codec that decodes and encodes the frames of the connection.
+// ClientHandshaker does the same on the client side for a TCPClient or TLSClient.
+//
+// Codec passes each complete text message to the next handler as a string and each binary message as a []byte,
+// so the codecs of package net such as JSONCodec can be placed after it.
+// Outbound strings are sent as text messages, and []byte and *net.Buffer as binary messages by default.
+// *Message can be written to send a message of a specific type such as a ping or a close message.
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shanpark/net"
+)
+
+// Message types defined by RFC 6455.
+const (
+	ContinuationMessage = 0
+	TextMessage         = 1
+	BinaryMessage       = 2
+	CloseMessage        = 8
+	PingMessage         = 9
+	PongMessage         = 10
+)
+
+// Close status codes defined by RFC 6455.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+// DefaultMaxMessageSize is the default maximum size of a received message.
+const DefaultMaxMessageSize = 16 * 1024 * 1024
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const maxControlPayloadSize = 125
+
+// ErrCloseSent is returned when a message is written after the close message has been sent.
+var ErrCloseSent = errors.New("websocket: close message already sent")
+
+// Message is a WebSocket message with its type.
+type Message struct {
+	Type int
+	Data []byte
+}
+
+// CloseError is returned when the connection is closed because of an invalid frame or message.
+// Code is the status code sent to the peer in the close message.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d - %s", e.Code, e.Text)
+}
+
+// Handshake is the result of the opening handshake of a connection.
+type Handshake struct {
+	Request     *http.Request  // request of the handshake.
+	Response    *http.Response // response of the handshake. It is set on the client side only.
+	Subprotocol string         // negotiated subprotocol. It is empty if no subprotocol is negotiated.
+	Compression bool           // whether permessage-deflate is negotiated.
+}
+
+type handshakeKey struct{}
+
+// HandshakeOf returns the result of the opening handshake of the connection of ctx.
+// It returns nil until the handshake is completed.
+func HandshakeOf(ctx *net.SoContext) *Handshake {
+	handshake, _ := ctx.Value(handshakeKey{}).(*Handshake)
+	return handshake
+}
+
+// FormatCloseMessage returns the payload of a close message with status code and text.
+func FormatCloseMessage(code int, text string) []byte {
+	payload := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], text)
+	return payload
+}
+
+// ParseCloseMessage returns the status code and the text of the payload of a close message.
+// If the payload is empty, it returns CloseNoStatusReceived.
+func ParseCloseMessage(payload []byte) (int, string) {
+	if len(payload) < 2 {
+		return CloseNoStatusReceived, ""
+	}
+	return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+}
+
+// validateClosePayload checks the payload of a received close message.
+func validateClosePayload(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	if len(payload) == 1 {
+		return &CloseError{CloseProtocolError, "invalid close payload"}
+	}
+	code, text := ParseCloseMessage(payload)
+	if !validCloseCode(code) {
+		return &CloseError{CloseProtocolError, fmt.Sprintf("invalid close code %d", code)}
+	}
+	if !utf8.ValidString(text) {
+		return &CloseError{CloseInvalidFramePayloadData, "invalid UTF-8 in close reason"}
+	}
+	return nil
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// acceptKey returns the value of Sec-WebSocket-Accept for the value of Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerTokens returns the comma separated tokens of all values of header field name.
+func headerTokens(header http.Header, name string) []string {
+	var tokens []string
+	for _, value := range header.Values(name) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// hasToken reports whether header field name contains token. Tokens are compared case-insensitively.
+func hasToken(header http.Header, name string, token string) bool {
+	for _, t := range headerTokens(header, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// extension is an element of Sec-WebSocket-Extensions.
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+func parseExtensions(header http.Header) []extension {
+	var extensions []extension
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, element := range strings.Split(value, ",") {
+			parts := strings.Split(element, ";")
+			ext := extension{strings.ToLower(strings.TrimSpace(parts[0])), make(map[string]string)}
+			if ext.name == "" {
+				continue
+			}
+			for _, param := range parts[1:] {
+				name, value := param, ""
+				if eq := strings.IndexByte(param, '='); eq >= 0 {
+					name, value = param[:eq], strings.Trim(strings.TrimSpace(param[eq+1:]), `"`)
+				}
+				ext.params[strings.ToLower(strings.TrimSpace(name))] = value
+			}
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
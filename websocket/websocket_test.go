@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/shanpark/net"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// example of RFC 6455 section 1.3
+	if key := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); key != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey() = %s", key)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	client := NewCodec(true)
+	server := NewCodec(false)
+
+	for _, size := range []int{0, 125, 126, 65535, 65536} {
+		payload := bytes.Repeat([]byte{'a'}, size)
+		frame := client.frame(BinaryMessage, false, payload)
+		fin, _, opcode, decoded, n, err := server.readFrame(frame)
+		if err != nil || !fin || opcode != BinaryMessage || n != len(frame) || !bytes.Equal(decoded, payload) {
+			t.Errorf("size %d: fin=%v opcode=%d n=%d err=%v", size, fin, opcode, n, err)
+		}
+		if _, _, _, _, _, err = server.readFrame(frame[:len(frame)-1]); size > 0 && err != errIncomplete {
+			t.Errorf("size %d: partial frame err=%v", size, err)
+		}
+	}
+
+	// frames from the server must not be masked.
+	if _, _, _, _, _, err := client.readFrame(client.frame(TextMessage, false, []byte("x"))); err == nil {
+		t.Error("masked frame accepted by client")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	codec := NewCodec(false)
+	message := bytes.Repeat([]byte("hello websocket "), 100)
+	compressed, err := compress(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := codec.decompress(compressed)
+	if err != nil || !bytes.Equal(decompressed, message) {
+		t.Errorf("decompress() = %d bytes, %v", len(decompressed), err)
+	}
+
+	codec.SetMaxMessageSize(100)
+	if _, err = codec.decompress(compressed); err == nil || err.(*CloseError).Code != CloseMessageTooBig {
+		t.Errorf("decompress() error = %v", err)
+	}
+}
+
+// handshakeResponse returns the response to the handshake request in the outbound of p.
+func handshakeResponse(t *testing.T, p *net.EmbeddedPipeline, header string) string {
+	sent, ok := p.ReadOutbound().(*net.Buffer)
+	if !ok {
+		t.Fatal("no handshake request")
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(sent.Data())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.Path != "/chat" || req.Host != "example.com" || req.Header.Get("Upgrade") != "websocket" || req.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("handshake request = %v", req)
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	return "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n" + header + "\r\n"
+}
+
+func TestClientHandshaker(t *testing.T) {
+	handshaker, err := NewClientHandshaker("ws://example.com/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshaker.SetSubprotocols("chat", "superchat")
+	p, _ := net.NewEmbeddedPipeline(handshaker)
+	resp := handshakeResponse(t, p, "Sec-WebSocket-Protocol: chat\r\n")
+
+	// messages written before the handshake completes are held.
+	p.WriteOutbound([]byte("early"))
+	if msg := p.ReadOutbound(); msg != nil {
+		t.Fatalf("message sent before handshake - %v", msg)
+	}
+
+	server := NewCodec(false)
+	inbound := append([]byte(resp), server.frame(TextMessage, false, []byte("hello"))...)
+	p.WriteInbound(inbound[:10])
+	if err = p.WriteInbound(inbound[10:]); err != nil {
+		t.Fatal(err)
+	}
+	if handshake := HandshakeOf(p.Context()); handshake == nil || handshake.Subprotocol != "chat" || handshake.Compression {
+		t.Errorf("HandshakeOf() = %v", handshake)
+	}
+	if msg := p.ReadInbound(); msg != "hello" {
+		t.Errorf("ReadInbound() = %v", msg)
+	}
+
+	frame, ok := p.ReadOutbound().(*net.Buffer)
+	if !ok {
+		t.Fatal("held message is not sent")
+	}
+	fin, _, opcode, payload, _, err := server.readFrame(frame.Data())
+	if err != nil || !fin || opcode != BinaryMessage || string(payload) != "early" {
+		t.Errorf("held message = %d %q, %v", opcode, payload, err)
+	}
+}
+
+func TestClientHandshakerFailure(t *testing.T) {
+	for _, header := range []string{"Sec-WebSocket-Protocol: other\r\n", "Sec-WebSocket-Extensions: permessage-deflate\r\n"} {
+		handshaker, _ := NewClientHandshaker("ws://example.com/chat")
+		handshaker.SetSubprotocols("chat")
+		p, _ := net.NewEmbeddedPipeline(handshaker)
+		if err := p.WriteInbound(handshakeResponse(t, p, header)); err == nil || !p.IsClosed() {
+			t.Errorf("%q: WriteInbound() = %v, closed %v", header, err, p.IsClosed())
+		}
+	}
+
+	handshaker, _ := NewClientHandshaker("ws://example.com/chat")
+	p, _ := net.NewEmbeddedPipeline(handshaker)
+	handshakeResponse(t, p, "")
+	if err := p.WriteInbound("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: x\r\n\r\n"); err == nil || !p.IsClosed() {
+		t.Errorf("WriteInbound() of invalid accept key = %v, closed %v", err, p.IsClosed())
+	}
+
+	if _, err := NewClientHandshaker("ftp://example.com/"); err == nil {
+		t.Error("unsupported scheme accepted")
+	}
+}